@@ -0,0 +1,241 @@
+package bam
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sort"
+)
+
+const (
+	// pseudo bin holding per reference metadata rather than chunks
+	baiMetaBin = 37450
+	// each linear index entry covers 16 kb
+	baiLinearShift = 14
+)
+
+var ErrNotBai = errors.New("not a bai index")
+
+type (
+	Chunk struct {
+		Begin uint64
+		End   uint64
+	}
+
+	refIndex struct {
+		bins   map[uint32][]*Chunk
+		linear []uint64
+		mapped uint64
+	}
+
+	// Index is a parsed bai index
+	Index struct {
+		refs []*refIndex
+	}
+)
+
+func ReadIndexFile(path string) (*Index, error) {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	return ReadIndex(bufio.NewReader(f))
+}
+
+func ReadIndex(r io.Reader) (*Index, error) {
+	magic := make([]byte, 4)
+
+	_, err := io.ReadFull(r, magic)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if string(magic) != "BAI\x01" {
+		return nil, ErrNotBai
+	}
+
+	var nRef int32
+
+	err = binary.Read(r, binary.LittleEndian, &nRef)
+
+	if err != nil {
+		return nil, err
+	}
+
+	index := Index{refs: make([]*refIndex, 0, nRef)}
+
+	for i := int32(0); i < nRef; i++ {
+		ref := refIndex{bins: make(map[uint32][]*Chunk)}
+
+		var nBin int32
+
+		err = binary.Read(r, binary.LittleEndian, &nBin)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for j := int32(0); j < nBin; j++ {
+			var bin uint32
+			var nChunk int32
+
+			err = binary.Read(r, binary.LittleEndian, &bin)
+
+			if err != nil {
+				return nil, err
+			}
+
+			err = binary.Read(r, binary.LittleEndian, &nChunk)
+
+			if err != nil {
+				return nil, err
+			}
+
+			chunks := make([]uint64, 2*nChunk)
+
+			err = binary.Read(r, binary.LittleEndian, chunks)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if bin == baiMetaBin {
+				// second pseudo chunk holds mapped and unmapped counts
+				if nChunk == 2 {
+					ref.mapped = chunks[2]
+				}
+
+				continue
+			}
+
+			for k := 0; k < int(nChunk); k++ {
+				ref.bins[bin] = append(ref.bins[bin], &Chunk{Begin: chunks[2*k], End: chunks[2*k+1]})
+			}
+		}
+
+		var nIntv int32
+
+		err = binary.Read(r, binary.LittleEndian, &nIntv)
+
+		if err != nil {
+			return nil, err
+		}
+
+		ref.linear = make([]uint64, nIntv)
+
+		err = binary.Read(r, binary.LittleEndian, ref.linear)
+
+		if err != nil {
+			return nil, err
+		}
+
+		index.refs = append(index.refs, &ref)
+	}
+
+	return &index, nil
+}
+
+// Mapped returns the number of mapped reads on a reference as recorded
+// by the index, or 0 if the index does not store it.
+func (index *Index) Mapped(refId int) uint64 {
+	if refId < 0 || refId >= len(index.refs) {
+		return 0
+	}
+
+	return index.refs[refId].mapped
+}
+
+// TotalMapped returns the number of mapped reads across all references.
+func (index *Index) TotalMapped() uint64 {
+	var ret uint64
+
+	for _, ref := range index.refs {
+		ret += ref.mapped
+	}
+
+	return ret
+}
+
+// Chunks returns the merged chunks of the file that may contain reads
+// overlapping the 0-based half open region [start, end) of a reference.
+func (index *Index) Chunks(refId int, start int, end int) []*Chunk {
+	if refId < 0 || refId >= len(index.refs) {
+		return []*Chunk{}
+	}
+
+	ref := index.refs[refId]
+
+	// reads ending before the start cannot be in chunks before the
+	// linear index entry of the start
+	var minOffset uint64
+
+	li := start >> baiLinearShift
+
+	if li < len(ref.linear) {
+		minOffset = ref.linear[li]
+	} else if len(ref.linear) > 0 {
+		minOffset = ref.linear[len(ref.linear)-1]
+	}
+
+	chunks := make([]*Chunk, 0, 10)
+
+	for _, bin := range reg2bins(start, end) {
+		for _, chunk := range ref.bins[bin] {
+			if chunk.End > minOffset {
+				chunks = append(chunks, chunk)
+			}
+		}
+	}
+
+	sort.Slice(chunks, func(i, j int) bool {
+		return chunks[i].Begin < chunks[j].Begin
+	})
+
+	// merge overlapping chunks so each part of the file is read once
+	ret := make([]*Chunk, 0, len(chunks))
+
+	for _, chunk := range chunks {
+		n := len(ret)
+
+		if n > 0 && chunk.Begin <= ret[n-1].End {
+			ret[n-1].End = max(ret[n-1].End, chunk.End)
+			continue
+		}
+
+		ret = append(ret, &Chunk{Begin: max(chunk.Begin, minOffset), End: chunk.End})
+	}
+
+	return ret
+}
+
+// reg2bins lists the bins that may overlap [start, end) as described in
+// the SAM specification.
+func reg2bins(start int, end int) []uint32 {
+	end--
+
+	ret := []uint32{0}
+
+	for _, level := range []struct {
+		offset int
+		shift  int
+	}{
+		{1, 26},
+		{9, 23},
+		{73, 20},
+		{585, 17},
+		{4681, 14},
+	} {
+		for k := level.offset + (start >> level.shift); k <= level.offset+(end>>level.shift); k++ {
+			ret = append(ret, uint32(k))
+		}
+	}
+
+	return ret
+}
@@ -0,0 +1,407 @@
+package bam
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	FlagPaired        = 0x1
+	FlagUnmapped      = 0x4
+	FlagRead1         = 0x40
+	FlagSecondary     = 0x100
+	FlagDuplicate     = 0x400
+	FlagSupplementary = 0x800
+)
+
+var (
+	ErrNotBam      = errors.New("not a bam file")
+	ErrChrNotFound = errors.New("chromosome not found")
+	ErrBadRecord   = errors.New("invalid bam record")
+)
+
+type (
+	Ref struct {
+		Name string
+		Len  int
+	}
+
+	// Record holds the fields of an alignment needed for binning.
+	// Positions are 0-based.
+	Record struct {
+		RefId     int
+		Pos       int
+		Flag      uint16
+		NextRefId int
+		NextPos   int
+		TLen      int
+		// number of reference bases covered by the alignment
+		RefLen int
+		SeqLen int
+	}
+
+	// Reader reads alignments from a coordinate sorted bam file using
+	// its bai index.
+	Reader struct {
+		f     *os.File
+		index *Index
+		refs  []*Ref
+		// lookup of chr name to ref id
+		refIds map[string]int
+		// virtual offset of the first alignment
+		dataOffset uint64
+	}
+)
+
+// Open opens a bam and its index. If indexPath is empty, the index is
+// found with IndexPath.
+func Open(path string, indexPath string) (*Reader, error) {
+	if indexPath == "" {
+		indexPath = IndexPath(path)
+	}
+
+	index, err := ReadIndexFile(indexPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return OpenWithIndex(path, index)
+}
+
+// IndexPath is where the index of a bam is, which is path.bai if it
+// exists and otherwise the path with .bam replaced by .bai.
+func IndexPath(path string) string {
+	indexPath := path + ".bai"
+
+	_, err := os.Stat(indexPath)
+
+	if err != nil {
+		indexPath = strings.TrimSuffix(path, ".bam") + ".bai"
+	}
+
+	return indexPath
+}
+
+// OpenWithIndex opens a bam using an index that has already been read,
+// so that callers can keep indexes between queries.
+func OpenWithIndex(path string, index *Index) (*Reader, error) {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	reader := &Reader{f: f, index: index, refIds: make(map[string]int)}
+
+	err = reader.readHeader()
+
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return reader, nil
+}
+
+func (reader *Reader) Close() error {
+	return reader.f.Close()
+}
+
+func (reader *Reader) Index() *Index {
+	return reader.index
+}
+
+func (reader *Reader) Refs() []*Ref {
+	return reader.refs
+}
+
+// RefId returns the reference id of a chromosome. Since bams do not
+// always use the chr prefix, names are also matched with and without it.
+func (reader *Reader) RefId(chr string) (int, error) {
+	id, ok := reader.refIds[chr]
+
+	if ok {
+		return id, nil
+	}
+
+	alt := strings.TrimPrefix(chr, "chr")
+
+	if alt == chr {
+		alt = "chr" + chr
+	}
+
+	id, ok = reader.refIds[alt]
+
+	if ok {
+		return id, nil
+	}
+
+	return -1, fmt.Errorf("%w: %s", ErrChrNotFound, chr)
+}
+
+func (reader *Reader) readHeader() error {
+	br := newBgzfReader(reader.f)
+
+	err := br.Seek(0)
+
+	if err != nil {
+		return err
+	}
+
+	magic := make([]byte, 4)
+
+	_, err = io.ReadFull(br, magic)
+
+	if err != nil {
+		return err
+	}
+
+	if string(magic) != "BAM\x01" {
+		return ErrNotBam
+	}
+
+	var lText int32
+
+	err = binary.Read(br, binary.LittleEndian, &lText)
+
+	if err != nil {
+		return err
+	}
+
+	// skip the sam header text
+	_, err = io.CopyN(io.Discard, br, int64(lText))
+
+	if err != nil {
+		return err
+	}
+
+	var nRef int32
+
+	err = binary.Read(br, binary.LittleEndian, &nRef)
+
+	if err != nil {
+		return err
+	}
+
+	reader.refs = make([]*Ref, 0, nRef)
+
+	for i := int32(0); i < nRef; i++ {
+		var lName int32
+
+		err = binary.Read(br, binary.LittleEndian, &lName)
+
+		if err != nil {
+			return err
+		}
+
+		name := make([]byte, lName)
+
+		_, err = io.ReadFull(br, name)
+
+		if err != nil {
+			return err
+		}
+
+		var lRef int32
+
+		err = binary.Read(br, binary.LittleEndian, &lRef)
+
+		if err != nil {
+			return err
+		}
+
+		ref := Ref{Name: strings.TrimRight(string(name), "\x00"), Len: int(lRef)}
+
+		reader.refIds[ref.Name] = len(reader.refs)
+		reader.refs = append(reader.refs, &ref)
+	}
+
+	reader.dataOffset = br.VirtualOffset()
+
+	return nil
+}
+
+// Span returns the 0-based start and length of the region a record
+// covers. Paired reads cover the whole fragment and are only reported
+// for the first mate so each fragment is counted once. Records that
+// should not be counted, such as unmapped or secondary alignments,
+// return false.
+func (record *Record) Span(paired bool) (int, int, bool) {
+	if record.Flag&(FlagUnmapped|FlagSecondary|FlagSupplementary) != 0 {
+		return 0, 0, false
+	}
+
+	if paired {
+		// ignore pairs whose mates are on different chromosomes
+		if record.Flag&FlagRead1 == 0 || record.TLen == 0 {
+			return 0, 0, false
+		}
+
+		return min(record.Pos, record.NextPos), max(record.TLen, -record.TLen), true
+	}
+
+	length := record.RefLen
+
+	if length == 0 {
+		length = record.SeqLen
+	}
+
+	return record.Pos, length, length > 0
+}
+
+// All calls fn for every alignment in the file in file order.
+func (reader *Reader) All(fn func(record *Record) error) error {
+	br := newBgzfReader(reader.f)
+
+	err := br.Seek(reader.dataOffset)
+
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, 0, 1024)
+
+	for {
+		var record Record
+
+		buf, err = readRecord(br, buf, &record)
+
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			return err
+		}
+
+		err = fn(&record)
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Query calls fn for every alignment on chr whose alignment start lies
+// before end and which may overlap the 0-based half open region
+// [start, end). Reads are visited in coordinate order. Reading stops
+// between chunks if ctx is cancelled.
+func (reader *Reader) Query(ctx context.Context, chr string, start int, end int, fn func(record *Record) error) error {
+	refId, err := reader.RefId(chr)
+
+	if err != nil {
+		return err
+	}
+
+	br := newBgzfReader(reader.f)
+
+	buf := make([]byte, 0, 1024)
+
+	for _, chunk := range reader.index.Chunks(refId, start, end) {
+		err = ctx.Err()
+
+		if err != nil {
+			return err
+		}
+
+		err = br.Seek(chunk.Begin)
+
+		if err != nil {
+			return err
+		}
+
+		for br.VirtualOffset() < chunk.End {
+			var record Record
+
+			buf, err = readRecord(br, buf, &record)
+
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					break
+				}
+
+				return err
+			}
+
+			if record.RefId != refId || record.Pos >= end {
+				// reads are sorted so nothing else in this chunk can overlap
+				break
+			}
+
+			err = fn(&record)
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// readRecord decodes the next alignment, reusing buf for the raw bytes
+func readRecord(r io.Reader, buf []byte, record *Record) ([]byte, error) {
+	var blockSize int32
+
+	err := binary.Read(r, binary.LittleEndian, &blockSize)
+
+	if err != nil {
+		return buf, err
+	}
+
+	// fixed fields take 32 bytes
+	if blockSize < 32 {
+		return buf, ErrBadRecord
+	}
+
+	if cap(buf) < int(blockSize) {
+		buf = make([]byte, blockSize)
+	}
+
+	buf = buf[:blockSize]
+
+	_, err = io.ReadFull(r, buf)
+
+	if err != nil {
+		return buf, err
+	}
+
+	le := binary.LittleEndian
+
+	record.RefId = int(int32(le.Uint32(buf)))
+	record.Pos = int(int32(le.Uint32(buf[4:])))
+	lReadName := int(buf[8])
+	// mapq at 9, bin at 10
+	nCigarOp := int(le.Uint16(buf[12:]))
+	record.Flag = le.Uint16(buf[14:])
+	record.SeqLen = int(int32(le.Uint32(buf[16:])))
+	record.NextRefId = int(int32(le.Uint32(buf[20:])))
+	record.NextPos = int(int32(le.Uint32(buf[24:])))
+	record.TLen = int(int32(le.Uint32(buf[28:])))
+
+	cigarStart := 32 + lReadName
+
+	if cigarStart+4*nCigarOp > len(buf) {
+		return buf, ErrBadRecord
+	}
+
+	record.RefLen = 0
+
+	for i := 0; i < nCigarOp; i++ {
+		op := le.Uint32(buf[cigarStart+4*i:])
+
+		switch op & 0xf {
+		// M, D, N, = and X consume the reference
+		case 0, 2, 3, 7, 8:
+			record.RefLen += int(op >> 4)
+		}
+	}
+
+	return buf, nil
+}
@@ -0,0 +1,177 @@
+package bam
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	bgzfHeaderBytes = 12
+	// largest possible bgzf block
+	bgzfMaxBlockBytes = 65536
+)
+
+var ErrBadBgzfBlock = errors.New("invalid bgzf block")
+
+// bgzfReader reads the decompressed stream of a bgzf file starting at a
+// virtual offset. Virtual offsets store the compressed block offset in
+// the upper 48 bits and the offset within the decompressed block in the
+// lower 16 bits.
+type bgzfReader struct {
+	r io.ReaderAt
+
+	// compressed offset of the current block and the next one
+	blockOffset uint64
+	nextOffset  uint64
+
+	block []byte
+	pos   int
+}
+
+func newBgzfReader(r io.ReaderAt) *bgzfReader {
+	return &bgzfReader{r: r}
+}
+
+// Seek positions the reader at a virtual offset.
+func (br *bgzfReader) Seek(voffset uint64) error {
+	coffset := voffset >> 16
+	uoffset := int(voffset & 0xffff)
+
+	if br.block == nil || coffset != br.blockOffset {
+		err := br.readBlock(coffset)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if uoffset > len(br.block) {
+		return ErrBadBgzfBlock
+	}
+
+	br.pos = uoffset
+
+	return nil
+}
+
+// VirtualOffset returns the virtual offset of the next byte to be read.
+func (br *bgzfReader) VirtualOffset() uint64 {
+	// at the end of a block the next byte is the start of the next block
+	if br.pos >= len(br.block) {
+		return br.nextOffset << 16
+	}
+
+	return br.blockOffset<<16 | uint64(br.pos)
+}
+
+func (br *bgzfReader) Read(p []byte) (int, error) {
+	n := 0
+
+	for n < len(p) {
+		if br.pos >= len(br.block) {
+			err := br.readBlock(br.nextOffset)
+
+			if err != nil {
+				if n > 0 && errors.Is(err, io.EOF) {
+					return n, nil
+				}
+
+				return n, err
+			}
+
+			// empty blocks, such as the eof marker, have nothing to read
+			if len(br.block) == 0 {
+				continue
+			}
+		}
+
+		c := copy(p[n:], br.block[br.pos:])
+		br.pos += c
+		n += c
+	}
+
+	return n, nil
+}
+
+func (br *bgzfReader) readBlock(offset uint64) error {
+	header := make([]byte, bgzfHeaderBytes)
+
+	n, err := br.r.ReadAt(header, int64(offset))
+
+	if n < bgzfHeaderBytes {
+		if err == nil || errors.Is(err, io.EOF) {
+			return io.EOF
+		}
+
+		return err
+	}
+
+	if header[0] != 31 || header[1] != 139 || header[3]&4 == 0 {
+		return ErrBadBgzfBlock
+	}
+
+	xlen := int(binary.LittleEndian.Uint16(header[10:]))
+
+	extra := make([]byte, xlen)
+
+	_, err = br.r.ReadAt(extra, int64(offset)+bgzfHeaderBytes)
+
+	if err != nil {
+		return err
+	}
+
+	// find the BC subfield which holds the total block size - 1
+	blockSize := 0
+
+	for i := 0; i+4 <= len(extra); {
+		slen := int(binary.LittleEndian.Uint16(extra[i+2:]))
+
+		if extra[i] == 'B' && extra[i+1] == 'C' && slen == 2 {
+			blockSize = int(binary.LittleEndian.Uint16(extra[i+4:])) + 1
+			break
+		}
+
+		i += 4 + slen
+	}
+
+	if blockSize == 0 || blockSize > bgzfMaxBlockBytes {
+		return ErrBadBgzfBlock
+	}
+
+	data := make([]byte, blockSize)
+
+	n, err = br.r.ReadAt(data, int64(offset))
+
+	if n < blockSize {
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return err
+	}
+
+	// deflate data sits between the header and the 8 byte crc/isize footer
+	cdata := data[bgzfHeaderBytes+xlen : blockSize-8]
+	isize := int(binary.LittleEndian.Uint32(data[blockSize-4:]))
+
+	block := make([]byte, isize)
+
+	fr := flate.NewReader(bytes.NewReader(cdata))
+	defer fr.Close()
+
+	_, err = io.ReadFull(fr, block)
+
+	if err != nil {
+		return err
+	}
+
+	br.block = block
+	br.blockOffset = offset
+	br.nextOffset = offset + uint64(blockSize)
+	br.pos = 0
+
+	return nil
+}
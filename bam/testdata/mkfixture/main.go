@@ -0,0 +1,278 @@
+// mkfixture writes fixture.bam and fixture.bam.bai, a small coordinate
+// sorted bam and its index, for the tests of bam readers. Run it from
+// the bam directory with
+//
+//	go run ./testdata/mkfixture
+//
+// chr1 holds paired reads, including a fragment whose first mate is the
+// downstream read, and chr2 holds single end reads. Each chromosome has
+// its own bgzf block and all of its chunks are in bin 0.
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"hash/crc32"
+	"log"
+	"os"
+)
+
+const (
+	flagPaired       = 0x1
+	flagProperPair   = 0x2
+	flagUnmapped     = 0x4
+	flagReverse      = 0x10
+	flagMateReverse  = 0x20
+	flagRead1        = 0x40
+	flagRead2        = 0x80
+	flagSecondary    = 0x100
+	baiMetaBin       = 37450
+	baiLinearWindow  = 1 << 14
+	cigarMatch       = 0
+	cigarDeletion    = 2
+	forwardRead1     = flagPaired | flagProperPair | flagMateReverse | flagRead1
+	reverseRead2     = flagPaired | flagProperPair | flagReverse | flagRead2
+	forwardRead2     = flagPaired | flagProperPair | flagMateReverse | flagRead2
+	reverseRead1     = flagPaired | flagProperPair | flagReverse | flagRead1
+	unpairedUnmapped = flagUnmapped
+)
+
+var order = binary.LittleEndian
+
+type (
+	ref struct {
+		name    string
+		size    int32
+		records []record
+	}
+
+	cigarOp struct {
+		op  uint32
+		len uint32
+	}
+
+	record struct {
+		name    string
+		pos     int32
+		flag    uint16
+		nextPos int32
+		tlen    int32
+		cigar   []cigarOp
+	}
+)
+
+func match(n uint32) []cigarOp {
+	return []cigarOp{{cigarMatch, n}}
+}
+
+var refs = []ref{
+	{"chr1", 10000, []record{
+		// fragment a is 100-300
+		{"a", 100, forwardRead1, 250, 200, match(50)},
+		{"a", 250, reverseRead2, 100, -200, match(50)},
+		// fragment b is 900-1150 and its first mate is downstream
+		{"b", 900, forwardRead2, 1100, 250, match(50)},
+		{"b", 1100, reverseRead1, 900, -250, match(50)},
+		// not counted
+		{"s", 1200, forwardRead1 | flagSecondary, 1300, 150, match(50)},
+		// fragment c is 5000-5150
+		{"c", 5000, forwardRead1, 5100, 150, match(50)},
+		{"c", 5100, reverseRead2, 5000, -150, match(50)},
+	}},
+	{"chr2", 5000, []record{
+		{"d", 10, 0, -1, 0, match(50)},
+		// covers 990-1045
+		{"e", 990, 0, -1, 0, []cigarOp{{cigarMatch, 20}, {cigarDeletion, 5}, {cigarMatch, 30}}},
+		// placed but unmapped
+		{"u", 2000, unpairedUnmapped, -1, 0, nil},
+	}},
+}
+
+func refLen(cigar []cigarOp) int32 {
+	var ret int32
+
+	for _, c := range cigar {
+		switch c.op {
+		case cigarMatch, cigarDeletion:
+			ret += int32(c.len)
+		}
+	}
+
+	return ret
+}
+
+// reg2bin is the bin of an alignment as given in the SAM specification
+func reg2bin(start int32, end int32) uint16 {
+	end--
+
+	switch {
+	case start>>14 == end>>14:
+		return uint16(((1<<15)-1)/7 + (start >> 14))
+	case start>>17 == end>>17:
+		return uint16(((1<<12)-1)/7 + (start >> 17))
+	case start>>20 == end>>20:
+		return uint16(((1<<9)-1)/7 + (start >> 20))
+	case start>>23 == end>>23:
+		return uint16(((1<<6)-1)/7 + (start >> 23))
+	case start>>26 == end>>26:
+		return uint16(((1<<3)-1)/7 + (start >> 26))
+	default:
+		return 0
+	}
+}
+
+func writeRecord(buf *bytes.Buffer, refId int32, r record) {
+	var data bytes.Buffer
+
+	seqLen := int32(0)
+
+	for _, c := range r.cigar {
+		if c.op == cigarMatch {
+			seqLen += int32(c.len)
+		}
+	}
+
+	nextRefId := refId
+
+	if r.nextPos < 0 {
+		nextRefId = -1
+	}
+
+	end := r.pos + max(1, refLen(r.cigar))
+
+	binary.Write(&data, order, refId)
+	binary.Write(&data, order, r.pos)
+	binary.Write(&data, order, uint8(len(r.name)+1))
+	// mapq
+	binary.Write(&data, order, uint8(60))
+	binary.Write(&data, order, reg2bin(r.pos, end))
+	binary.Write(&data, order, uint16(len(r.cigar)))
+	binary.Write(&data, order, r.flag)
+	binary.Write(&data, order, seqLen)
+	binary.Write(&data, order, nextRefId)
+	binary.Write(&data, order, r.nextPos)
+	binary.Write(&data, order, r.tlen)
+	data.WriteString(r.name)
+	data.WriteByte(0)
+
+	for _, c := range r.cigar {
+		binary.Write(&data, order, c.len<<4|c.op)
+	}
+
+	// seq as all A and quality unavailable
+	data.Write(bytes.Repeat([]byte{0x11}, int(seqLen+1)/2))
+	data.Write(bytes.Repeat([]byte{0xff}, int(seqLen)))
+
+	binary.Write(buf, order, int32(data.Len()))
+	buf.Write(data.Bytes())
+}
+
+// bgzfBlock compresses data into a single bgzf block
+func bgzfBlock(data []byte) []byte {
+	var cdata bytes.Buffer
+
+	w, err := flate.NewWriter(&cdata, flate.BestCompression)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w.Write(data)
+	w.Close()
+
+	var block bytes.Buffer
+
+	// gzip header with the BC extra subfield
+	block.Write([]byte{31, 139, 8, 4, 0, 0, 0, 0, 0, 255})
+	binary.Write(&block, order, uint16(6))
+	block.Write([]byte{'B', 'C'})
+	binary.Write(&block, order, uint16(2))
+	binary.Write(&block, order, uint16(18+cdata.Len()+8-1))
+	block.Write(cdata.Bytes())
+	binary.Write(&block, order, crc32.ChecksumIEEE(data))
+	binary.Write(&block, order, uint32(len(data)))
+
+	return block.Bytes()
+}
+
+func main() {
+	var bamFile bytes.Buffer
+
+	// header block
+	var header bytes.Buffer
+
+	text := "@HD\tVN:1.6\tSO:coordinate\n"
+
+	header.WriteString("BAM\x01")
+	binary.Write(&header, order, int32(len(text)))
+	header.WriteString(text)
+	binary.Write(&header, order, int32(len(refs)))
+
+	for _, r := range refs {
+		binary.Write(&header, order, int32(len(r.name)+1))
+		header.WriteString(r.name)
+		header.WriteByte(0)
+		binary.Write(&header, order, r.size)
+	}
+
+	bamFile.Write(bgzfBlock(header.Bytes()))
+
+	var bai bytes.Buffer
+
+	bai.WriteString("BAI\x01")
+	binary.Write(&bai, order, int32(len(refs)))
+
+	for i, r := range refs {
+		var records bytes.Buffer
+
+		var mapped uint64
+		var unmapped uint64
+
+		for _, rec := range r.records {
+			writeRecord(&records, int32(i), rec)
+
+			if rec.flag&flagUnmapped != 0 {
+				unmapped++
+			} else {
+				mapped++
+			}
+		}
+
+		begin := uint64(bamFile.Len()) << 16
+		bamFile.Write(bgzfBlock(records.Bytes()))
+		end := uint64(bamFile.Len()) << 16
+
+		// bin 0 with one chunk and the pseudo bin with the read counts
+		binary.Write(&bai, order, int32(2))
+		binary.Write(&bai, order, uint32(0))
+		binary.Write(&bai, order, int32(1))
+		binary.Write(&bai, order, []uint64{begin, end})
+		binary.Write(&bai, order, uint32(baiMetaBin))
+		binary.Write(&bai, order, int32(2))
+		binary.Write(&bai, order, []uint64{begin, end, mapped, unmapped})
+
+		nIntv := (int(r.size) + baiLinearWindow - 1) / baiLinearWindow
+
+		binary.Write(&bai, order, int32(nIntv))
+
+		for j := 0; j < nIntv; j++ {
+			binary.Write(&bai, order, begin)
+		}
+	}
+
+	// eof marker
+	bamFile.Write(bgzfBlock(nil))
+
+	err := os.WriteFile("testdata/fixture.bam", bamFile.Bytes(), 0644)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = os.WriteFile("testdata/fixture.bam.bai", bai.Bytes(), 0644)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
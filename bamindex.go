@@ -0,0 +1,146 @@
+package seqs
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/antonybholmes/go-seqs/bam"
+)
+
+const (
+	DefaultBamIndexCacheSize = 64
+)
+
+type (
+	bamIndexEntry struct {
+		path string
+		// versions of the bam and its index when the index was read
+		version    string
+		index      *bam.Index
+		lruElement *list.Element
+	}
+
+	// BamIndexCache keeps the parsed bai indexes of recently used bams so
+	// that they are not read again for every query. An index is read
+	// again if the bam or the index file changes.
+	BamIndexCache struct {
+		maxSize int
+		entries map[string]*bamIndexEntry
+		// front is most recently used
+		lru *list.List
+		mu  sync.Mutex
+	}
+)
+
+var defaultBamIndexCache = NewBamIndexCache(DefaultBamIndexCacheSize)
+
+func NewBamIndexCache(maxSize int) *BamIndexCache {
+	if maxSize < 1 {
+		maxSize = DefaultBamIndexCacheSize
+	}
+
+	return &BamIndexCache{
+		maxSize: maxSize,
+		entries: make(map[string]*bamIndexEntry),
+		lru:     list.New(),
+	}
+}
+
+// Open opens a bam using a cached copy of its index. It also returns
+// the bamIndexVersion of the files.
+func (cache *BamIndexCache) Open(path string) (*bam.Reader, string, error) {
+	index, version, err := cache.Index(path)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	reader, err := bam.OpenWithIndex(path, index)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	return reader, version, nil
+}
+
+// Index returns the parsed index of a bam, reading it only if it is not
+// cached or the files have changed since it was read. It also returns
+// the bamIndexVersion of the files.
+func (cache *BamIndexCache) Index(path string) (*bam.Index, string, error) {
+	indexPath := bam.IndexPath(path)
+
+	version, err := bamIndexVersion(path, indexPath)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	cache.mu.Lock()
+
+	entry, ok := cache.entries[path]
+
+	if ok && entry.version == version {
+		cache.lru.MoveToFront(entry.lruElement)
+		cache.mu.Unlock()
+		return entry.index, version, nil
+	}
+
+	cache.mu.Unlock()
+
+	// indexes can be large so they are read without holding the lock;
+	// two callers may both read a new index, which is harmless
+	index, err := bam.ReadIndexFile(indexPath)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	entry, ok = cache.entries[path]
+
+	if ok {
+		entry.version = version
+		entry.index = index
+		cache.lru.MoveToFront(entry.lruElement)
+		return index, version, nil
+	}
+
+	entry = &bamIndexEntry{path: path, version: version, index: index}
+	entry.lruElement = cache.lru.PushFront(entry)
+	cache.entries[path] = entry
+
+	for cache.lru.Len() > cache.maxSize {
+		back := cache.lru.Back().Value.(*bamIndexEntry)
+		cache.lru.Remove(back.lruElement)
+		delete(cache.entries, back.path)
+	}
+
+	return index, version, nil
+}
+
+func (cache *BamIndexCache) Len() int {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	return cache.lru.Len()
+}
+
+// bamIndexVersion changes if either the bam or its index is replaced
+func bamIndexVersion(path string, indexPath string) (string, error) {
+	bamVersion, err := fileVersion(path)
+
+	if err != nil {
+		return "", err
+	}
+
+	indexVersion, err := fileVersion(indexPath)
+
+	if err != nil {
+		return "", err
+	}
+
+	return bamVersion + "/" + indexVersion, nil
+}
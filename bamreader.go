@@ -0,0 +1,261 @@
+package seqs
+
+import (
+	"context"
+	"math"
+
+	"github.com/antonybholmes/go-dna"
+	"github.com/antonybholmes/go-seqs/bam"
+	"github.com/antonybholmes/go-sys/log"
+	basemath "github.com/antonybholmes/go-sys/math"
+)
+
+const (
+	// paired reads are counted once from the first mate, which may be
+	// either end of the fragment, so we look this far either side of a
+	// location for fragments that may overlap it
+	BamMaxFragmentLength = 2000
+)
+
+// BamSeqReader bins reads directly from a coordinate sorted, bai indexed
+// bam so new libraries can be viewed at any bin size without first being
+// converted to a sample db.
+type BamSeqReader struct {
+	sample  *Sample
+	url     string
+	binSize int
+	stat    string
+}
+
+func NewBamReader(sample *Sample, url string, binSize int, stat string) (SeqReader, error) {
+	return &BamSeqReader{
+		sample:  sample,
+		url:     url,
+		binSize: binSize,
+		stat:    stat,
+	}, nil
+}
+
+// Version changes if the bam or its index is replaced, since either
+// changes the bins
+func (reader *BamSeqReader) Version() (string, error) {
+	return bamIndexVersion(reader.url, bam.IndexPath(reader.url))
+}
+
+func (reader *BamSeqReader) CacheKey(version string) string {
+	return readerCacheKey(reader.sample, version, reader.binSize, reader.stat)
+}
+
+func (reader *BamSeqReader) BinCounts(ctx context.Context, location *dna.Location) (*SampleBinCounts, error) {
+
+	log.Debug().Msgf("binning bam %s for location %s with bin size %d", reader.url, location, reader.binSize)
+
+	// we return something for every call, even if data not available
+	ret := SampleBinCounts{
+		Id:      reader.sample.Id,
+		Bins:    make([]*ReadBin, 0, reader.binSize),
+		YMax:    0,
+		BinSize: reader.binSize,
+		Reads:   reader.sample.Reads,
+		Norm:    NormRaw,
+		Stat:    reader.stat,
+		Status:  SampleStatusOk,
+	}
+
+	chrSize := reader.sample.ChrSize(location.Chr())
+
+	location, err := clampLocation(location, chrSize)
+
+	if err != nil {
+		return &ret, err
+	}
+
+	bamReader, version, err := defaultBamIndexCache.Open(reader.url)
+
+	if err != nil {
+		log.Debug().Msgf("error opening bam %s %s", reader.url, err)
+		return &ret, err
+	}
+
+	defer bamReader.Close()
+
+	ret.version = version
+
+	mapped := int(bamReader.Index().TotalMapped())
+
+	// bams are not in the catalogue with a read count
+	if ret.Reads == 0 {
+		ret.Reads = mapped
+	}
+
+	// the bam knows the length even if the catalogue does not
+	if chrSize == 0 {
+		chrSize = bamChrSize(bamReader, location.Chr())
+
+		location, err = clampLocation(location, chrSize)
+
+		if err != nil {
+			return &ret, err
+		}
+	}
+
+	counts, err := bamBinCounts(ctx, bamReader, location, reader.binSize)
+
+	if err != nil {
+		log.Debug().Msgf("error reading bam %s %s", reader.url, err)
+		return &ret, err
+	}
+
+	// sample dbs scale by the sum of the counts of every bin, which
+	// would mean reading the whole bam, so it is estimated from the
+	// library size and the fragments seen here
+	ret.BinReads = counts.estimateBinReads(mapped, reader.binSize)
+	ret.BpmEstimate = true
+
+	if ret.BinReads > 0 {
+		ret.BpmScaleFactor = 1000000 / float64(ret.BinReads)
+	}
+
+	values := make([]float64, len(counts.counts))
+
+	for i, c := range counts.counts {
+		values[i] = statValue(float64(c), reader.stat)
+	}
+
+	ret.Bins = clipBins(mergeBinCounts(values, counts.startBin, reader.binSize), chrSize)
+
+	for _, bin := range ret.Bins {
+		ret.YMax = basemath.Max(ret.YMax, bin.Count)
+	}
+
+	return &ret, nil
+}
+
+// bamCounts is the reads in each bin of a location
+type bamCounts struct {
+	counts []int
+	// index of the first bin
+	startBin int
+	// reads or fragments counted and the bases they cover
+	fragments int
+	spanned   int
+	paired    bool
+}
+
+// bamBinCounts counts the reads in each genome aligned bin overlapping
+// the location. A read is counted in every bin it spans, in the same way
+// as step1_bamtosql.py.
+func bamBinCounts(ctx context.Context, reader *bam.Reader, location *dna.Location, binSize int) (*bamCounts, error) {
+	start0 := location.Start() - 1
+	end := location.End()
+
+	startBin := start0 / binSize
+	endBin := (end - 1) / binSize
+
+	ret := bamCounts{counts: make([]int, endBin-startBin+1), startBin: startBin}
+
+	// fragments outside the location are dropped when the bins are
+	// clipped below
+	err := reader.Query(ctx, bamChr(reader, location.Chr()), max(0, start0-BamMaxFragmentLength), end+BamMaxFragmentLength, func(record *bam.Record) error {
+		paired := record.Flag&bam.FlagPaired != 0
+
+		start, readLength, ok := record.Span(paired)
+
+		if !ok {
+			return nil
+		}
+
+		ret.fragments++
+		ret.spanned += readLength
+		ret.paired = ret.paired || paired
+
+		sb := max(start/binSize, startBin)
+		eb := min((start+readLength-1)/binSize, endBin)
+
+		for b := sb; b <= eb; b++ {
+			ret.counts[b-startBin]++
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &ret, nil
+}
+
+// estimateBinReads estimates the sum of the counts of every bin of the
+// library from its mapped reads. Paired reads are counted once per
+// fragment and a fragment of length l falls in 1 + (l - 1) / binSize
+// bins on average. The mean fragment length comes from the location,
+// so the estimate is only as good as that sample of the library.
+func (counts *bamCounts) estimateBinReads(mapped int, binSize int) int {
+	fragments := float64(mapped)
+
+	if counts.paired {
+		fragments /= 2
+	}
+
+	if counts.fragments == 0 {
+		return int(fragments)
+	}
+
+	meanSpan := float64(counts.spanned) / float64(counts.fragments)
+
+	return int(math.Round(fragments * (1 + (meanSpan-1)/float64(binSize))))
+}
+
+// mergeBinCounts converts per bin counts into runs of bins with the same
+// count, skipping empty bins, which is how reads are stored in the sample
+// dbs.
+func mergeBinCounts[T int | float64](counts []T, startBin int, binSize int) []*ReadBin {
+	ret := make([]*ReadBin, 0, len(counts))
+
+	for i, c := range counts {
+		if c == 0 {
+			continue
+		}
+
+		b := startBin + i
+		n := len(ret)
+
+		if n > 0 && ret[n-1].End == b*binSize && ret[n-1].Count == float64(c) {
+			ret[n-1].End = (b + 1) * binSize
+			continue
+		}
+
+		// 1-based inclusive coordinates
+		ret = append(ret, &ReadBin{
+			Start: b*binSize + 1,
+			End:   (b + 1) * binSize,
+			Count: float64(c),
+		})
+	}
+
+	return ret
+}
+
+// bamChr is the name a bam uses for a chromosome
+func bamChr(reader *bam.Reader, chr string) string {
+	names := make([]string, 0, len(reader.Refs()))
+
+	for _, ref := range reader.Refs() {
+		names = append(names, ref.Name)
+	}
+
+	return defaultNaming.SourceChr(chr, names)
+}
+
+// bamChrSize is the length of a chromosome in a bam, or 0 if the bam
+// does not have it
+func bamChrSize(reader *bam.Reader, chr string) int {
+	id, err := reader.RefId(bamChr(reader, chr))
+
+	if err != nil {
+		return 0
+	}
+
+	return reader.Refs()[id].Len
+}
@@ -0,0 +1,195 @@
+package seqs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/antonybholmes/go-dna"
+	"github.com/antonybholmes/go-seqs/bam"
+)
+
+// bam/testdata/fixture.bam is written by bam/testdata/mkfixture
+const bamFixture = "bam/testdata/fixture.bam"
+
+func bamBins(t *testing.T, chr string, start int, end int, binSize int) *SampleBinCounts {
+	t.Helper()
+
+	sample := &Sample{Id: "fixture", Url: bamFixture, Type: SampleTypeBam}
+
+	reader, err := NewBamReader(sample, bamFixture, binSize, StatSum)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	location, err := dna.NewLocation(chr, start, end)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts, err := reader.BinCounts(context.Background(), location)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return counts
+}
+
+func checkBins(t *testing.T, bins []*ReadBin, want []ReadBin) {
+	t.Helper()
+
+	if len(bins) != len(want) {
+		t.Fatalf("bins %v, want %v", bins, want)
+	}
+
+	for i, bin := range bins {
+		if *bin != want[i] {
+			t.Errorf("bin %d is %+v, want %+v", i, *bin, want[i])
+		}
+	}
+}
+
+func TestBamBinCountsPaired(t *testing.T) {
+	// fragment b starts in the location but its first mate is after it
+	counts := bamBins(t, "chr1", 1, 1000, 1000)
+
+	checkBins(t, counts.Bins, []ReadBin{{Start: 1, End: 1000, Count: 2}})
+
+	// b spans two bins and c is counted once from its first mate
+	counts = bamBins(t, "chr1", 1, 10000, 1000)
+
+	checkBins(t, counts.Bins, []ReadBin{
+		{Start: 1, End: 1000, Count: 2},
+		{Start: 1001, End: 2000, Count: 1},
+		{Start: 5001, End: 6000, Count: 1},
+	})
+
+	// 9 mapped reads are 4.5 fragments and the fragments here have a
+	// mean length of 200, so each is in 1.199 bins on average
+	if !counts.BpmEstimate || counts.BinReads != 5 || counts.BpmScaleFactor != 200000 {
+		t.Errorf("bin reads %d and bpm scale factor %f, want an estimate of 5 and 200000",
+			counts.BinReads, counts.BpmScaleFactor)
+	}
+
+	// only b overlaps, from the first mate before the location
+	counts = bamBins(t, "chr1", 1001, 2000, 1000)
+
+	checkBins(t, counts.Bins, []ReadBin{{Start: 1001, End: 2000, Count: 1}})
+}
+
+func TestBamBinCountsSingleEnd(t *testing.T) {
+	// e has a deletion so it covers 990-1045
+	counts := bamBins(t, "chr2", 1, 5000, 1000)
+
+	checkBins(t, counts.Bins, []ReadBin{
+		{Start: 1, End: 1000, Count: 2},
+		{Start: 1001, End: 2000, Count: 1},
+	})
+
+	if counts.YMax != 2 || counts.Status != SampleStatusOk {
+		t.Errorf("unexpected counts %+v", counts)
+	}
+}
+
+func TestBamIndexCache(t *testing.T) {
+	cache := NewBamIndexCache(1)
+
+	index, version, err := cache.Index(bamFixture)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	again, againVersion, err := cache.Index(bamFixture)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if again != index || againVersion != version {
+		t.Errorf("index was read again")
+	}
+
+	if index.TotalMapped() != 9 {
+		t.Errorf("%d mapped reads, want 9", index.TotalMapped())
+	}
+
+	_, _, err = cache.Index("bam/testdata/missing.bam")
+
+	if err == nil {
+		t.Errorf("expected an error for a missing bam")
+	}
+
+	if cache.Len() != 1 {
+		t.Errorf("cache has %d indexes, want 1", cache.Len())
+	}
+}
+
+// copyFile copies a fixture so that a test can change it
+func copyFile(t *testing.T, src string, dst string) {
+	t.Helper()
+
+	data, err := os.ReadFile(src)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(dst, data, 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBamBinCacheNewIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sample.bam")
+
+	copyFile(t, bamFixture, path)
+	copyFile(t, bam.IndexPath(bamFixture), bam.IndexPath(path))
+
+	reader, err := NewBamReader(&Sample{Id: "fixture", Type: SampleTypeBam}, path, 100, StatSum)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache := NewBinCache(NewMemoryBinCacheBackend(0))
+
+	binCounts := func() {
+		t.Helper()
+
+		_, err := cache.BinCounts(context.Background(), reader, binsLocation(t, "chr1", 1, 1000), NormRaw, 1)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the second read is only a hit if the version the reader reports
+	// is the version it read
+	binCounts()
+	binCounts()
+
+	// only the index is regenerated
+	copyFile(t, bam.IndexPath(bamFixture), bam.IndexPath(path))
+
+	err = os.Chtimes(bam.IndexPath(path), time.Time{}, time.Now().Add(time.Minute))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	binCounts()
+
+	stats := cache.Stats()
+
+	if stats.Hits != 1 || stats.Misses != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
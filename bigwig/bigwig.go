@@ -0,0 +1,384 @@
+package bigwig
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	BigWigMagic    uint32 = 0x888FFC26
+	ChromTreeMagic uint32 = 0x78CA8C91
+	RTreeMagic     uint32 = 0x2468ACE0
+
+	headerBytes     = 64
+	zoomHeaderBytes = 24
+
+	// bbi section types for the full resolution data blocks
+	sectionBedGraph  = 1
+	sectionVarStep   = 2
+	sectionFixedStep = 3
+)
+
+var (
+	ErrNotBigWig     = errors.New("not a bigwig file")
+	ErrChrNotFound   = errors.New("chromosome not found")
+	ErrBadChromTree  = errors.New("invalid chromosome tree")
+	ErrBadRTree      = errors.New("invalid r-tree index")
+	ErrBadDataBlock  = errors.New("invalid data block")
+	ErrInvalidRegion = errors.New("invalid region")
+)
+
+type (
+	Header struct {
+		Version            uint16
+		ZoomLevels         uint16
+		ChromTreeOffset    uint64
+		FullDataOffset     uint64
+		FullIndexOffset    uint64
+		FieldCount         uint16
+		DefinedFieldCount  uint16
+		AutoSqlOffset      uint64
+		TotalSummaryOffset uint64
+		UncompressBufSize  uint32
+		ExtensionOffset    uint64
+		compressed         bool
+		byteOrder          binary.ByteOrder
+		chromTreeKeySize   uint32
+		chromTreeItemCount uint64
+	}
+
+	// ReaderAtContext is implemented by readers whose reads can be
+	// cancelled, such as HttpReaderAt
+	ReaderAtContext interface {
+		ReadAtContext(ctx context.Context, p []byte, off int64) (int, error)
+	}
+
+	ZoomHeader struct {
+		ReductionLevel uint32
+		DataOffset     uint64
+		IndexOffset    uint64
+	}
+
+	Chrom struct {
+		Name string
+		Id   uint32
+		Size uint32
+	}
+
+	// BigWig is a parsed bigwig file. The header, zoom headers and
+	// chromosome tree are read once on open; index nodes and data
+	// blocks are read on demand so the same code can run over local
+	// files or remote urls.
+	BigWig struct {
+		r      io.ReaderAt
+		closer io.Closer
+		header *Header
+		zooms  []*ZoomHeader
+		chroms map[string]*Chrom
+
+		// cache of r-tree nodes keyed by file offset since the upper
+		// levels of the index are hit by every query
+		nodeMu sync.Mutex
+		nodes  map[uint64]*rTreeNode
+	}
+)
+
+// Open parses the header, zoom headers and chromosome tree of a bigwig
+// available through r.
+func Open(r io.ReaderAt) (*BigWig, error) {
+	return OpenContext(context.Background(), r)
+}
+
+// OpenContext is Open with a context that can cancel reading the header.
+func OpenContext(ctx context.Context, r io.ReaderAt) (*BigWig, error) {
+	bw := &BigWig{r: r, nodes: make(map[uint64]*rTreeNode)}
+
+	err := bw.readHeader(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = bw.readChromTree(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return bw, nil
+}
+
+// OpenFile opens a local bigwig file. The caller must Close it when done.
+func OpenFile(path string) (*BigWig, error) {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	bw, err := Open(f)
+
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	bw.closer = f
+
+	return bw, nil
+}
+
+func (bw *BigWig) Close() error {
+	if bw.closer != nil {
+		return bw.closer.Close()
+	}
+
+	return nil
+}
+
+func (bw *BigWig) Header() *Header {
+	return bw.header
+}
+
+func (bw *BigWig) ZoomHeaders() []*ZoomHeader {
+	return bw.zooms
+}
+
+// Chrom returns the chromosome entry for a name. Names are matched
+// exactly first and then case insensitively.
+func (bw *BigWig) Chrom(name string) (*Chrom, error) {
+	chrom, ok := bw.chroms[name]
+
+	if ok {
+		return chrom, nil
+	}
+
+	for n, c := range bw.chroms {
+		if strings.EqualFold(n, name) {
+			return c, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrChrNotFound, name)
+}
+
+// Chroms returns all chromosomes in the file.
+func (bw *BigWig) Chroms() []*Chrom {
+	ret := make([]*Chrom, 0, len(bw.chroms))
+
+	for _, c := range bw.chroms {
+		ret = append(ret, c)
+	}
+
+	return ret
+}
+
+// readAt reads exactly len(buf) bytes unless the end of the file is
+// reached, in which case the bytes read so far are returned.
+func (bw *BigWig) readAt(ctx context.Context, buf []byte, offset uint64) ([]byte, error) {
+	var n int
+	var err error
+
+	if r, ok := bw.r.(ReaderAtContext); ok {
+		n, err = r.ReadAtContext(ctx, buf, int64(offset))
+	} else {
+		err = ctx.Err()
+
+		if err != nil {
+			return nil, err
+		}
+
+		n, err = bw.r.ReadAt(buf, int64(offset))
+	}
+
+	if err != nil && !(errors.Is(err, io.EOF) && n > 0) {
+		return nil, err
+	}
+
+	return buf[:n], nil
+}
+
+func (bw *BigWig) readHeader(ctx context.Context) error {
+	buf, err := bw.readAt(ctx, make([]byte, headerBytes), 0)
+
+	if err != nil {
+		return err
+	}
+
+	if len(buf) < headerBytes {
+		return ErrNotBigWig
+	}
+
+	var order binary.ByteOrder
+
+	switch {
+	case binary.LittleEndian.Uint32(buf) == BigWigMagic:
+		order = binary.LittleEndian
+	case binary.BigEndian.Uint32(buf) == BigWigMagic:
+		order = binary.BigEndian
+	default:
+		return ErrNotBigWig
+	}
+
+	h := Header{
+		byteOrder:          order,
+		Version:            order.Uint16(buf[4:]),
+		ZoomLevels:         order.Uint16(buf[6:]),
+		ChromTreeOffset:    order.Uint64(buf[8:]),
+		FullDataOffset:     order.Uint64(buf[16:]),
+		FullIndexOffset:    order.Uint64(buf[24:]),
+		FieldCount:         order.Uint16(buf[32:]),
+		DefinedFieldCount:  order.Uint16(buf[34:]),
+		AutoSqlOffset:      order.Uint64(buf[36:]),
+		TotalSummaryOffset: order.Uint64(buf[44:]),
+		UncompressBufSize:  order.Uint32(buf[52:]),
+		ExtensionOffset:    order.Uint64(buf[56:]),
+	}
+
+	h.compressed = h.UncompressBufSize > 0
+
+	bw.header = &h
+
+	// zoom headers follow directly after the main header
+	if h.ZoomLevels > 0 {
+		buf, err = bw.readAt(ctx, make([]byte, int(h.ZoomLevels)*zoomHeaderBytes), headerBytes)
+
+		if err != nil {
+			return err
+		}
+
+		if len(buf) < int(h.ZoomLevels)*zoomHeaderBytes {
+			return ErrNotBigWig
+		}
+
+		bw.zooms = make([]*ZoomHeader, 0, h.ZoomLevels)
+
+		for i := 0; i < int(h.ZoomLevels); i++ {
+			b := buf[i*zoomHeaderBytes:]
+
+			bw.zooms = append(bw.zooms, &ZoomHeader{
+				ReductionLevel: order.Uint32(b),
+				// 4 bytes reserved
+				DataOffset:  order.Uint64(b[8:]),
+				IndexOffset: order.Uint64(b[16:]),
+			})
+		}
+	}
+
+	return nil
+}
+
+func (bw *BigWig) readChromTree(ctx context.Context) error {
+	h := bw.header
+	order := h.byteOrder
+
+	buf, err := bw.readAt(ctx, make([]byte, 32), h.ChromTreeOffset)
+
+	if err != nil {
+		return err
+	}
+
+	if len(buf) < 32 || order.Uint32(buf) != ChromTreeMagic {
+		return ErrBadChromTree
+	}
+
+	// block size (4 bytes) is not needed to walk the tree
+	h.chromTreeKeySize = order.Uint32(buf[8:])
+	// value size is always 8 (chrom id + chrom size)
+	h.chromTreeItemCount = order.Uint64(buf[16:])
+	// 8 bytes reserved
+
+	bw.chroms = make(map[string]*Chrom, h.chromTreeItemCount)
+
+	// root node follows the 32 byte tree header
+	return bw.readChromNode(ctx, h.ChromTreeOffset+32)
+}
+
+func (bw *BigWig) readChromNode(ctx context.Context, offset uint64) error {
+	h := bw.header
+	order := h.byteOrder
+
+	buf, err := bw.readAt(ctx, make([]byte, 4), offset)
+
+	if err != nil {
+		return err
+	}
+
+	if len(buf) < 4 {
+		return ErrBadChromTree
+	}
+
+	isLeaf := buf[0] == 1
+	// 1 byte reserved
+	count := int(order.Uint16(buf[2:]))
+
+	keySize := int(h.chromTreeKeySize)
+
+	// leaves store chrom id and size, internal nodes a child offset
+	itemSize := keySize + 8
+
+	buf, err = bw.readAt(ctx, make([]byte, count*itemSize), offset+4)
+
+	if err != nil {
+		return err
+	}
+
+	if len(buf) < count*itemSize {
+		return ErrBadChromTree
+	}
+
+	for i := 0; i < count; i++ {
+		b := buf[i*itemSize:]
+
+		if isLeaf {
+			name := string(bytes.TrimRight(b[:keySize], "\x00"))
+
+			bw.chroms[name] = &Chrom{
+				Name: name,
+				Id:   order.Uint32(b[keySize:]),
+				Size: order.Uint32(b[keySize+4:]),
+			}
+		} else {
+			err = bw.readChromNode(ctx, order.Uint64(b[keySize:]))
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// decompress inflates a data block if the file is compressed.
+func (bw *BigWig) decompress(buf []byte) ([]byte, error) {
+	if !bw.header.compressed {
+		return buf, nil
+	}
+
+	zr, err := zlib.NewReader(bytes.NewReader(buf))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer zr.Close()
+
+	out := bytes.NewBuffer(make([]byte, 0, bw.header.UncompressBufSize))
+
+	_, err = io.Copy(out, zr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
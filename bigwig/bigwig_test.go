@@ -0,0 +1,354 @@
+package bigwig
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+)
+
+// testdata/fixture.bw is written by testdata/mkfixture. These are the
+// intervals it holds, 0-based half open.
+var fixtureIntervals = map[string][]Interval{
+	"chr1": {
+		// bedGraph block
+		{100, 200, 2}, {200, 300, 4}, {1000, 1500, 10}, {4000, 4100, -1.5},
+		// variableStep block with a span of 50
+		{6000, 6050, 1}, {6100, 6150, 2}, {6200, 6250, 3},
+		// fixedStep block with a step and span of 100
+		{9000, 9100, 5}, {9100, 9200, 5}, {9200, 9300, 6}, {9300, 9400, 7},
+		// bedGraph block
+		{20000, 25000, 0.5}, {49900, 50000, 8},
+	},
+	"chr2": {{0, 1000, 1}, {10000, 10010, 100}},
+	"chrX": {{0, 10, 1}, {10, 20, 2}, {20, 30, 3}, {30, 40, 4}, {40, 50, 5},
+		{50, 60, 6}, {60, 70, 7}, {70, 80, 8}, {80, 90, 9}, {90, 100, 10}},
+}
+
+func openFixture(t *testing.T) *BigWig {
+	t.Helper()
+
+	bw, err := OpenFile("testdata/fixture.bw")
+
+	if err != nil {
+		t.Fatalf("open fixture: %s", err)
+	}
+
+	t.Cleanup(func() { bw.Close() })
+
+	return bw
+}
+
+// expectedSummary summarizes the fixture base by base, which is what
+// bigWigSummary reports for full resolution data
+func expectedSummary(chr string, start int, end int) *Summary {
+	var ret Summary
+
+	for _, iv := range fixtureIntervals[chr] {
+		for base := max(int(iv.Start), start); base < min(int(iv.End), end); base++ {
+			v := float64(iv.Value)
+			ret.add(1, v, v, v, v*v)
+		}
+	}
+
+	return &ret
+}
+
+func closeTo(a float64, b float64) bool {
+	return math.Abs(a-b) <= 1e-6*math.Max(1, math.Abs(b))
+}
+
+func TestHeader(t *testing.T) {
+	bw := openFixture(t)
+
+	h := bw.Header()
+
+	if h.Version != 4 {
+		t.Errorf("version %d, want 4", h.Version)
+	}
+
+	if !h.compressed || h.UncompressBufSize == 0 {
+		t.Errorf("fixture should be compressed")
+	}
+
+	if h.ChromTreeOffset == 0 || h.FullDataOffset <= h.ChromTreeOffset || h.FullIndexOffset <= h.FullDataOffset {
+		t.Errorf("unexpected section offsets %+v", h)
+	}
+
+	zooms := bw.ZoomHeaders()
+
+	if len(zooms) != 1 {
+		t.Fatalf("%d zoom levels, want 1", len(zooms))
+	}
+
+	if zooms[0].ReductionLevel != 1000 {
+		t.Errorf("zoom reduction %d, want 1000", zooms[0].ReductionLevel)
+	}
+
+	if zooms[0].DataOffset <= h.FullIndexOffset || zooms[0].IndexOffset <= zooms[0].DataOffset {
+		t.Errorf("unexpected zoom offsets %+v", zooms[0])
+	}
+}
+
+func TestOpenNotBigWig(t *testing.T) {
+	_, err := OpenFile("testdata/mkfixture/main.go")
+
+	if !errors.Is(err, ErrNotBigWig) {
+		t.Errorf("error %v, want %v", err, ErrNotBigWig)
+	}
+}
+
+func TestChromTree(t *testing.T) {
+	bw := openFixture(t)
+
+	// the tree has a root and two leaves and chr10 has the longest
+	// name, so the other keys are zero padded
+	want := []Chrom{
+		{"chr1", 0, 50000},
+		{"chr10", 1, 1000},
+		{"chr2", 2, 20000},
+		{"chrX", 3, 10000},
+	}
+
+	if len(bw.Chroms()) != len(want) {
+		t.Errorf("%d chromosomes, want %d", len(bw.Chroms()), len(want))
+	}
+
+	for _, w := range want {
+		chrom, err := bw.Chrom(w.Name)
+
+		if err != nil {
+			t.Errorf("%s: %s", w.Name, err)
+			continue
+		}
+
+		if *chrom != w {
+			t.Errorf("chrom %+v, want %+v", *chrom, w)
+		}
+	}
+
+	chrom, err := bw.Chrom("CHRX")
+
+	if err != nil || chrom.Name != "chrX" {
+		t.Errorf("case insensitive lookup returned %v, %v", chrom, err)
+	}
+
+	_, err = bw.Chrom("chr3")
+
+	if !errors.Is(err, ErrChrNotFound) {
+		t.Errorf("error %v, want %v", err, ErrChrNotFound)
+	}
+}
+
+func TestFindBlocks(t *testing.T) {
+	bw := openFixture(t)
+
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		chromId uint32
+		start   uint32
+		end     uint32
+		blocks  int
+	}{
+		{"all of chr1", 0, 0, 50000, 4},
+		{"first block", 0, 0, 1000, 1},
+		{"last two blocks of chr1", 0, 9300, 20001, 2},
+		{"between blocks", 0, 9400, 20000, 0},
+		{"chr2", 2, 0, 20000, 1},
+		{"chrX", 3, 50, 60, 1},
+		{"no data", 1, 0, 1000, 0},
+	}
+
+	for _, test := range tests {
+		blocks, err := bw.findBlocks(ctx, bw.Header().FullIndexOffset, test.chromId, test.start, test.end)
+
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+
+		if len(blocks) != test.blocks {
+			t.Errorf("%s: %d blocks, want %d", test.name, len(blocks), test.blocks)
+		}
+
+		for i := 1; i < len(blocks); i++ {
+			if blocks[i].Offset <= blocks[i-1].Offset {
+				t.Errorf("%s: blocks are not in file order", test.name)
+			}
+		}
+	}
+
+	_, err := bw.findBlocks(ctx, bw.Header().ChromTreeOffset, 0, 0, 1000)
+
+	if !errors.Is(err, ErrBadRTree) {
+		t.Errorf("error %v, want %v", err, ErrBadRTree)
+	}
+}
+
+func TestIntervals(t *testing.T) {
+	bw := openFixture(t)
+
+	// every chromosome decompresses to the intervals that were written,
+	// whichever section type holds them
+	for chr, want := range fixtureIntervals {
+		intervals, err := bw.Intervals(context.Background(), chr, 0, 50000)
+
+		if err != nil {
+			t.Errorf("%s: %s", chr, err)
+			continue
+		}
+
+		if len(intervals) != len(want) {
+			t.Errorf("%s: %d intervals, want %d", chr, len(intervals), len(want))
+			continue
+		}
+
+		for i, iv := range intervals {
+			if *iv != want[i] {
+				t.Errorf("%s: interval %d is %+v, want %+v", chr, i, *iv, want[i])
+			}
+		}
+	}
+
+	// only overlapping intervals are returned
+	intervals, err := bw.Intervals(context.Background(), "chr1", 150, 1001)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(intervals) != 3 || intervals[0].Start != 100 || intervals[2].Start != 1000 {
+		t.Errorf("unexpected intervals %v", intervals)
+	}
+
+	_, err = bw.Intervals(context.Background(), "chr1", 100, 100)
+
+	if !errors.Is(err, ErrInvalidRegion) {
+		t.Errorf("error %v, want %v", err, ErrInvalidRegion)
+	}
+}
+
+func TestSummariesFullResolution(t *testing.T) {
+	bw := openFixture(t)
+
+	// bins of 50 bases are too small for the 1000 base zoom level
+	start := 0
+	end := 10000
+	bins := 200
+
+	if bw.BestZoom((end-start)/bins) != nil {
+		t.Fatalf("expected full resolution data to be used")
+	}
+
+	summaries, err := bw.Summaries(context.Background(), "chr1", start, end, bins)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, s := range summaries {
+		bs := start + (end-start)*i/bins
+		be := start + (end-start)*(i+1)/bins
+
+		want := expectedSummary("chr1", bs, be)
+
+		if !closeTo(s.ValidCount, want.ValidCount) || !closeTo(s.Sum, want.Sum) ||
+			!closeTo(s.SumSquares, want.SumSquares) || (want.HasData() && (s.Min != want.Min || s.Max != want.Max)) {
+			t.Errorf("bin %d [%d, %d) is %+v, want %+v", i, bs, be, *s, *want)
+		}
+	}
+}
+
+func TestSummariesZoom(t *testing.T) {
+	bw := openFixture(t)
+
+	// bins of 4000 bases use the 1000 base zoom level and line up with
+	// its records, so the result is exact
+	start := 0
+	end := 12000
+	bins := 3
+
+	zoom := bw.BestZoom((end - start) / bins)
+
+	if zoom == nil || zoom.ReductionLevel != 1000 {
+		t.Fatalf("expected the 1000 base zoom level, got %v", zoom)
+	}
+
+	summaries, err := bw.Summaries(context.Background(), "chr1", start, end, bins)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, s := range summaries {
+		bs := start + (end-start)*i/bins
+		be := start + (end-start)*(i+1)/bins
+
+		want := expectedSummary("chr1", bs, be)
+
+		if !closeTo(s.ValidCount, want.ValidCount) || !closeTo(s.Sum, want.Sum) ||
+			!closeTo(s.SumSquares, want.SumSquares) || s.Min != want.Min || s.Max != want.Max {
+			t.Errorf("bin %d [%d, %d) is %+v, want %+v", i, bs, be, *s, *want)
+		}
+	}
+}
+
+// TestSummariesBigWigSummary checks the means of chr1:0-8000 in 4 bins,
+// which bigWigSummary gives as the mean of the covered bases of each bin
+// with n/a for bins without data: 8 n/a -1.5 2
+func TestSummariesBigWigSummary(t *testing.T) {
+	bw := openFixture(t)
+
+	summaries, err := bw.Summaries(context.Background(), "chr1", 0, 8000, 4)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []float64{8, math.NaN(), -1.5, 2}
+
+	for i, s := range summaries {
+		if math.IsNaN(want[i]) {
+			if s.HasData() {
+				t.Errorf("bin %d has data %+v, want none", i, *s)
+			}
+
+			continue
+		}
+
+		if !closeTo(s.Mean(), want[i]) {
+			t.Errorf("bin %d mean %f, want %f", i, s.Mean(), want[i])
+		}
+	}
+
+	// a 2000 base bin with 700 bases covered
+	if !closeTo(summaries[0].Coverage(2000), 0.35) {
+		t.Errorf("coverage %f, want 0.35", summaries[0].Coverage(2000))
+	}
+}
+
+func TestSummaryStd(t *testing.T) {
+	bw := openFixture(t)
+
+	// chrX:0-100 is 1 to 10 in steps of 10 bases
+	summaries, err := bw.Summaries(context.Background(), "chrX", 0, 100, 1)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := summaries[0]
+
+	if s.ValidCount != 100 || s.Min != 1 || s.Max != 10 || !closeTo(s.Mean(), 5.5) {
+		t.Errorf("unexpected summary %+v", *s)
+	}
+
+	// sample standard deviation of 1..10 each repeated 10 times
+	want := math.Sqrt((10*385 - 100*5.5*5.5) / 99)
+
+	if !closeTo(s.Std(), want) {
+		t.Errorf("std %f, want %f", s.Std(), want)
+	}
+}
@@ -0,0 +1,142 @@
+package bigwig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrRangeNotSupported = errors.New("server does not support range requests")
+	// the remote file is not the one that was opened, so offsets read
+	// from its header and index can no longer be trusted
+	ErrRemoteChanged = errors.New("remote file has changed")
+)
+
+// HttpReaderAt implements io.ReaderAt over an http(s) url using Range
+// requests so only the parts of a remote bigwig that are needed are
+// downloaded. The ETag and Last-Modified of the first response are
+// kept and any later response that does not match fails with
+// ErrRemoteChanged.
+type HttpReaderAt struct {
+	url          string
+	client       *http.Client
+	etag         string
+	lastModified string
+	mu           sync.Mutex
+}
+
+func NewHttpReaderAt(url string, client *http.Client) *HttpReaderAt {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &HttpReaderAt{url: url, client: client}
+}
+
+// IsUrl tests whether a bigwig path should be read over http.
+func IsUrl(path string) bool {
+	path = strings.ToLower(path)
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// OpenUrl opens a remote bigwig. Only the header and chromosome tree are
+// fetched up front.
+func OpenUrl(ctx context.Context, url string, client *http.Client) (*BigWig, error) {
+	return OpenContext(ctx, NewHttpReaderAt(url, client))
+}
+
+func (r *HttpReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	return r.ReadAtContext(context.Background(), p, off)
+}
+
+// ReadAtContext is ReadAt with a context so that requests for clients
+// that have gone away can be abandoned.
+func (r *HttpReaderAt) ReadAtContext(ctx context.Context, p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url, nil)
+
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+int64(len(p))-1))
+
+	resp, err := r.client.Do(req)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		// expected response
+	case http.StatusOK:
+		// the whole file is on its way, which for every index node and
+		// data block read is far too much to download
+		return 0, fmt.Errorf("%w: %s", ErrRangeNotSupported, r.url)
+	case http.StatusRequestedRangeNotSatisfiable:
+		return 0, io.EOF
+	default:
+		return 0, fmt.Errorf("error reading %s: %s", r.url, resp.Status)
+	}
+
+	err = r.checkVersion(resp)
+
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := io.ReadFull(resp.Body, p)
+
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		return n, io.EOF
+	}
+
+	return n, err
+}
+
+// Version is the ETag of the remote file, or its Last-Modified time if it
+// has no ETag, as first seen by the reader. It is empty until something
+// has been read or if the server sends neither.
+func (r *HttpReaderAt) Version() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.etag != "" {
+		return r.etag
+	}
+
+	return r.lastModified
+}
+
+// checkVersion remembers the validators of the first response and
+// rejects responses for a different version of the file
+func (r *HttpReaderAt) checkVersion(resp *http.Response) error {
+	etag := resp.Header.Get("ETag")
+	lastModified := resp.Header.Get("Last-Modified")
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.etag == "" && r.lastModified == "" {
+		r.etag = etag
+		r.lastModified = lastModified
+		return nil
+	}
+
+	if etag != r.etag || lastModified != r.lastModified {
+		return fmt.Errorf("%w: %s", ErrRemoteChanged, r.url)
+	}
+
+	return nil
+}
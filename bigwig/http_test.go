@@ -0,0 +1,174 @@
+package bigwig
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// rangeServer serves the fixture and records the Range of each request
+type rangeServer struct {
+	*httptest.Server
+	data     []byte
+	etag     string
+	ranges   []string
+	noRanges bool
+	mu       sync.Mutex
+}
+
+func newRangeServer(t *testing.T) *rangeServer {
+	t.Helper()
+
+	data, err := os.ReadFile("testdata/fixture.bw")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &rangeServer{data: data, etag: `"v1"`}
+
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.mu.Lock()
+		server.ranges = append(server.ranges, r.Header.Get("Range"))
+		etag := server.etag
+		noRanges := server.noRanges
+		server.mu.Unlock()
+
+		w.Header().Set("ETag", etag)
+
+		if noRanges {
+			w.Write(server.data)
+			return
+		}
+
+		http.ServeContent(w, r, "fixture.bw", time.Time{}, bytes.NewReader(server.data))
+	}))
+
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func (server *rangeServer) requests() []string {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	return append([]string{}, server.ranges...)
+}
+
+func TestHttpReaderRanges(t *testing.T) {
+	server := newRangeServer(t)
+
+	ctx := context.Background()
+
+	bw, err := OpenUrl(ctx, server.URL+"/fixture.bw", server.Client())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opened := len(server.requests())
+
+	for i := 0; i < 2; i++ {
+		// full resolution and zoom level reads
+		_, err = bw.Summaries(ctx, "chr1", 0, 10000, 200)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = bw.Summaries(ctx, "chr1", 0, 12000, 3)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	requests := server.requests()
+
+	h := bw.Header()
+	zoom := bw.ZoomHeaders()[0]
+
+	// data blocks are read each time, everything else once
+	isData := func(offset uint64) bool {
+		return (offset >= h.FullDataOffset && offset < h.FullIndexOffset) ||
+			(offset >= zoom.DataOffset && offset < zoom.IndexOffset)
+	}
+
+	seen := make(map[string]int)
+
+	for i, r := range requests {
+		var start uint64
+		var end uint64
+
+		_, err := fmt.Sscanf(r, "bytes=%d-%d", &start, &end)
+
+		if err != nil {
+			t.Fatalf("request without a range: %q", r)
+		}
+
+		seen[r]++
+
+		if isData(start) {
+			if i < opened {
+				t.Errorf("data read %s while opening", r)
+			}
+
+			continue
+		}
+
+		if seen[r] > 1 {
+			t.Errorf("header or index read %s more than once", r)
+		}
+	}
+
+	if seen["bytes=0-63"] != 1 {
+		t.Errorf("header read %d times", seen["bytes=0-63"])
+	}
+}
+
+func TestHttpReaderNoRanges(t *testing.T) {
+	server := newRangeServer(t)
+	server.noRanges = true
+
+	_, err := OpenUrl(context.Background(), server.URL+"/fixture.bw", server.Client())
+
+	if !errors.Is(err, ErrRangeNotSupported) {
+		t.Errorf("error %v, want %v", err, ErrRangeNotSupported)
+	}
+}
+
+func TestHttpReaderChanged(t *testing.T) {
+	server := newRangeServer(t)
+
+	ctx := context.Background()
+
+	reader := NewHttpReaderAt(server.URL+"/fixture.bw", server.Client())
+
+	bw, err := OpenContext(ctx, reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reader.Version() != `"v1"` {
+		t.Errorf("version %q, want %q", reader.Version(), `"v1"`)
+	}
+
+	server.mu.Lock()
+	server.etag = `"v2"`
+	server.mu.Unlock()
+
+	_, err = bw.Intervals(ctx, "chr2", 0, 1000)
+
+	if !errors.Is(err, ErrRemoteChanged) {
+		t.Errorf("error %v, want %v", err, ErrRemoteChanged)
+	}
+}
@@ -0,0 +1,238 @@
+package bigwig
+
+import "context"
+
+const (
+	rTreeHeaderBytes   = 48
+	rTreeLeafItemBytes = 32
+	rTreeNodeItemBytes = 24
+)
+
+type (
+	// a block of data referenced by an r-tree leaf
+	dataBlock struct {
+		Offset uint64
+		Size   uint64
+	}
+
+	rTreeItem struct {
+		startChromIx uint32
+		startBase    uint32
+		endChromIx   uint32
+		endBase      uint32
+		// data offset for leaves, child node offset otherwise
+		offset uint64
+		// only used by leaves
+		size uint64
+	}
+
+	rTreeNode struct {
+		isLeaf bool
+		items  []*rTreeItem
+	}
+)
+
+// overlaps tests whether the item spans any of chromId:[start, end)
+func (item *rTreeItem) overlaps(chromId uint32, start uint32, end uint32) bool {
+	// item starts after the region
+	if item.startChromIx > chromId || (item.startChromIx == chromId && item.startBase >= end) {
+		return false
+	}
+
+	// item ends before the region
+	if item.endChromIx < chromId || (item.endChromIx == chromId && item.endBase <= start) {
+		return false
+	}
+
+	return true
+}
+
+// findBlocks walks the r-tree index at indexOffset and returns the data
+// blocks overlapping chromId:[start, end) in file order.
+func (bw *BigWig) findBlocks(ctx context.Context, indexOffset uint64, chromId uint32, start uint32, end uint32) ([]*dataBlock, error) {
+	rootOffset := indexOffset + rTreeHeaderBytes
+
+	bw.nodeMu.Lock()
+	_, ok := bw.nodes[rootOffset]
+	bw.nodeMu.Unlock()
+
+	// only check the index header the first time it is used
+	if !ok {
+		buf, err := bw.readAt(ctx, make([]byte, rTreeHeaderBytes), indexOffset)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(buf) < rTreeHeaderBytes || bw.header.byteOrder.Uint32(buf) != RTreeMagic {
+			return nil, ErrBadRTree
+		}
+	}
+
+	ret := make([]*dataBlock, 0, 10)
+
+	err := bw.findBlocksInNode(ctx, rootOffset, chromId, start, end, &ret)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+func (bw *BigWig) findBlocksInNode(ctx context.Context, offset uint64, chromId uint32, start uint32, end uint32, blocks *[]*dataBlock) error {
+	node, err := bw.readRTreeNode(ctx, offset)
+
+	if err != nil {
+		return err
+	}
+
+	for _, item := range node.items {
+		if !item.overlaps(chromId, start, end) {
+			continue
+		}
+
+		if node.isLeaf {
+			*blocks = append(*blocks, &dataBlock{Offset: item.offset, Size: item.size})
+		} else {
+			err = bw.findBlocksInNode(ctx, item.offset, chromId, start, end, blocks)
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (bw *BigWig) readRTreeNode(ctx context.Context, offset uint64) (*rTreeNode, error) {
+	bw.nodeMu.Lock()
+	node, ok := bw.nodes[offset]
+	bw.nodeMu.Unlock()
+
+	if ok {
+		return node, nil
+	}
+
+	order := bw.header.byteOrder
+
+	buf, err := bw.readAt(ctx, make([]byte, 4), offset)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(buf) < 4 {
+		return nil, ErrBadRTree
+	}
+
+	isLeaf := buf[0] == 1
+	// 1 byte reserved
+	count := int(order.Uint16(buf[2:]))
+
+	itemSize := rTreeNodeItemBytes
+
+	if isLeaf {
+		itemSize = rTreeLeafItemBytes
+	}
+
+	buf, err = bw.readAt(ctx, make([]byte, count*itemSize), offset+4)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(buf) < count*itemSize {
+		return nil, ErrBadRTree
+	}
+
+	node = &rTreeNode{isLeaf: isLeaf, items: make([]*rTreeItem, 0, count)}
+
+	for i := 0; i < count; i++ {
+		b := buf[i*itemSize:]
+
+		item := rTreeItem{
+			startChromIx: order.Uint32(b),
+			startBase:    order.Uint32(b[4:]),
+			endChromIx:   order.Uint32(b[8:]),
+			endBase:      order.Uint32(b[12:]),
+			offset:       order.Uint64(b[16:]),
+		}
+
+		if isLeaf {
+			item.size = order.Uint64(b[24:])
+		}
+
+		node.items = append(node.items, &item)
+	}
+
+	bw.nodeMu.Lock()
+	bw.nodes[offset] = node
+	bw.nodeMu.Unlock()
+
+	return node, nil
+}
+
+// mergeBlocks groups blocks that sit back to back in the file so they
+// can be fetched with a single read. Each group is returned as the
+// combined span together with the original blocks.
+func mergeBlocks(blocks []*dataBlock) [][]*dataBlock {
+	ret := make([][]*dataBlock, 0, len(blocks))
+
+	for _, block := range blocks {
+		n := len(ret)
+
+		if n > 0 {
+			group := ret[n-1]
+			last := group[len(group)-1]
+
+			if last.Offset+last.Size == block.Offset {
+				ret[n-1] = append(group, block)
+				continue
+			}
+		}
+
+		ret = append(ret, []*dataBlock{block})
+	}
+
+	return ret
+}
+
+// readBlocks reads the given blocks, fetching adjacent blocks in one
+// read, and calls fn with each decompressed block in order.
+func (bw *BigWig) readBlocks(ctx context.Context, blocks []*dataBlock, fn func(data []byte) error) error {
+	for _, group := range mergeBlocks(blocks) {
+		first := group[0]
+		last := group[len(group)-1]
+
+		buf, err := bw.readAt(ctx, make([]byte, last.Offset+last.Size-first.Offset), first.Offset)
+
+		if err != nil {
+			return err
+		}
+
+		for _, block := range group {
+			s := block.Offset - first.Offset
+			e := s + block.Size
+
+			if e > uint64(len(buf)) {
+				return ErrBadDataBlock
+			}
+
+			data, err := bw.decompress(buf[s:e])
+
+			if err != nil {
+				return err
+			}
+
+			err = fn(data)
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
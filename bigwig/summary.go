@@ -0,0 +1,377 @@
+package bigwig
+
+import (
+	"context"
+	"math"
+)
+
+const zoomRecordBytes = 32
+
+type (
+	// Interval is a full resolution value over the 0-based half open
+	// range [Start, End)
+	Interval struct {
+		Start uint32
+		End   uint32
+		Value float32
+	}
+
+	// Summary holds the aggregate statistics of a region in the same
+	// way as bbiSummaryElement. ValidCount is the number of bases
+	// covered by data, so a summary with ValidCount == 0 has no data.
+	Summary struct {
+		ValidCount float64
+		Min        float64
+		Max        float64
+		Sum        float64
+		SumSquares float64
+	}
+
+	zoomRecord struct {
+		chromId    uint32
+		start      uint32
+		end        uint32
+		validCount uint32
+		min        float32
+		max        float32
+		sum        float32
+		sumSquares float32
+	}
+)
+
+func (s *Summary) HasData() bool {
+	return s.ValidCount > 0
+}
+
+func (s *Summary) Mean() float64 {
+	if s.ValidCount == 0 {
+		return 0
+	}
+
+	return s.Sum / s.ValidCount
+}
+
+// Std is the sample standard deviation of the values of the covered
+// bases, calculated as calcStdFromSums does.
+func (s *Summary) Std() float64 {
+	if s.ValidCount <= 1 {
+		return 0
+	}
+
+	v := (s.SumSquares - s.Sum*s.Sum/s.ValidCount) / (s.ValidCount - 1)
+
+	if v <= 0 {
+		return 0
+	}
+
+	return math.Sqrt(v)
+}
+
+// Coverage is the fraction of the bases of a region that have data.
+func (s *Summary) Coverage(bases int) float64 {
+	if bases < 1 {
+		return 0
+	}
+
+	return s.ValidCount / float64(bases)
+}
+
+func (s *Summary) add(validCount float64, min float64, max float64, sum float64, sumSquares float64) {
+	if s.ValidCount == 0 {
+		s.Min = min
+		s.Max = max
+	} else {
+		s.Min = math.Min(s.Min, min)
+		s.Max = math.Max(s.Max, max)
+	}
+
+	s.ValidCount += validCount
+	s.Sum += sum
+	s.SumSquares += sumSquares
+}
+
+// BestZoom returns the zoom level with the largest reduction that is
+// still no more than half the bases per bin, mirroring bbiBestZoom, or
+// nil if the full resolution data should be used.
+func (bw *BigWig) BestZoom(basesPerBin int) *ZoomHeader {
+	desiredReduction := basesPerBin / 2
+
+	if desiredReduction <= 1 {
+		return nil
+	}
+
+	var ret *ZoomHeader
+	closestDiff := math.MaxInt
+
+	for _, zoom := range bw.zooms {
+		diff := desiredReduction - int(zoom.ReductionLevel)
+
+		if diff >= 0 && diff < closestDiff {
+			closestDiff = diff
+			ret = zoom
+		}
+	}
+
+	return ret
+}
+
+// Intervals returns the full resolution values overlapping the 0-based
+// half open region chr:[start, end).
+func (bw *BigWig) Intervals(ctx context.Context, chr string, start int, end int) ([]*Interval, error) {
+	chrom, s, e, err := bw.region(chr, start, end)
+
+	if err != nil {
+		return nil, err
+	}
+
+	blocks, err := bw.findBlocks(ctx, bw.header.FullIndexOffset, chrom.Id, s, e)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return bw.intervals(ctx, blocks, chrom.Id, s, e)
+}
+
+// Summaries divides the 0-based half open region chr:[start, end) into
+// bins of equal size and summarizes each one, using the most suitable
+// zoom level in the same way as bigWigSummary. Bins without data have
+// a ValidCount of zero.
+func (bw *BigWig) Summaries(ctx context.Context, chr string, start int, end int, bins int) ([]*Summary, error) {
+	chrom, s, e, err := bw.region(chr, start, end)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if bins < 1 {
+		return nil, ErrInvalidRegion
+	}
+
+	ret := make([]*Summary, bins)
+
+	for i := range ret {
+		ret[i] = &Summary{}
+	}
+
+	zoom := bw.BestZoom((end - start) / bins)
+
+	if zoom != nil {
+		blocks, err := bw.findBlocks(ctx, zoom.IndexOffset, chrom.Id, s, e)
+
+		if err != nil {
+			return nil, err
+		}
+
+		records, err := bw.zoomRecords(ctx, blocks, chrom.Id, s, e)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, r := range records {
+			span := float64(r.end - r.start)
+
+			eachBin(start, end, bins, int(r.start), int(r.end), func(bi int, overlap int) {
+				// zoom records are scaled by the fraction of the
+				// record that falls in the bin
+				f := float64(overlap) / span
+
+				ret[bi].add(float64(r.validCount)*f,
+					float64(r.min),
+					float64(r.max),
+					float64(r.sum)*f,
+					float64(r.sumSquares)*f)
+			})
+		}
+	} else {
+		blocks, err := bw.findBlocks(ctx, bw.header.FullIndexOffset, chrom.Id, s, e)
+
+		if err != nil {
+			return nil, err
+		}
+
+		intervals, err := bw.intervals(ctx, blocks, chrom.Id, s, e)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, iv := range intervals {
+			v := float64(iv.Value)
+
+			eachBin(start, end, bins, int(iv.Start), int(iv.End), func(bi int, overlap int) {
+				o := float64(overlap)
+				ret[bi].add(o, v, v, v*o, v*v*o)
+			})
+		}
+	}
+
+	return ret, nil
+}
+
+// region validates a region and looks up its chromosome
+func (bw *BigWig) region(chr string, start int, end int) (*Chrom, uint32, uint32, error) {
+	chrom, err := bw.Chrom(chr)
+
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	if start < 0 || end <= start {
+		return nil, 0, 0, ErrInvalidRegion
+	}
+
+	return chrom, uint32(start), uint32(min(end, math.MaxUint32)), nil
+}
+
+// eachBin calls fn for every bin of [start, end) split into n bins that
+// the item [s, e) overlaps, along with the number of overlapping bases.
+// Bin boundaries are computed as in bbiSummaryArray so that regions not
+// divisible by n are handled the same way.
+func eachBin(start int, end int, n int, s int, e int, fn func(bi int, overlap int)) {
+	size := end - start
+
+	if s < start {
+		s = start
+	}
+
+	if e > end {
+		e = end
+	}
+
+	if s >= e {
+		return
+	}
+
+	bi := (s - start) * n / size
+
+	for ; bi < n; bi++ {
+		bs := start + size*bi/n
+		be := start + size*(bi+1)/n
+
+		if bs >= e {
+			break
+		}
+
+		overlap := min(be, e) - max(bs, s)
+
+		if overlap > 0 {
+			fn(bi, overlap)
+		}
+	}
+}
+
+func (bw *BigWig) intervals(ctx context.Context, blocks []*dataBlock, chromId uint32, start uint32, end uint32) ([]*Interval, error) {
+	order := bw.header.byteOrder
+
+	ret := make([]*Interval, 0, 100)
+
+	err := bw.readBlocks(ctx, blocks, func(data []byte) error {
+		if len(data) < 24 {
+			return ErrBadDataBlock
+		}
+
+		blockChromId := order.Uint32(data)
+		blockStart := order.Uint32(data[4:])
+		// block end at 8
+		itemStep := order.Uint32(data[12:])
+		itemSpan := order.Uint32(data[16:])
+		sectionType := data[20]
+		// 1 byte reserved
+		itemCount := int(order.Uint16(data[22:]))
+
+		if blockChromId != chromId {
+			return nil
+		}
+
+		var itemSize int
+
+		switch sectionType {
+		case sectionBedGraph:
+			itemSize = 12
+		case sectionVarStep:
+			itemSize = 8
+		case sectionFixedStep:
+			itemSize = 4
+		default:
+			return ErrBadDataBlock
+		}
+
+		if len(data) < 24+itemCount*itemSize {
+			return ErrBadDataBlock
+		}
+
+		for i := 0; i < itemCount; i++ {
+			b := data[24+i*itemSize:]
+
+			var iv Interval
+
+			switch sectionType {
+			case sectionBedGraph:
+				iv.Start = order.Uint32(b)
+				iv.End = order.Uint32(b[4:])
+				iv.Value = math.Float32frombits(order.Uint32(b[8:]))
+			case sectionVarStep:
+				iv.Start = order.Uint32(b)
+				iv.End = iv.Start + itemSpan
+				iv.Value = math.Float32frombits(order.Uint32(b[4:]))
+			default:
+				iv.Start = blockStart + uint32(i)*itemStep
+				iv.End = iv.Start + itemSpan
+				iv.Value = math.Float32frombits(order.Uint32(b))
+			}
+
+			if iv.End <= start || iv.Start >= end {
+				continue
+			}
+
+			ret = append(ret, &iv)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+func (bw *BigWig) zoomRecords(ctx context.Context, blocks []*dataBlock, chromId uint32, start uint32, end uint32) ([]*zoomRecord, error) {
+	order := bw.header.byteOrder
+
+	ret := make([]*zoomRecord, 0, 100)
+
+	err := bw.readBlocks(ctx, blocks, func(data []byte) error {
+		for i := 0; i+zoomRecordBytes <= len(data); i += zoomRecordBytes {
+			b := data[i:]
+
+			r := zoomRecord{
+				chromId:    order.Uint32(b),
+				start:      order.Uint32(b[4:]),
+				end:        order.Uint32(b[8:]),
+				validCount: order.Uint32(b[12:]),
+				min:        math.Float32frombits(order.Uint32(b[16:])),
+				max:        math.Float32frombits(order.Uint32(b[20:])),
+				sum:        math.Float32frombits(order.Uint32(b[24:])),
+				sumSquares: math.Float32frombits(order.Uint32(b[28:])),
+			}
+
+			if r.chromId != chromId || r.end <= start || r.start >= end || r.end <= r.start {
+				continue
+			}
+
+			ret = append(ret, &r)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,453 @@
+// mkfixture writes fixture.bw, a small compressed bigwig laid out as
+// bedGraphToBigWig does, for the tests of the bigwig package. Run it from
+// the bigwig directory with
+//
+//	go run ./testdata/mkfixture
+//
+// The chromosome tree and both r-trees use a block size of 2 so that
+// they have internal nodes, and the data blocks use all three section
+// types.
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"log"
+	"math"
+	"os"
+	"sort"
+)
+
+const (
+	bigWigMagic    uint32 = 0x888FFC26
+	chromTreeMagic uint32 = 0x78CA8C91
+	rTreeMagic     uint32 = 0x2468ACE0
+
+	blockSize = 2
+	reduction = 1000
+
+	sectionBedGraph  = 1
+	sectionVarStep   = 2
+	sectionFixedStep = 3
+)
+
+var order = binary.LittleEndian
+
+type (
+	chrom struct {
+		name string
+		size uint32
+	}
+
+	interval struct {
+		start uint32
+		end   uint32
+		value float32
+	}
+
+	block struct {
+		chrom       string
+		sectionType uint8
+		step        uint32
+		span        uint32
+		intervals   []interval
+	}
+
+	// an r-tree leaf item
+	indexItem struct {
+		chromId uint32
+		start   uint32
+		end     uint32
+		offset  uint64
+		size    uint64
+	}
+)
+
+var chroms = []chrom{
+	{"chr1", 50000},
+	{"chr10", 1000},
+	{"chr2", 20000},
+	{"chrX", 10000},
+}
+
+// 0-based half open intervals, which the tests repeat
+var blocks = []block{
+	{chrom: "chr1", sectionType: sectionBedGraph, intervals: []interval{
+		{100, 200, 2}, {200, 300, 4}, {1000, 1500, 10}, {4000, 4100, -1.5}}},
+	{chrom: "chr1", sectionType: sectionVarStep, span: 50, intervals: []interval{
+		{6000, 6050, 1}, {6100, 6150, 2}, {6200, 6250, 3}}},
+	{chrom: "chr1", sectionType: sectionFixedStep, step: 100, span: 100, intervals: []interval{
+		{9000, 9100, 5}, {9100, 9200, 5}, {9200, 9300, 6}, {9300, 9400, 7}}},
+	{chrom: "chr1", sectionType: sectionBedGraph, intervals: []interval{
+		{20000, 25000, 0.5}, {49900, 50000, 8}}},
+	{chrom: "chr2", sectionType: sectionBedGraph, intervals: []interval{
+		{0, 1000, 1}, {10000, 10010, 100}}},
+	{chrom: "chrX", sectionType: sectionFixedStep, step: 10, span: 10, intervals: []interval{
+		{0, 10, 1}, {10, 20, 2}, {20, 30, 3}, {30, 40, 4}, {40, 50, 5},
+		{50, 60, 6}, {60, 70, 7}, {70, 80, 8}, {80, 90, 9}, {90, 100, 10}}},
+}
+
+func main() {
+	err := os.WriteFile("testdata/fixture.bw", write(), 0644)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func chromId(name string) uint32 {
+	for i, c := range chroms {
+		if c.name == name {
+			return uint32(i)
+		}
+	}
+
+	log.Fatalf("unknown chromosome %s", name)
+
+	return 0
+}
+
+func compress(data []byte) []byte {
+	var buf bytes.Buffer
+
+	w := zlib.NewWriter(&buf)
+	w.Write(data)
+	w.Close()
+
+	return buf.Bytes()
+}
+
+func put32(buf *bytes.Buffer, v uint32) {
+	b := make([]byte, 4)
+	order.PutUint32(b, v)
+	buf.Write(b)
+}
+
+func put64(buf *bytes.Buffer, v uint64) {
+	b := make([]byte, 8)
+	order.PutUint64(b, v)
+	buf.Write(b)
+}
+
+func putFloat(buf *bytes.Buffer, v float32) {
+	put32(buf, math.Float32bits(v))
+}
+
+func putFloat64(buf *bytes.Buffer, v float64) {
+	put64(buf, math.Float64bits(v))
+}
+
+func dataBlock(b block) []byte {
+	var buf bytes.Buffer
+
+	put32(&buf, chromId(b.chrom))
+	put32(&buf, b.intervals[0].start)
+	put32(&buf, b.intervals[len(b.intervals)-1].end)
+	put32(&buf, b.step)
+	put32(&buf, b.span)
+	buf.WriteByte(b.sectionType)
+	buf.WriteByte(0)
+	buf.Write(order.AppendUint16(nil, uint16(len(b.intervals))))
+
+	for _, iv := range b.intervals {
+		switch b.sectionType {
+		case sectionBedGraph:
+			put32(&buf, iv.start)
+			put32(&buf, iv.end)
+			putFloat(&buf, iv.value)
+		case sectionVarStep:
+			put32(&buf, iv.start)
+			putFloat(&buf, iv.value)
+		default:
+			putFloat(&buf, iv.value)
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// zoomBlock summarizes the intervals of a chromosome into records of
+// reduction bases
+func zoomBlock(name string) []byte {
+	type record struct {
+		validCount                uint32
+		min, max, sum, sumSquares float64
+	}
+
+	records := make(map[uint32]*record)
+
+	for _, b := range blocks {
+		if b.chrom != name {
+			continue
+		}
+
+		for _, iv := range b.intervals {
+			for base := iv.start; base < iv.end; base++ {
+				window := base / reduction
+
+				r, ok := records[window]
+
+				v := float64(iv.value)
+
+				if !ok {
+					r = &record{min: v, max: v}
+					records[window] = r
+				}
+
+				r.validCount++
+				r.min = math.Min(r.min, v)
+				r.max = math.Max(r.max, v)
+				r.sum += v
+				r.sumSquares += v * v
+			}
+		}
+	}
+
+	windows := make([]uint32, 0, len(records))
+
+	for w := range records {
+		windows = append(windows, w)
+	}
+
+	sort.Slice(windows, func(i, j int) bool { return windows[i] < windows[j] })
+
+	size := chroms[chromId(name)].size
+
+	var buf bytes.Buffer
+
+	for _, w := range windows {
+		r := records[w]
+
+		put32(&buf, chromId(name))
+		put32(&buf, w*reduction)
+		put32(&buf, min((w+1)*reduction, size))
+		put32(&buf, r.validCount)
+		putFloat(&buf, float32(r.min))
+		putFloat(&buf, float32(r.max))
+		putFloat(&buf, float32(r.sum))
+		putFloat(&buf, float32(r.sumSquares))
+	}
+
+	return buf.Bytes()
+}
+
+// rTree writes an index over items, which must be in order, with leaf
+// nodes of blockSize items under a single root
+func rTree(buf *bytes.Buffer, items []indexItem, endFileOffset uint64) {
+	first := items[0]
+	last := items[len(items)-1]
+
+	put32(buf, rTreeMagic)
+	put32(buf, blockSize)
+	put64(buf, uint64(len(items)))
+	put32(buf, first.chromId)
+	put32(buf, first.start)
+	put32(buf, last.chromId)
+	put32(buf, last.end)
+	put64(buf, endFileOffset)
+	put32(buf, 1)
+	put32(buf, 0)
+
+	leaves := make([][]indexItem, 0, len(items)/blockSize+1)
+
+	for i := 0; i < len(items); i += blockSize {
+		leaves = append(leaves, items[i:min(i+blockSize, len(items))])
+	}
+
+	if len(leaves) == 1 {
+		writeLeaf(buf, leaves[0])
+		return
+	}
+
+	// root node, whose children follow it
+	rootSize := 4 + len(leaves)*24
+	childOffset := uint64(buf.Len() + rootSize)
+
+	buf.Write([]byte{0, 0})
+	buf.Write(order.AppendUint16(nil, uint16(len(leaves))))
+
+	for _, leaf := range leaves {
+		put32(buf, leaf[0].chromId)
+		put32(buf, leaf[0].start)
+		put32(buf, leaf[len(leaf)-1].chromId)
+		put32(buf, leaf[len(leaf)-1].end)
+		put64(buf, childOffset)
+
+		childOffset += uint64(4 + len(leaf)*32)
+	}
+
+	for _, leaf := range leaves {
+		writeLeaf(buf, leaf)
+	}
+}
+
+func writeLeaf(buf *bytes.Buffer, items []indexItem) {
+	buf.Write([]byte{1, 0})
+	buf.Write(order.AppendUint16(nil, uint16(len(items))))
+
+	for _, item := range items {
+		put32(buf, item.chromId)
+		put32(buf, item.start)
+		put32(buf, item.chromId)
+		put32(buf, item.end)
+		put64(buf, item.offset)
+		put64(buf, item.size)
+	}
+}
+
+// chromTree writes a b+ tree with leaves of blockSize chromosomes
+func chromTree(buf *bytes.Buffer) {
+	keySize := 0
+
+	for _, c := range chroms {
+		keySize = max(keySize, len(c.name))
+	}
+
+	key := func(name string) []byte {
+		return append([]byte(name), make([]byte, keySize-len(name))...)
+	}
+
+	put32(buf, chromTreeMagic)
+	put32(buf, blockSize)
+	put32(buf, uint32(keySize))
+	put32(buf, 8)
+	put64(buf, uint64(len(chroms)))
+	put64(buf, 0)
+
+	leaves := (len(chroms) + blockSize - 1) / blockSize
+	childOffset := uint64(buf.Len() + 4 + leaves*(keySize+8))
+
+	buf.Write([]byte{0, 0})
+	buf.Write(order.AppendUint16(nil, uint16(leaves)))
+
+	for i := 0; i < len(chroms); i += blockSize {
+		buf.Write(key(chroms[i].name))
+		put64(buf, childOffset)
+
+		childOffset += uint64(4 + min(blockSize, len(chroms)-i)*(keySize+8))
+	}
+
+	for i := 0; i < len(chroms); i += blockSize {
+		leaf := chroms[i:min(i+blockSize, len(chroms))]
+
+		buf.Write([]byte{1, 0})
+		buf.Write(order.AppendUint16(nil, uint16(len(leaf))))
+
+		for j, c := range leaf {
+			buf.Write(key(c.name))
+			put32(buf, uint32(i+j))
+			put32(buf, c.size)
+		}
+	}
+}
+
+func write() []byte {
+	var buf bytes.Buffer
+
+	// header, zoom header and total summary are filled in at the end
+	buf.Write(make([]byte, 64+24+40))
+
+	chromTreeOffset := buf.Len()
+	chromTree(&buf)
+
+	fullDataOffset := buf.Len()
+	put64(&buf, uint64(len(blocks)))
+
+	maxBlock := 0
+	items := make([]indexItem, 0, len(blocks))
+
+	for _, b := range blocks {
+		data := dataBlock(b)
+		maxBlock = max(maxBlock, len(data))
+
+		z := compress(data)
+
+		items = append(items, indexItem{chromId: chromId(b.chrom),
+			start:  b.intervals[0].start,
+			end:    b.intervals[len(b.intervals)-1].end,
+			offset: uint64(buf.Len()),
+			size:   uint64(len(z))})
+
+		buf.Write(z)
+	}
+
+	fullIndexOffset := buf.Len()
+	rTree(&buf, items, uint64(fullIndexOffset))
+
+	zoomDataOffset := buf.Len()
+	zoomItems := make([]indexItem, 0, len(chroms))
+	zoomCount := 0
+
+	put32(&buf, 0)
+
+	for _, c := range chroms {
+		data := zoomBlock(c.name)
+
+		if len(data) == 0 {
+			continue
+		}
+
+		maxBlock = max(maxBlock, len(data))
+		zoomCount += len(data) / 32
+
+		z := compress(data)
+
+		zoomItems = append(zoomItems, indexItem{chromId: chromId(c.name),
+			start:  order.Uint32(data[4:]),
+			end:    order.Uint32(data[len(data)-32+8:]),
+			offset: uint64(buf.Len()),
+			size:   uint64(len(z))})
+
+		buf.Write(z)
+	}
+
+	zoomIndexOffset := buf.Len()
+	rTree(&buf, zoomItems, uint64(zoomIndexOffset))
+
+	put32(&buf, bigWigMagic)
+
+	out := buf.Bytes()
+
+	order.PutUint32(out, bigWigMagic)
+	order.PutUint16(out[4:], 4)
+	order.PutUint16(out[6:], 1)
+	order.PutUint64(out[8:], uint64(chromTreeOffset))
+	order.PutUint64(out[16:], uint64(fullDataOffset))
+	order.PutUint64(out[24:], uint64(fullIndexOffset))
+	order.PutUint64(out[44:], 64+24)
+	order.PutUint32(out[52:], uint32(maxBlock))
+
+	order.PutUint32(out[64:], reduction)
+	order.PutUint64(out[72:], uint64(zoomDataOffset))
+	order.PutUint64(out[80:], uint64(zoomIndexOffset))
+	order.PutUint32(out[zoomDataOffset:], uint32(zoomCount))
+
+	// total summary over every base with data
+	var total bytes.Buffer
+	var covered uint64
+	minV, maxV, sum, sumSquares := math.Inf(1), math.Inf(-1), 0.0, 0.0
+
+	for _, b := range blocks {
+		for _, iv := range b.intervals {
+			n := float64(iv.end - iv.start)
+			v := float64(iv.value)
+
+			covered += uint64(iv.end - iv.start)
+			minV = math.Min(minV, v)
+			maxV = math.Max(maxV, v)
+			sum += v * n
+			sumSquares += v * v * n
+		}
+	}
+
+	put64(&total, covered)
+	putFloat64(&total, minV)
+	putFloat64(&total, maxV)
+	putFloat64(&total, sum)
+	putFloat64(&total, sumSquares)
+
+	copy(out[64+24:], total.Bytes())
+
+	return out
+}
@@ -0,0 +1,447 @@
+package seqs
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/antonybholmes/go-dna"
+)
+
+// The binary bins format is a compact alternative to json for clients
+// that load many samples. Integers are varints, floats little endian.
+//
+//	magic "SQBN", version byte, uvarint location count, then per location:
+//	  chr string, uvarint start, uvarint end, uvarint sample count
+//	  per sample:
+//	    id, norm, stat, status, message strings
+//	    uvarint bin size, bin reads, reads; float64 ymax, bpm scale factor
+//	    flags byte, bit 0 set if dense, bit 1 if the bpm scale factor
+//	    is an estimate
+//	    sparse: uvarint n, n starts as varint deltas from the previous
+//	            start, n ends as varint deltas from their start, n float32
+//	    dense:  uvarint start, uvarint n, n float32 with NaN for null
+//
+// Strings are a uvarint length followed by utf-8 bytes.
+const (
+	BinsBinaryContentType = "application/vnd.seqs.bins"
+
+	binsBinaryVersion = 1
+
+	binsBinaryDense       = 1
+	binsBinaryBpmEstimate = 2
+
+	// sanity limit on bins per sample when decoding
+	maxBinsBinaryBins = 1 << 24
+)
+
+var (
+	binsBinaryMagic = []byte("SQBN")
+
+	ErrBadBinsBinary = errors.New("invalid binary bins data")
+)
+
+// LocationBinCounts is the bins of several samples at one location
+type LocationBinCounts struct {
+	Location *dna.Location      `json:"location"`
+	Samples  []*SampleBinCounts `json:"samples"`
+}
+
+type binsWriter struct {
+	w   *bufio.Writer
+	buf [binary.MaxVarintLen64]byte
+}
+
+func (bw *binsWriter) uvarint(v uint64) {
+	n := binary.PutUvarint(bw.buf[:], v)
+	bw.w.Write(bw.buf[:n])
+}
+
+func (bw *binsWriter) varint(v int64) {
+	n := binary.PutVarint(bw.buf[:], v)
+	bw.w.Write(bw.buf[:n])
+}
+
+func (bw *binsWriter) string(s string) {
+	bw.uvarint(uint64(len(s)))
+	bw.w.WriteString(s)
+}
+
+func (bw *binsWriter) float32(v float64) {
+	binary.LittleEndian.PutUint32(bw.buf[:4], math.Float32bits(float32(v)))
+	bw.w.Write(bw.buf[:4])
+}
+
+func (bw *binsWriter) float64(v float64) {
+	binary.LittleEndian.PutUint64(bw.buf[:8], math.Float64bits(v))
+	bw.w.Write(bw.buf[:8])
+}
+
+// EncodeBinsBinary writes bins in the binary bins format. Counts are
+// stored as float32.
+func EncodeBinsBinary(w io.Writer, locations []*LocationBinCounts) error {
+	bw := binsWriter{w: bufio.NewWriter(w)}
+
+	bw.w.Write(binsBinaryMagic)
+	bw.w.WriteByte(binsBinaryVersion)
+	bw.uvarint(uint64(len(locations)))
+
+	for _, location := range locations {
+		bw.string(location.Location.Chr())
+		bw.uvarint(uint64(location.Location.Start()))
+		bw.uvarint(uint64(location.Location.End()))
+		bw.uvarint(uint64(len(location.Samples)))
+
+		for _, sample := range location.Samples {
+			bw.encodeSample(sample)
+		}
+	}
+
+	return bw.w.Flush()
+}
+
+func (bw *binsWriter) encodeSample(sample *SampleBinCounts) {
+	bw.string(sample.Id)
+	bw.string(sample.Norm)
+	bw.string(sample.Stat)
+	bw.string(sample.Status)
+	bw.string(sample.Message)
+	bw.uvarint(uint64(sample.BinSize))
+	bw.uvarint(uint64(sample.BinReads))
+	bw.uvarint(uint64(sample.Reads))
+	bw.float64(sample.YMax)
+	bw.float64(sample.BpmScaleFactor)
+
+	var flags byte
+
+	if sample.BpmEstimate {
+		flags |= binsBinaryBpmEstimate
+	}
+
+	if sample.Values != nil {
+		bw.w.WriteByte(flags | binsBinaryDense)
+		bw.uvarint(uint64(sample.Start))
+		bw.uvarint(uint64(len(sample.Values)))
+
+		for _, v := range sample.Values {
+			bw.float32(v)
+		}
+
+		return
+	}
+
+	bw.w.WriteByte(flags)
+	bw.uvarint(uint64(len(sample.Bins)))
+
+	// columns so that similar numbers sit together
+	prev := 0
+
+	for _, bin := range sample.Bins {
+		bw.varint(int64(bin.Start - prev))
+		prev = bin.Start
+	}
+
+	for _, bin := range sample.Bins {
+		bw.varint(int64(bin.End - bin.Start))
+	}
+
+	for _, bin := range sample.Bins {
+		bw.float32(bin.Count)
+	}
+}
+
+type binsReader struct {
+	r   *bufio.Reader
+	buf [8]byte
+}
+
+// badVarint reports varints that overflow as invalid data while keeping
+// io errors from truncated input as they are
+func badVarint(err error) error {
+	if err == nil || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+
+	return fmt.Errorf("%w: %s", ErrBadBinsBinary, err)
+}
+
+func (br *binsReader) uvarint() (uint64, error) {
+	v, err := binary.ReadUvarint(br.r)
+
+	return v, badVarint(err)
+}
+
+func (br *binsReader) varint() (int64, error) {
+	v, err := binary.ReadVarint(br.r)
+
+	return v, badVarint(err)
+}
+
+// count reads a length that must be plausible for the data left so
+// corrupt input cannot cause huge allocations
+func (br *binsReader) count(max uint64) (int, error) {
+	n, err := br.uvarint()
+
+	if err != nil {
+		return 0, err
+	}
+
+	if n > max {
+		return 0, fmt.Errorf("%w: count %d is too large", ErrBadBinsBinary, n)
+	}
+
+	return int(n), nil
+}
+
+// int reads a coordinate, bin size or read count
+func (br *binsReader) int() (int, error) {
+	v, err := br.uvarint()
+
+	if err != nil {
+		return 0, err
+	}
+
+	if v > math.MaxInt32 {
+		return 0, fmt.Errorf("%w: %d is too large", ErrBadBinsBinary, v)
+	}
+
+	return int(v), nil
+}
+
+func (br *binsReader) string() (string, error) {
+	n, err := br.count(1 << 20)
+
+	if err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, n)
+
+	_, err = io.ReadFull(br.r, buf)
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(buf), nil
+}
+
+func (br *binsReader) float32() (float64, error) {
+	_, err := io.ReadFull(br.r, br.buf[:4])
+
+	if err != nil {
+		return 0, err
+	}
+
+	return float64(math.Float32frombits(binary.LittleEndian.Uint32(br.buf[:4]))), nil
+}
+
+func (br *binsReader) float64() (float64, error) {
+	_, err := io.ReadFull(br.r, br.buf[:8])
+
+	if err != nil {
+		return 0, err
+	}
+
+	return math.Float64frombits(binary.LittleEndian.Uint64(br.buf[:8])), nil
+}
+
+// DecodeBinsBinary reads data written by EncodeBinsBinary.
+func DecodeBinsBinary(r io.Reader) ([]*LocationBinCounts, error) {
+	br := binsReader{r: bufio.NewReader(r)}
+
+	header := make([]byte, len(binsBinaryMagic)+1)
+
+	_, err := io.ReadFull(br.r, header)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if string(header[:len(binsBinaryMagic)]) != string(binsBinaryMagic) {
+		return nil, ErrBadBinsBinary
+	}
+
+	if header[len(binsBinaryMagic)] != binsBinaryVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrBadBinsBinary, header[len(binsBinaryMagic)])
+	}
+
+	n, err := br.count(1 << 20)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]*LocationBinCounts, 0, n)
+
+	for range n {
+		location, err := br.decodeLocation()
+
+		if err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, location)
+	}
+
+	return ret, nil
+}
+
+func (br *binsReader) decodeLocation() (*LocationBinCounts, error) {
+	chr, err := br.string()
+
+	if err != nil {
+		return nil, err
+	}
+
+	start, err := br.int()
+
+	if err != nil {
+		return nil, err
+	}
+
+	end, err := br.int()
+
+	if err != nil {
+		return nil, err
+	}
+
+	location, err := dna.NewLocation(chr, start, end)
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrBadBinsBinary, err)
+	}
+
+	n, err := br.count(1 << 20)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ret := LocationBinCounts{Location: location, Samples: make([]*SampleBinCounts, 0, n)}
+
+	for range n {
+		sample, err := br.decodeSample()
+
+		if err != nil {
+			return nil, err
+		}
+
+		ret.Samples = append(ret.Samples, sample)
+	}
+
+	return &ret, nil
+}
+
+func (br *binsReader) decodeSample() (*SampleBinCounts, error) {
+	var sample SampleBinCounts
+	var err error
+
+	for _, s := range []*string{&sample.Id, &sample.Norm, &sample.Stat, &sample.Status, &sample.Message} {
+		*s, err = br.string()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, v := range []*int{&sample.BinSize, &sample.BinReads, &sample.Reads} {
+		*v, err = br.int()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sample.YMax, err = br.float64()
+
+	if err != nil {
+		return nil, err
+	}
+
+	sample.BpmScaleFactor, err = br.float64()
+
+	if err != nil {
+		return nil, err
+	}
+
+	flags, err := br.r.ReadByte()
+
+	if err != nil {
+		return nil, err
+	}
+
+	sample.BpmEstimate = flags&binsBinaryBpmEstimate != 0
+
+	if flags&binsBinaryDense != 0 {
+		sample.Bins = []*ReadBin{}
+
+		sample.Start, err = br.int()
+
+		if err != nil {
+			return nil, err
+		}
+
+		n, err := br.count(maxBinsBinaryBins)
+
+		if err != nil {
+			return nil, err
+		}
+
+		sample.Values = make(DenseValues, n)
+
+		for i := range sample.Values {
+			sample.Values[i], err = br.float32()
+
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return &sample, nil
+	}
+
+	n, err := br.count(maxBinsBinaryBins)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sample.Bins = make([]*ReadBin, n)
+
+	prev := 0
+
+	for i := range sample.Bins {
+		delta, err := br.varint()
+
+		if err != nil {
+			return nil, err
+		}
+
+		prev += int(delta)
+		sample.Bins[i] = &ReadBin{Start: prev}
+	}
+
+	for _, bin := range sample.Bins {
+		delta, err := br.varint()
+
+		if err != nil {
+			return nil, err
+		}
+
+		bin.End = bin.Start + int(delta)
+	}
+
+	for _, bin := range sample.Bins {
+		bin.Count, err = br.float32()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &sample, nil
+}
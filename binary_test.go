@@ -0,0 +1,201 @@
+package seqs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/antonybholmes/go-dna"
+)
+
+func binsLocation(t *testing.T, chr string, start int, end int) *dna.Location {
+	t.Helper()
+
+	location, err := dna.NewLocation(chr, start, end)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return location
+}
+
+// testBins has sparse, dense and error samples over two locations. Counts
+// are exact in float32.
+func testBins(t *testing.T) []*LocationBinCounts {
+	sparse := &SampleBinCounts{Id: "sparse",
+		Bins: []*ReadBin{
+			{Start: 1, End: 100, Count: 2},
+			{Start: 101, End: 300, Count: 0.5},
+			// gap before a long run
+			{Start: 90001, End: 100000, Count: -1.5},
+		},
+		YMax:           2,
+		BinSize:        100,
+		BpmScaleFactor: 0.25,
+		Reads:          4000000,
+		BinReads:       4100000,
+		Norm:           NormBPM,
+		Stat:           StatMean,
+		Status:         SampleStatusOk}
+
+	dense := &SampleBinCounts{Id: "dense",
+		Bins:        []*ReadBin{},
+		YMax:        7,
+		BinSize:     1000,
+		BinReads:    12,
+		BpmEstimate: true,
+		Norm:        NormRaw,
+		Stat:        StatMax,
+		Status:      SampleStatusOk,
+		Start:       1001,
+		Values:      DenseValues{1, math.NaN(), 0, 7, math.NaN()}}
+
+	forbidden := NewSampleBinCountsError("forbidden", 100, ErrPermissionDenied)
+
+	return []*LocationBinCounts{
+		{Location: binsLocation(t, "chr1", 1, 100000), Samples: []*SampleBinCounts{sparse, dense, forbidden}},
+		{Location: binsLocation(t, "chrX", 5000, 6000), Samples: []*SampleBinCounts{
+			{Id: "empty", Bins: []*ReadBin{}, BinSize: 10, Norm: NormRaw, Status: SampleStatusOk},
+		}},
+	}
+}
+
+func encodeBins(t *testing.T, locations []*LocationBinCounts) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	err := EncodeBinsBinary(&buf, locations)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func sameValue(a float64, b float64) bool {
+	return a == b || (math.IsNaN(a) && math.IsNaN(b))
+}
+
+func checkSample(t *testing.T, got *SampleBinCounts, want *SampleBinCounts) {
+	t.Helper()
+
+	if got.Id != want.Id || got.Norm != want.Norm || got.Stat != want.Stat ||
+		got.Status != want.Status || got.Message != want.Message ||
+		got.BinSize != want.BinSize || got.BinReads != want.BinReads || got.Reads != want.Reads ||
+		got.YMax != want.YMax || got.BpmScaleFactor != want.BpmScaleFactor ||
+		got.BpmEstimate != want.BpmEstimate || got.Start != want.Start {
+		t.Errorf("sample %+v, want %+v", *got, *want)
+	}
+
+	if len(got.Bins) != len(want.Bins) {
+		t.Fatalf("sample %s has %d bins, want %d", want.Id, len(got.Bins), len(want.Bins))
+	}
+
+	for i, bin := range got.Bins {
+		if *bin != *want.Bins[i] {
+			t.Errorf("sample %s bin %d is %+v, want %+v", want.Id, i, *bin, *want.Bins[i])
+		}
+	}
+
+	if (got.Values == nil) != (want.Values == nil) || len(got.Values) != len(want.Values) {
+		t.Fatalf("sample %s values %v, want %v", want.Id, got.Values, want.Values)
+	}
+
+	for i, v := range got.Values {
+		if !sameValue(v, want.Values[i]) {
+			t.Errorf("sample %s value %d is %f, want %f", want.Id, i, v, want.Values[i])
+		}
+	}
+}
+
+func TestBinsBinaryRoundTrip(t *testing.T) {
+	want := testBins(t)
+
+	got, err := DecodeBinsBinary(bytes.NewReader(encodeBins(t, want)))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("%d locations, want %d", len(got), len(want))
+	}
+
+	for li, location := range got {
+		if location.Location.String() != want[li].Location.String() {
+			t.Errorf("location %s, want %s", location.Location, want[li].Location)
+		}
+
+		if len(location.Samples) != len(want[li].Samples) {
+			t.Fatalf("%s has %d samples, want %d", location.Location, len(location.Samples), len(want[li].Samples))
+		}
+
+		for si, sample := range location.Samples {
+			checkSample(t, sample, want[li].Samples[si])
+		}
+	}
+
+	if got[0].Samples[2].Status != SampleStatusForbidden || got[0].Samples[2].Message == "" {
+		t.Errorf("error sample lost its status %+v", *got[0].Samples[2])
+	}
+}
+
+// badBinsError is whether an error is one decoding is allowed to return
+// for bad input
+func badBinsError(err error) bool {
+	return errors.Is(err, ErrBadBinsBinary) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func TestBinsBinaryTruncated(t *testing.T) {
+	data := encodeBins(t, testBins(t))
+
+	for n := 0; n < len(data); n++ {
+		_, err := DecodeBinsBinary(bytes.NewReader(data[:n]))
+
+		if !badBinsError(err) {
+			t.Errorf("truncated to %d bytes: error %v", n, err)
+		}
+	}
+}
+
+func TestBinsBinaryCorrupt(t *testing.T) {
+	data := encodeBins(t, testBins(t))
+
+	// every byte set to values that break lengths, varints and strings;
+	// decoding may succeed but must not panic or return other errors
+	for i := range data {
+		for _, b := range []byte{0x00, 0x7f, 0x80, 0xff} {
+			corrupt := bytes.Clone(data)
+			corrupt[i] = b
+
+			_, err := DecodeBinsBinary(bytes.NewReader(corrupt))
+
+			if err != nil && !badBinsError(err) {
+				t.Errorf("byte %d set to %#x: error %v", i, b, err)
+			}
+		}
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"bad magic", []byte("SQBX\x01\x00")},
+		{"unsupported version", []byte("SQBN\x09\x00")},
+		{"too many locations", []byte("SQBN\x01\xff\xff\xff\xff\x0f")},
+		{"varint overflow", append([]byte("SQBN\x01"), bytes.Repeat([]byte{0xff}, 11)...)},
+	}
+
+	for _, test := range tests {
+		_, err := DecodeBinsBinary(bytes.NewReader(test.data))
+
+		if !errors.Is(err, ErrBadBinsBinary) {
+			t.Errorf("%s: error %v, want %v", test.name, err, ErrBadBinsBinary)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package seqs
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/antonybholmes/go-dna"
+	"github.com/antonybholmes/go-sys/log"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	DefaultBinCacheBytes = 256 * 1024 * 1024
+
+	DefaultBinCacheTtl = 24 * time.Hour
+
+	binCacheKeyPrefix = "seqs:bins:v1:"
+)
+
+type (
+	// CacheableSeqReader is implemented by readers whose results can be
+	// cached. The key identifies the sample, bin size, stat and version
+	// of the data so that a changed file is never served from the cache.
+	// Readers record the version of the data they actually read in the
+	// counts they return, which is the version the results are stored
+	// under.
+	CacheableSeqReader interface {
+		SeqReader
+		// Version identifies the current state of the data
+		Version() (string, error)
+		CacheKey(version string) string
+	}
+
+	// BinCacheBackend stores encoded bins
+	BinCacheBackend interface {
+		Get(ctx context.Context, key string) ([]byte, bool, error)
+		Set(ctx context.Context, key string, data []byte) error
+	}
+
+	BinCacheStats struct {
+		Hits   uint64 `json:"hits"`
+		Misses uint64 `json:"misses"`
+	}
+
+	// BinCache caches normalized bins in front of the readers
+	BinCache struct {
+		backend BinCacheBackend
+		hits    atomic.Uint64
+		misses  atomic.Uint64
+	}
+
+	memoryBinCacheEntry struct {
+		key  string
+		data []byte
+	}
+
+	// MemoryBinCacheBackend is an in process LRU bounded by the total
+	// size of the cached data
+	MemoryBinCacheBackend struct {
+		maxBytes int
+		bytes    int
+		entries  map[string]*list.Element
+		// front is most recently used
+		lru *list.List
+		mu  sync.Mutex
+	}
+
+	// RedisClient is the part of a redis client the cache uses, so that
+	// a fake can be used instead of a server
+	RedisClient interface {
+		Get(ctx context.Context, key string) *redis.StringCmd
+		Set(ctx context.Context, key string, value any, expiration time.Duration) *redis.StatusCmd
+	}
+
+	// RedisBinCacheBackend shares cached bins between servers. Entries
+	// expire after ttl so that bins of deleted or changed files, whose
+	// keys are never asked for again, do not build up.
+	RedisBinCacheBackend struct {
+		client RedisClient
+		ttl    time.Duration
+	}
+)
+
+func NewBinCache(backend BinCacheBackend) *BinCache {
+	return &BinCache{backend: backend}
+}
+
+func (cache *BinCache) Stats() BinCacheStats {
+	return BinCacheStats{Hits: cache.hits.Load(), Misses: cache.misses.Load()}
+}
+
+// BinCounts returns the normalized bins of a reader, using the cache if
+// the reader supports it. Cache errors are logged and the reader used
+// instead, so a cache outage only makes things slower.
+func (cache *BinCache) BinCounts(ctx context.Context, reader SeqReader, location *dna.Location, norm string, scale float64) (*SampleBinCounts, error) {
+	cacheable, ok := reader.(CacheableSeqReader)
+
+	if !ok || cache == nil {
+		return normalizedBinCounts(ctx, reader, location, norm, scale)
+	}
+
+	version, err := cacheable.Version()
+
+	if err != nil {
+		// the file is probably missing so let the reader report it
+		return normalizedBinCounts(ctx, reader, location, norm, scale)
+	}
+
+	key := binCacheKey(cacheable.CacheKey(version), location, norm, scale)
+
+	data, ok, err := cache.backend.Get(ctx, key)
+
+	if err != nil {
+		log.Debug().Msgf("bin cache get %s: %s", key, err)
+	}
+
+	if ok {
+		var counts SampleBinCounts
+
+		err = json.Unmarshal(data, &counts)
+
+		if err == nil {
+			cache.hits.Add(1)
+			return &counts, nil
+		}
+
+		log.Debug().Msgf("bin cache decode %s: %s", key, err)
+	}
+
+	cache.misses.Add(1)
+
+	counts, err := normalizedBinCounts(ctx, reader, location, norm, scale)
+
+	// only complete results are cached, and only if the reader says
+	// which version of the data it read
+	if err != nil || counts.Status != SampleStatusOk || counts.version == "" {
+		return counts, err
+	}
+
+	// the file may have changed since the key was made, in which case
+	// the results belong to the new version
+	if counts.version != version {
+		key = binCacheKey(cacheable.CacheKey(counts.version), location, norm, scale)
+	}
+
+	data, err = json.Marshal(counts)
+
+	if err == nil {
+		err = cache.backend.Set(ctx, key, data)
+	}
+
+	if err != nil {
+		log.Debug().Msgf("bin cache set %s: %s", key, err)
+	}
+
+	return counts, nil
+}
+
+func binCacheKey(readerKey string, location *dna.Location, norm string, scale float64) string {
+	return binCacheKeyPrefix + readerKey + ":" + location.String() + ":" + norm + ":" + strconv.FormatFloat(scale, 'g', -1, 64)
+}
+
+func normalizedBinCounts(ctx context.Context, reader SeqReader, location *dna.Location, norm string, scale float64) (*SampleBinCounts, error) {
+	counts, err := reader.BinCounts(ctx, location)
+
+	counts.Normalize(norm, scale)
+
+	return counts, err
+}
+
+// fileVersion identifies the state of a file by its modification time
+// and size, which is cheaper than a checksum and changes whenever a
+// sample is rebuilt.
+func fileVersion(path string) (string, error) {
+	info, err := os.Stat(path)
+
+	if err != nil {
+		return "", err
+	}
+
+	return fileInfoVersion(info), nil
+}
+
+// fileInfoVersion is the fileVersion of a file that is already open
+func fileInfoVersion(info os.FileInfo) string {
+	return strconv.FormatInt(info.ModTime().UnixNano(), 36) + "-" + strconv.FormatInt(info.Size(), 36)
+}
+
+// readerCacheKey is the cache key of a reader of a version of a file.
+// It includes what the bins depend on from the catalogue, the library
+// size used by cpm and rpkm and the chromosome sizes bins are clipped to,
+// so that rebuilding the catalogue but not the sample changes the key.
+func readerCacheKey(sample *Sample, version string, binSize int, stat string) string {
+	return fmt.Sprintf("%s:%s:%d:%s:%d:%s", sample.Id, version, binSize, stat, sample.Reads, sample.chromSizesVersion())
+}
+
+func NewMemoryBinCacheBackend(maxBytes int) *MemoryBinCacheBackend {
+	if maxBytes < 1 {
+		maxBytes = DefaultBinCacheBytes
+	}
+
+	return &MemoryBinCacheBackend{
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+func (backend *MemoryBinCacheBackend) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+
+	element, ok := backend.entries[key]
+
+	if !ok {
+		return nil, false, nil
+	}
+
+	backend.lru.MoveToFront(element)
+
+	return element.Value.(*memoryBinCacheEntry).data, true, nil
+}
+
+func (backend *MemoryBinCacheBackend) Set(ctx context.Context, key string, data []byte) error {
+	// never worth evicting everything for
+	if len(data) > backend.maxBytes/2 {
+		return nil
+	}
+
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+
+	element, ok := backend.entries[key]
+
+	if ok {
+		entry := element.Value.(*memoryBinCacheEntry)
+		backend.bytes += len(data) - len(entry.data)
+		entry.data = data
+		backend.lru.MoveToFront(element)
+	} else {
+		backend.entries[key] = backend.lru.PushFront(&memoryBinCacheEntry{key: key, data: data})
+		backend.bytes += len(data)
+	}
+
+	for backend.bytes > backend.maxBytes {
+		entry := backend.lru.Remove(backend.lru.Back()).(*memoryBinCacheEntry)
+		delete(backend.entries, entry.key)
+		backend.bytes -= len(entry.data)
+	}
+
+	return nil
+}
+
+func NewRedisBinCacheBackend(client RedisClient, ttl time.Duration) *RedisBinCacheBackend {
+	if ttl <= 0 {
+		ttl = DefaultBinCacheTtl
+	}
+
+	return &RedisBinCacheBackend{client: client, ttl: ttl}
+}
+
+func (backend *RedisBinCacheBackend) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	data, err := backend.client.Get(ctx, key).Bytes()
+
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, false, nil
+		}
+
+		return nil, false, err
+	}
+
+	return data, true, nil
+}
+
+func (backend *RedisBinCacheBackend) Set(ctx context.Context, key string, data []byte) error {
+	return backend.client.Set(ctx, key, data, backend.ttl).Err()
+}
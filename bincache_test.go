@@ -0,0 +1,302 @@
+package seqs
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/antonybholmes/go-dna"
+	"github.com/redis/go-redis/v9"
+)
+
+// fakeRedis is an in memory RedisClient
+type fakeRedis struct {
+	values map[string]string
+	ttls   map[string]time.Duration
+	// if set, every command fails as if the server were down
+	err error
+	mu  sync.Mutex
+}
+
+func newFakeRedis() *fakeRedis {
+	return &fakeRedis{values: make(map[string]string), ttls: make(map[string]time.Duration)}
+}
+
+func (client *fakeRedis) Get(ctx context.Context, key string) *redis.StringCmd {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	if client.err != nil {
+		return redis.NewStringResult("", client.err)
+	}
+
+	value, ok := client.values[key]
+
+	if !ok {
+		return redis.NewStringResult("", redis.Nil)
+	}
+
+	return redis.NewStringResult(value, nil)
+}
+
+func (client *fakeRedis) Set(ctx context.Context, key string, value any, expiration time.Duration) *redis.StatusCmd {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	if client.err != nil {
+		return redis.NewStatusResult("", client.err)
+	}
+
+	client.values[key] = string(value.([]byte))
+	client.ttls[key] = expiration
+
+	return redis.NewStatusResult("OK", nil)
+}
+
+// versionedReader returns one bin and claims to have read a version of
+// its data that may differ from the one it reports beforehand, as
+// happens when a file is replaced between the two
+type versionedReader struct {
+	version     string
+	readVersion string
+	reads       int
+}
+
+func (reader *versionedReader) Version() (string, error) {
+	return reader.version, nil
+}
+
+func (reader *versionedReader) CacheKey(version string) string {
+	return "test:" + version
+}
+
+func (reader *versionedReader) BinCounts(ctx context.Context, location *dna.Location) (*SampleBinCounts, error) {
+	reader.reads++
+
+	return &SampleBinCounts{Id: "test",
+		Bins:    []*ReadBin{{Start: 1, End: 100, Count: 1}},
+		BinSize: 100,
+		Norm:    NormRaw,
+		Status:  SampleStatusOk,
+		version: reader.readVersion}, nil
+}
+
+func cacheLocation(t *testing.T) *dna.Location {
+	t.Helper()
+
+	location, err := dna.NewLocation("chr1", 1, 100)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return location
+}
+
+func cachedCount(t *testing.T, cache *BinCache, reader SeqReader) float64 {
+	t.Helper()
+
+	counts, err := cache.BinCounts(context.Background(), reader, cacheLocation(t), NormRaw, 1)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(counts.Bins) != 1 {
+		t.Fatalf("bins %v, want one bin", counts.Bins)
+	}
+
+	return counts.Bins[0].Count
+}
+
+func TestBinCacheRebuiltSampleDB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sample.db")
+
+	writeSampleDB(t, path, 5)
+
+	pool := NewSampleDBPool(4)
+	defer pool.Close()
+
+	cache := NewBinCache(NewMemoryBinCacheBackend(0))
+
+	sample := &Sample{Id: "sample"}
+
+	reader, err := NewDBSeqReader(sample, path, 100, StatSum, pool)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 {
+		if n := cachedCount(t, cache, reader); n != 5 {
+			t.Errorf("count %f, want 5", n)
+		}
+	}
+
+	// the pool and the cache both see the new file
+	rebuildSampleDB(t, path, 9, time.Minute)
+
+	if n := cachedCount(t, cache, reader); n != 9 {
+		t.Errorf("count %f after rebuild, want 9", n)
+	}
+
+	stats := cache.Stats()
+
+	if stats.Hits != 1 || stats.Misses != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestBinCacheReadVersion(t *testing.T) {
+	backend := NewMemoryBinCacheBackend(0)
+	cache := NewBinCache(backend)
+
+	location := cacheLocation(t)
+
+	// the file changed after the key was made
+	reader := &versionedReader{version: "v1", readVersion: "v2"}
+
+	cachedCount(t, cache, reader)
+
+	_, ok, _ := backend.Get(context.Background(), binCacheKey("test:v1", location, NormRaw, 1))
+
+	if ok {
+		t.Errorf("results of v2 were cached as v1")
+	}
+
+	_, ok, _ = backend.Get(context.Background(), binCacheKey("test:v2", location, NormRaw, 1))
+
+	if !ok {
+		t.Errorf("results were not cached as v2")
+	}
+
+	// once the new version is seen the cached results are used
+	reader.version = "v2"
+
+	cachedCount(t, cache, reader)
+
+	if reader.reads != 1 {
+		t.Errorf("%d reads, want 1", reader.reads)
+	}
+
+	// readers that cannot say what they read are not cached
+	reader = &versionedReader{version: "v3"}
+
+	cachedCount(t, cache, reader)
+	cachedCount(t, cache, reader)
+
+	if reader.reads != 2 {
+		t.Errorf("%d reads, want 2", reader.reads)
+	}
+}
+
+func TestBinCacheRedis(t *testing.T) {
+	client := newFakeRedis()
+
+	cache := NewBinCache(NewRedisBinCacheBackend(client, 0))
+
+	reader := &versionedReader{version: "v1", readVersion: "v1"}
+
+	cachedCount(t, cache, reader)
+	cachedCount(t, cache, reader)
+
+	if reader.reads != 1 {
+		t.Errorf("%d reads, want 1", reader.reads)
+	}
+
+	key := binCacheKey("test:v1", cacheLocation(t), NormRaw, 1)
+
+	if client.ttls[key] != DefaultBinCacheTtl {
+		t.Errorf("ttl %s, want %s", client.ttls[key], DefaultBinCacheTtl)
+	}
+
+	// a redis outage falls back to the reader
+	client.err = errors.New("connection refused")
+
+	if n := cachedCount(t, cache, reader); n != 1 {
+		t.Errorf("count %f, want 1", n)
+	}
+
+	if reader.reads != 2 {
+		t.Errorf("%d reads, want 2", reader.reads)
+	}
+
+	stats := cache.Stats()
+
+	if stats.Hits != 1 || stats.Misses != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestBinCacheRebuiltCatalogue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sample.db")
+
+	writeSampleDB(t, path, 5)
+
+	pool := NewSampleDBPool(4)
+	defer pool.Close()
+
+	cache := NewBinCache(NewMemoryBinCacheBackend(0))
+
+	location := cacheLocation(t)
+
+	// read a sample as a catalogue describes it
+	binCounts := func(sample *Sample) *ReadBin {
+		t.Helper()
+
+		reader, err := NewDBSeqReader(sample, path, 100, StatSum, pool)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		counts, err := cache.BinCounts(context.Background(), reader, location, NormCPM, 1)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(counts.Bins) != 1 {
+			t.Fatalf("bins %v, want one bin", counts.Bins)
+		}
+
+		return counts.Bins[0]
+	}
+
+	chr1 := func(size int) *assemblyChromSizes {
+		chrs := []*ChromSize{{Chr: "chr1", Size: size}}
+
+		return &assemblyChromSizes{chrs: chrs, sizes: map[string]int{"chr1": size}, version: chromSizesVersion(chrs)}
+	}
+
+	sample := &Sample{Id: "sample", Reads: 1000000, chromSizes: chr1(1000)}
+
+	for range 2 {
+		if bin := binCounts(sample); bin.Count != 5 || bin.End != 100 {
+			t.Errorf("bin %+v, want 5 ending at 100", *bin)
+		}
+	}
+
+	// the library size changed
+	sample = &Sample{Id: "sample", Reads: 2000000, chromSizes: chr1(1000)}
+
+	if bin := binCounts(sample); bin.Count != 2.5 {
+		t.Errorf("count %f after the reads changed, want 2.5", bin.Count)
+	}
+
+	// the chromosome is shorter
+	sample = &Sample{Id: "sample", Reads: 2000000, chromSizes: chr1(50)}
+
+	if bin := binCounts(sample); bin.End != 50 {
+		t.Errorf("bin ends at %d after the chromosome size changed, want 50", bin.End)
+	}
+
+	stats := cache.Stats()
+
+	if stats.Hits != 1 || stats.Misses != 3 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
@@ -0,0 +1,14 @@
+#!/bin/bash
+# go-sqlite3 only includes FTS5 with the sqlite_fts5 build tag. seqs-ingest
+# needs it to write the samples search index and SeqDB to rank searches
+# with it, so servers that import this module must be built with the tag
+# too, e.g. go build -tags sqlite_fts5, or have it set for all commands
+# with go env -w GOFLAGS=-tags=sqlite_fts5
+
+set -e
+
+tags="sqlite_fts5"
+
+go vet -tags ${tags} ./...
+go test -tags ${tags} ./...
+go build -tags ${tags} -o bin/seqs-ingest ./cmd/seqs-ingest
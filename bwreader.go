@@ -1,11 +1,12 @@
 package seqs
 
 import (
-	"os/exec"
-	"strconv"
-	"strings"
+	"context"
+	"fmt"
+	"os"
 
 	"github.com/antonybholmes/go-dna"
+	"github.com/antonybholmes/go-seqs/bigwig"
 	"github.com/antonybholmes/go-sys/log"
 	basemath "github.com/antonybholmes/go-sys/math"
 )
@@ -14,25 +15,36 @@ type BigWigSeqReader struct {
 	sample  *Sample
 	url     string
 	binSize int
+	stat    string
 }
 
-const (
-	BigWigSummaryCmd = "bin/bigWigSummary"
-)
-
-func NewBigWigReader(sample *Sample, binSize int) (SeqReader, error) {
+func NewBigWigReader(sample *Sample, binSize int, stat string) (SeqReader, error) {
+	// older catalogues store remote bigwigs with the BigWig type so
+	// route any url to the remote reader
+	if bigwig.IsUrl(sample.Url) {
+		return NewRemoteBigWigReader(sample, binSize, stat)
+	}
 
 	return &BigWigSeqReader{
 		sample:  sample,
 		url:     sample.Url,
 		binSize: binSize,
+		stat:    stat,
 
 		// estimate the number of bins to represent a region
 		//defaultBinCount: binSize * 4,
 	}, nil
 }
 
-func (reader *BigWigSeqReader) BinCounts(location *dna.Location) (*SampleBinCounts, error) {
+func (reader *BigWigSeqReader) Version() (string, error) {
+	return fileVersion(reader.url)
+}
+
+func (reader *BigWigSeqReader) CacheKey(version string) string {
+	return readerCacheKey(reader.sample, version, reader.binSize, reader.stat)
+}
+
+func (reader *BigWigSeqReader) BinCounts(ctx context.Context, location *dna.Location) (*SampleBinCounts, error) {
 
 	log.Debug().Msgf("getting bigwig summary for location %s with bin size %d and url %s", location, reader.binSize, reader.url)
 
@@ -42,16 +54,29 @@ func (reader *BigWigSeqReader) BinCounts(location *dna.Location) (*SampleBinCoun
 		Bins:    make([]*ReadBin, 0, reader.binSize),
 		YMax:    0,
 		BinSize: reader.binSize,
+		Reads:   reader.sample.Reads,
+		Norm:    NormRaw,
+		Stat:    reader.stat,
+		Status:  SampleStatusOk,
 	}
 
-	readBins, err := getBigWigSummary(reader.url, location, reader.binSize)
+	chrSize := reader.sample.ChrSize(location.Chr())
+
+	location, err := clampLocation(location, chrSize)
+
+	if err != nil {
+		return &ret, err
+	}
+
+	readBins, version, err := getBigWigSummary(ctx, reader.url, location, reader.binSize, reader.stat)
 
 	if err != nil {
 		log.Debug().Msgf("error reading bigwig summary %s %s", reader.url, err)
 		return &ret, err
 	}
 
-	ret.Bins = readBins
+	ret.version = version
+	ret.Bins = clipBins(readBins, chrSize)
 
 	for _, bin := range ret.Bins {
 		ret.YMax = basemath.Max(ret.YMax, bin.Count)
@@ -64,7 +89,8 @@ func (reader *BigWigSeqReader) BinCounts(location *dna.Location) (*SampleBinCoun
 // and the end is a multiple of the bin size. This is necessary because bigwig summary
 // requires locations to be aligned to the bin size.
 func alignLocToBinSize(location *dna.Location, binSize int) (*dna.Location, error) {
-	start := (location.Start())/binSize*binSize + 1
+	// 1-based so a start on a bin boundary stays in its bin
+	start := (location.Start()-1)/binSize*binSize + 1
 
 	// align end to be a multiple of bin size
 	end := ((location.End()-1)/binSize + 1) * binSize
@@ -79,66 +105,142 @@ func alignLocToBinSize(location *dna.Location, binSize int) (*dna.Location, erro
 	return loc, nil
 }
 
-func getBigWigSummary(url string, location *dna.Location, binSize int) ([]*ReadBin, error) {
+// getBigWigSummary also returns the fileVersion of the bigwig it read
+func getBigWigSummary(ctx context.Context, url string, location *dna.Location, binSize int, stat string) ([]*ReadBin, string, error) {
 	// ensure aligned to bin size by aligning start and end to the nearest multiple of bin size
 	// for example, if bin size is 1000, and location is chr1:1500-2500, we would align to chr1:1000-3000
 	// if location is chr1:500-1500, we would align to chr1:0-2000
 	locBinSizeAligned, err := alignLocToBinSize(location, binSize)
 
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	//log.Debug().Msgf("location %s aligned to %s", location, locBinSizeAligned)
+	f, err := os.Open(url)
 
-	start0 := locBinSizeAligned.Start() - 1
+	if err != nil {
+		return nil, "", err
+	}
+
+	defer f.Close()
+
+	info, err := f.Stat()
+
+	if err != nil {
+		return nil, "", err
+	}
 
-	// bigWigSummary returns a number of bins, so we must calculate the number of bins to return based on the location length and bin size
-	bins := locBinSizeAligned.Len() / binSize
+	bw, err := bigwig.OpenContext(ctx, f)
 
-	//log.Debug().Msgf("getting bigwig summary for location %s with bin size %d and url %s, calculated bins %d", locBinSizeAligned, binSize, url, bins)
+	if err != nil {
+		return nil, "", err
+	}
 
-	cmd := exec.Command(
-		BigWigSummaryCmd,
-		url,
-		locBinSizeAligned.Chr(),
-		strconv.Itoa(start0),
-		strconv.Itoa(locBinSizeAligned.End()),
-		strconv.Itoa(bins),
-	)
+	bins, err := bigWigSummaryBins(ctx, bw, locBinSizeAligned, binSize, stat)
+
+	return bins, fileInfoVersion(info), err
+}
 
-	out, err := cmd.Output()
+// bigWigSummaryBins summarizes an aligned location into bins of binSize
+// using a stat as bigWigSummary does. Every bin is returned, with bins
+// that have no data as 0, since that is the form clients of bigwig
+// samples have always had. The location is cut at the end of the
+// chromosome, where the final bin is summarized over the bases that
+// remain.
+func bigWigSummaryBins(ctx context.Context, bw *bigwig.BigWig, locBinSizeAligned *dna.Location, binSize int, stat string) ([]*ReadBin, error) {
+	chrom, err := bw.Chrom(bigWigChr(bw, locBinSizeAligned.Chr()))
 
 	if err != nil {
 		return nil, err
 	}
 
-	values := strings.Fields(string(out))
-	result := make([]*ReadBin, len(values))
-	start := locBinSizeAligned.Start()
-	binEndWidth := binSize - 1
+	chrSize := int(chrom.Size)
 
-	for i, v := range values {
-		bin := &ReadBin{
-			Start: start,
-			End:   start + binEndWidth,
+	start0 := locBinSizeAligned.Start() - 1
+	end := min(locBinSizeAligned.End(), chrSize)
+
+	if start0 >= end {
+		return nil, fmt.Errorf("%w: %s starts after %d", ErrLocationOutOfRange, locBinSizeAligned, chrSize)
+	}
+
+	// we must calculate the number of bins to return based on the location length and bin size
+	bins := (end - start0) / binSize
+	partial := (end - start0) % binSize
+
+	values := make([]float64, 0, bins+1)
+
+	if bins > 0 {
+		summaries, err := bw.Summaries(ctx, chrom.Name, start0, start0+bins*binSize, bins)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, summary := range summaries {
+			values = append(values, bigWigSummaryValue(summary, binSize, stat))
 		}
+	}
+
+	if partial > 0 {
+		summaries, err := bw.Summaries(ctx, chrom.Name, end-partial, end, 1)
 
-		start += binSize
-
-		if v == "nan" {
-			bin.Count = 0
-		} else {
-			// convert string to float64
-			f, err := strconv.ParseFloat(v, 64)
-			if err != nil {
-				return nil, err
-			}
-			bin.Count = f
+		if err != nil {
+			return nil, err
 		}
 
-		result[i] = bin
+		values = append(values, bigWigSummaryValue(summaries[0], partial, stat))
+	}
+
+	return clipBins(stepBinCounts(values, start0/binSize, binSize), chrSize), nil
+}
+
+// stepBinCounts gives every bin its own ReadBin, including empty ones,
+// unlike mergeBinCounts
+func stepBinCounts(values []float64, startBin int, binSize int) []*ReadBin {
+	ret := make([]*ReadBin, len(values))
+
+	for i, v := range values {
+		b := startBin + i
+
+		// 1-based inclusive coordinates
+		ret[i] = &ReadBin{Start: b*binSize + 1, End: (b + 1) * binSize, Count: v}
+	}
+
+	return ret
+}
+
+func bigWigSummaryValue(summary *bigwig.Summary, width int, stat string) float64 {
+	if !summary.HasData() {
+		return 0
+	}
+
+	return bigWigStat(summary, width, stat)
+}
+
+func bigWigStat(summary *bigwig.Summary, binSize int, stat string) float64 {
+	switch stat {
+	case StatMax:
+		return summary.Max
+	case StatMin:
+		return summary.Min
+	case StatSum:
+		return summary.Sum
+	case StatCoverage:
+		return summary.Coverage(binSize)
+	case StatStd:
+		return summary.Std()
+	default:
+		return summary.Mean()
+	}
+}
+
+// bigWigChr is the name a bigwig uses for a chromosome
+func bigWigChr(bw *bigwig.BigWig, chr string) string {
+	names := make([]string, 0, len(bw.Chroms()))
+
+	for _, c := range bw.Chroms() {
+		names = append(names, c.Name)
 	}
 
-	return result, nil
+	return defaultNaming.SourceChr(chr, names)
 }
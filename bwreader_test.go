@@ -0,0 +1,96 @@
+package seqs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/antonybholmes/go-dna"
+)
+
+func TestBigWigBinCounts(t *testing.T) {
+	sample := &Sample{Id: "fixture", Url: "bigwig/testdata/fixture.bw", Type: SampleTypeBigWig}
+
+	reader, err := NewBigWigReader(sample, 1000, StatMean)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	location, err := dna.NewLocation("chr1", 1, 8000)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts, err := reader.BinCounts(context.Background(), location)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// bins are 1-based and, as bigWigSummary gave them, every bin is
+	// returned with empty bins as 0, see the intervals in
+	// bigwig/bigwig_test.go
+	want := []ReadBin{
+		{Start: 1, End: 1000, Count: 3},
+		{Start: 1001, End: 2000, Count: 10},
+		{Start: 2001, End: 3000, Count: 0},
+		{Start: 3001, End: 4000, Count: 0},
+		{Start: 4001, End: 5000, Count: -1.5},
+		{Start: 5001, End: 6000, Count: 0},
+		{Start: 6001, End: 7000, Count: 2},
+		{Start: 7001, End: 8000, Count: 0},
+	}
+
+	if len(counts.Bins) != len(want) {
+		t.Fatalf("bins %v, want %v", counts.Bins, want)
+	}
+
+	for i, bin := range counts.Bins {
+		if *bin != want[i] {
+			t.Errorf("bin %d is %+v, want %+v", i, *bin, want[i])
+		}
+	}
+
+	if counts.YMax != 10 || counts.BinSize != 1000 || counts.Status != SampleStatusOk {
+		t.Errorf("unexpected counts %+v", counts)
+	}
+
+	// the last bin of chr1 is cut at the end of the chromosome and the
+	// 100 bases from 49900 have a value of 8
+	location, err = dna.NewLocation("chr1", 49001, 51000)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err = NewBigWigReader(sample, 300, StatCoverage)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts, err = reader.BinCounts(context.Background(), location)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(counts.Bins) != 4 || *counts.Bins[3] != (ReadBin{Start: 49801, End: 50000, Count: 0.5}) {
+		t.Errorf("unexpected bins %v", counts.Bins)
+	}
+
+	// dense output of the same bins, for the location clamped to the
+	// chromosome as routes do
+	location, err = dna.NewLocation("chr1", 49001, 50000)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts.Densify(location, FillNull)
+
+	if counts.Start != 48901 || len(counts.Values) != 4 || counts.Values[3] != 0.5 || counts.Values[0] != 0 {
+		t.Errorf("unexpected values %v from %d", counts.Values, counts.Start)
+	}
+}
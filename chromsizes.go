@@ -0,0 +1,186 @@
+package seqs
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+
+	"github.com/antonybholmes/go-dna"
+)
+
+const (
+	ChromSizesSql = `SELECT a.name, cs.name, cs.size
+		FROM chrom_sizes cs
+		JOIN assemblies a ON cs.assembly_id = a.id
+		ORDER BY a.name, cs.id`
+)
+
+var (
+	ErrLocationOutOfRange = errors.New("location is beyond the end of the chromosome")
+	ErrAssemblyNotFound   = errors.New("assembly not found")
+)
+
+type (
+	ChromSize struct {
+		Chr  string `json:"chr"`
+		Size int    `json:"size"`
+	}
+
+	// assemblyChromSizes are the chromosomes of an assembly in catalogue
+	// order, with a lookup by preferred name
+	assemblyChromSizes struct {
+		chrs  []*ChromSize
+		sizes map[string]int
+		// changes if the catalogue is rebuilt with other sizes
+		version string
+	}
+)
+
+// loadChromSizes reads the chromosome sizes of each assembly. Catalogues
+// without a chrom_sizes table are fine, locations are then only checked
+// against what the sample files know.
+func (sdb *SeqDB) loadChromSizes() error {
+	sdb.chromSizes = make(map[string]*assemblyChromSizes)
+
+	var table string
+
+	err := sdb.db.QueryRow(TableExistsSql, sql.Named("name", "chrom_sizes")).Scan(&table)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+
+		return err
+	}
+
+	rows, err := sdb.db.Query(ChromSizesSql)
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var assembly string
+		var chr ChromSize
+
+		err := rows.Scan(&assembly, &chr.Chr, &chr.Size)
+
+		if err != nil {
+			return err
+		}
+
+		chr.Chr = ParseChr(chr.Chr)
+
+		key := strings.ToLower(assembly)
+
+		sizes, ok := sdb.chromSizes[key]
+
+		if !ok {
+			sizes = &assemblyChromSizes{chrs: make([]*ChromSize, 0, 30), sizes: make(map[string]int)}
+			sdb.chromSizes[key] = sizes
+		}
+
+		sizes.chrs = append(sizes.chrs, &chr)
+		sizes.sizes[chr.Chr] = chr.Size
+	}
+
+	err = rows.Err()
+
+	if err != nil {
+		return err
+	}
+
+	for _, sizes := range sdb.chromSizes {
+		sizes.version = chromSizesVersion(sizes.chrs)
+	}
+
+	return nil
+}
+
+// chromSizesVersion identifies a set of chromosome sizes
+func chromSizesVersion(chrs []*ChromSize) string {
+	h := fnv.New64a()
+
+	for _, chr := range chrs {
+		fmt.Fprintf(h, "%s\t%d\n", chr.Chr, chr.Size)
+	}
+
+	return strconv.FormatUint(h.Sum64(), 36)
+}
+
+// ChromSizes lists the chromosomes of an assembly in catalogue order
+func (sdb *SeqDB) ChromSizes(assembly string) ([]*ChromSize, error) {
+	sizes, ok := sdb.chromSizes[strings.ToLower(ParseAssembly(assembly))]
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrAssemblyNotFound, assembly)
+	}
+
+	return sizes.chrs, nil
+}
+
+func (sdb *SeqDB) assemblyChromSizes(assembly string) *assemblyChromSizes {
+	return sdb.chromSizes[strings.ToLower(ParseAssembly(assembly))]
+}
+
+// ChrSize is the length of a chromosome in the assembly of the sample,
+// or 0 if it is not known
+func (sample *Sample) ChrSize(chr string) int {
+	if sample.chromSizes == nil {
+		return 0
+	}
+
+	return sample.chromSizes.sizes[chr]
+}
+
+// chromSizesVersion identifies the chromosome sizes of the sample, ""
+// if they are not known
+func (sample *Sample) chromSizesVersion() string {
+	if sample.chromSizes == nil {
+		return ""
+	}
+
+	return sample.chromSizes.version
+}
+
+// clampLocation shortens a location that runs past the end of its
+// chromosome and rejects one that starts after it. size is the length
+// of the chromosome, 0 if unknown, in which case the location is used
+// as is.
+func clampLocation(location *dna.Location, size int) (*dna.Location, error) {
+	if size < 1 || location.End() <= size {
+		return location, nil
+	}
+
+	if location.Start() > size {
+		return nil, fmt.Errorf("%w: %s starts after %d", ErrLocationOutOfRange, location, size)
+	}
+
+	return dna.NewLocation(location.Chr(), location.Start(), size)
+}
+
+// clipBins ends the final bin of a chromosome at the chromosome end
+// rather than at the bin boundary, dropping any bins past it. Bins are
+// 1-based inclusive and in order.
+func clipBins(bins []*ReadBin, size int) []*ReadBin {
+	if size < 1 {
+		return bins
+	}
+
+	for len(bins) > 0 && bins[len(bins)-1].Start > size {
+		bins = bins[:len(bins)-1]
+	}
+
+	if len(bins) > 0 {
+		last := bins[len(bins)-1]
+		last.End = min(last.End, size)
+	}
+
+	return bins
+}
@@ -0,0 +1,152 @@
+package seqs
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestClampLocation(t *testing.T) {
+	tests := []struct {
+		start int
+		end   int
+		size  int
+		want  string
+	}{
+		// unknown sizes leave the location alone
+		{1, 2000, 0, "chr1:1-2000"},
+		{1, 1000, 1000, "chr1:1-1000"},
+		{900, 2000, 1000, "chr1:900-1000"},
+		{1000, 2000, 1000, "chr1:1000-1000"},
+	}
+
+	for _, test := range tests {
+		location, err := clampLocation(binsLocation(t, "chr1", test.start, test.end), test.size)
+
+		if err != nil {
+			t.Errorf("%d-%d of %d: %s", test.start, test.end, test.size, err)
+			continue
+		}
+
+		if location.String() != test.want {
+			t.Errorf("%d-%d of %d is %s, want %s", test.start, test.end, test.size, location, test.want)
+		}
+	}
+
+	_, err := clampLocation(binsLocation(t, "chr1", 1001, 2000), 1000)
+
+	if !errors.Is(err, ErrLocationOutOfRange) {
+		t.Errorf("start past the end: error %v, want %v", err, ErrLocationOutOfRange)
+	}
+}
+
+func TestClipBins(t *testing.T) {
+	bins := func() []*ReadBin {
+		return []*ReadBin{
+			{Start: 1, End: 100, Count: 1},
+			// a merged run across the end of the chromosome
+			{Start: 201, End: 400, Count: 2},
+			{Start: 401, End: 500, Count: 3},
+		}
+	}
+
+	tests := []struct {
+		size int
+		want []*ReadBin
+	}{
+		{0, bins()},
+		{500, bins()},
+		// the final bin is partial
+		{450, []*ReadBin{{Start: 1, End: 100, Count: 1}, {Start: 201, End: 400, Count: 2}, {Start: 401, End: 450, Count: 3}}},
+		// bins past the end are dropped
+		{350, []*ReadBin{{Start: 1, End: 100, Count: 1}, {Start: 201, End: 350, Count: 2}}},
+		{150, []*ReadBin{{Start: 1, End: 100, Count: 1}}},
+		{1, []*ReadBin{{Start: 1, End: 1, Count: 1}}},
+	}
+
+	for _, test := range tests {
+		if got := clipBins(bins(), test.size); !sameBins(got, test.want) {
+			t.Errorf("clipped to %d: %v, want %v", test.size, got, test.want)
+		}
+	}
+
+	if got := clipBins([]*ReadBin{}, 100); len(got) != 0 {
+		t.Errorf("clipped no bins to %v", got)
+	}
+}
+
+func TestResamplePartialFinalBin(t *testing.T) {
+	// chr1 is 950 bases so the second 500 base bin has 450
+	runs := []*ReadBin{{Start: 501, End: 950, Count: 2}}
+
+	location := binsLocation(t, "chr1", 1, 950)
+
+	tests := []struct {
+		stat string
+		want float64
+	}{
+		// the 4.5 stored bins of the partial bin all have data
+		{StatCoverage, 1},
+		{StatMin, 2},
+		{StatSum, 9},
+		{StatMean, 9},
+	}
+
+	for _, test := range tests {
+		bins := clipBins(resampleBins(runs, 100, location, 500, 950, test.stat), 950)
+
+		if !sameBins(bins, []*ReadBin{{Start: 501, End: 950, Count: test.want}}) {
+			t.Errorf("%s bins %v, want one of %f", test.stat, bins, test.want)
+		}
+	}
+}
+
+func TestChromSizes(t *testing.T) {
+	sdb := openTestCatalogue(t, oldCatalogueSql,
+		`CREATE TABLE chrom_sizes (id INTEGER PRIMARY KEY, assembly_id INTEGER NOT NULL, name TEXT NOT NULL, size INTEGER NOT NULL);
+		INSERT INTO chrom_sizes (assembly_id, name, size) VALUES (1, 'chr2', 2000), (1, '1', 1000), (1, 'MT', 16);`)
+
+	// aliases find the assembly
+	chrs, err := sdb.ChromSizes("GRCh37")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// in catalogue order, with preferred names
+	want := []ChromSize{{"chr2", 2000}, {"chr1", 1000}, {"chrM", 16}}
+
+	if len(chrs) != len(want) {
+		t.Fatalf("chromosomes %v, want %v", chrs, want)
+	}
+
+	for i, chr := range chrs {
+		if *chr != want[i] {
+			t.Errorf("chromosome %d is %+v, want %+v", i, *chr, want[i])
+		}
+	}
+
+	sample := &Sample{chromSizes: sdb.assemblyChromSizes("hg19")}
+
+	if sample.ChrSize("chr1") != 1000 || sample.ChrSize("chr3") != 0 {
+		t.Errorf("unexpected sizes %d %d", sample.ChrSize("chr1"), sample.ChrSize("chr3"))
+	}
+
+	if sample.chromSizesVersion() == "" || sample.chromSizesVersion() == chromSizesVersion([]*ChromSize{{"chr1", 1000}}) {
+		t.Errorf("unexpected version %q", sample.chromSizesVersion())
+	}
+
+	_, err = sdb.ChromSizes("mm10")
+
+	if !errors.Is(err, ErrAssemblyNotFound) {
+		t.Errorf("error %v, want %v", err, ErrAssemblyNotFound)
+	}
+
+	// catalogues without sizes have none
+	sdb = openTestCatalogue(t, oldCatalogueSql)
+
+	sample = &Sample{chromSizes: sdb.assemblyChromSizes("hg19")}
+
+	if sample.ChrSize("chr1") != 0 || sample.chromSizesVersion() != "" {
+		t.Errorf("sizes without a chrom_sizes table")
+	}
+}
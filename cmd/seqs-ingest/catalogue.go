@@ -0,0 +1,707 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/antonybholmes/go-seqs"
+	"github.com/antonybholmes/go-seqs/bigwig"
+	"github.com/antonybholmes/go-sys"
+	"github.com/antonybholmes/go-sys/db"
+	"github.com/antonybholmes/go-sys/log"
+)
+
+const (
+	PermissionRdfView = "rdf:view"
+
+	SelectSampleDBSql = `SELECT public_id, name, reads FROM sample`
+)
+
+// schema of the catalogue db, in creation order
+var catalogueSchema = []string{
+	`CREATE TABLE genomes (
+		id INTEGER PRIMARY KEY,
+		public_id TEXT NOT NULL UNIQUE,
+		name TEXT NOT NULL,
+		scientific_name TEXT NOT NULL,
+		UNIQUE(name, scientific_name));`,
+	`CREATE INDEX idx_genomes_name_id ON genomes(LOWER(name));`,
+
+	`CREATE TABLE assemblies (
+		id INTEGER PRIMARY KEY,
+		public_id TEXT NOT NULL UNIQUE,
+		genome_id INTEGER NOT NULL,
+		name TEXT NOT NULL UNIQUE,
+		FOREIGN KEY (genome_id) REFERENCES genomes(id) ON DELETE CASCADE);`,
+	`CREATE INDEX idx_assemblies_name_id ON assemblies(LOWER(name));`,
+	`CREATE INDEX idx_assemblies_genome_id ON assemblies(genome_id);`,
+
+	// other names clients may use for an assembly, read by the server
+	// to resolve them
+	`CREATE TABLE assembly_aliases (
+		id INTEGER PRIMARY KEY,
+		assembly_id INTEGER NOT NULL,
+		name TEXT NOT NULL UNIQUE,
+		FOREIGN KEY (assembly_id) REFERENCES assemblies(id) ON DELETE CASCADE);`,
+	`CREATE INDEX idx_assembly_aliases_assembly_id ON assembly_aliases(assembly_id);`,
+
+	`CREATE TABLE chrom_sizes (
+		id INTEGER PRIMARY KEY,
+		assembly_id INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		UNIQUE(assembly_id, name),
+		FOREIGN KEY (assembly_id) REFERENCES assemblies(id) ON DELETE CASCADE);`,
+
+	`CREATE TABLE technologies (
+		id INTEGER PRIMARY KEY,
+		public_id TEXT NOT NULL UNIQUE,
+		name TEXT NOT NULL UNIQUE);`,
+	`CREATE INDEX idx_technologies_name_id ON technologies(LOWER(name));`,
+
+	`CREATE TABLE institutions (
+		id INTEGER PRIMARY KEY,
+		public_id TEXT NOT NULL UNIQUE,
+		name TEXT NOT NULL UNIQUE);`,
+	`CREATE INDEX idx_institutions_name_id ON institutions(LOWER(name));`,
+
+	`CREATE TABLE datasets (
+		id INTEGER PRIMARY KEY,
+		public_id TEXT NOT NULL UNIQUE,
+		assembly_id INTEGER NOT NULL,
+		institution_id INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		accession TEXT NOT NULL DEFAULT '',
+		doi TEXT NOT NULL DEFAULT '',
+		contact TEXT NOT NULL DEFAULT '',
+		created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
+		tags TEXT NOT NULL DEFAULT '',
+		FOREIGN KEY(assembly_id) REFERENCES assemblies(id) ON DELETE CASCADE,
+		FOREIGN KEY(institution_id) REFERENCES institutions(id) ON DELETE CASCADE);`,
+	`CREATE INDEX idx_datasets_name_id ON datasets(LOWER(name));`,
+	`CREATE INDEX idx_datasets_accession ON datasets(LOWER(accession));`,
+	`CREATE INDEX idx_datasets_assembly_id ON datasets(assembly_id);`,
+	`CREATE INDEX idx_datasets_institution_id ON datasets(institution_id);`,
+
+	`CREATE TABLE permissions (
+		id INTEGER PRIMARY KEY ASC,
+		public_id TEXT NOT NULL UNIQUE,
+		name TEXT NOT NULL);`,
+
+	`CREATE TABLE dataset_permissions (
+		dataset_id INTEGER,
+		permission_id INTEGER,
+		PRIMARY KEY(dataset_id, permission_id),
+		FOREIGN KEY (dataset_id) REFERENCES datasets(id) ON DELETE CASCADE,
+		FOREIGN KEY (permission_id) REFERENCES permissions(id) ON DELETE CASCADE);`,
+	`CREATE INDEX idx_dataset_permissions_dataset_id ON dataset_permissions(dataset_id);`,
+	`CREATE INDEX idx_dataset_permissions_permission_id ON dataset_permissions(permission_id);`,
+
+	`CREATE TABLE sample_types (
+		id INTEGER PRIMARY KEY ASC,
+		public_id TEXT NOT NULL UNIQUE,
+		name TEXT NOT NULL);`,
+
+	`CREATE TABLE samples (
+		id INTEGER PRIMARY KEY,
+		public_id TEXT NOT NULL UNIQUE,
+		technology_id INTEGER NOT NULL,
+		institution_id INTEGER NOT NULL,
+		dataset_id INTEGER NOT NULL,
+		name TEXT NOT NULL UNIQUE,
+		type_id INTEGER NOT NULL,
+		reads INTEGER NOT NULL DEFAULT 0,
+		url TEXT NOT NULL DEFAULT '',
+		public_url TEXT NOT NULL DEFAULT '',
+		description TEXT NOT NULL DEFAULT '',
+		tags BLOB NOT NULL DEFAULT (jsonb('[]')),
+		FOREIGN KEY(institution_id) REFERENCES institutions(id) ON DELETE CASCADE,
+		FOREIGN KEY(dataset_id) REFERENCES datasets(id) ON DELETE CASCADE,
+		FOREIGN KEY(technology_id) REFERENCES technologies(id) ON DELETE CASCADE,
+		FOREIGN KEY(type_id) REFERENCES sample_types(id) ON DELETE CASCADE);`,
+	`CREATE INDEX idx_samples_name_id ON samples(LOWER(name));`,
+	`CREATE INDEX idx_samples_dataset_id ON samples(dataset_id);`,
+	`CREATE INDEX idx_samples_technology_id ON samples(technology_id);`,
+	`CREATE INDEX idx_samples_type_id ON samples(type_id);`,
+	`CREATE INDEX idx_samples_institution_id ON samples(institution_id);`,
+}
+
+var (
+	// genome name to scientific name
+	genomes = [][]string{{"Human", "Homo sapiens"}, {"Mouse", "Mus musculus"}}
+
+	// assembly to genome
+	assemblies = [][]string{{"hg19", "Human"}, {"GRCh38", "Human"}, {"GRCm39", "Mouse"}}
+
+	// assembly to alias
+	assemblyAliases = [][]string{{"hg19", "GRCh37"}, {"GRCh38", "hg38"}, {"GRCm39", "mm39"}}
+
+	technologies = []string{"ChIP-seq", "RNA-seq", "CUT&RUN"}
+
+	sampleTypes = []string{seqs.SampleTypeSeq,
+		seqs.SampleTypeBigWig,
+		seqs.SampleTypeRemoteBigWig,
+		seqs.SampleTypeBam}
+)
+
+// catalogue keeps track of the ids of the rows written so far
+type catalogue struct {
+	tx           *sql.Tx
+	genomes      map[string]int
+	assemblies   map[string]int
+	technologies map[string]int
+	institutions map[string]int
+	datasets     map[string]int
+	sampleTypes  map[string]int
+}
+
+func newCatalogue(tx *sql.Tx) *catalogue {
+	return &catalogue{tx: tx,
+		genomes:      make(map[string]int),
+		assemblies:   make(map[string]int),
+		technologies: make(map[string]int),
+		institutions: make(map[string]int),
+		datasets:     make(map[string]int),
+		sampleTypes:  make(map[string]int)}
+}
+
+// WriteCatalogue creates the catalogue db that SeqDB reads. Seq samples
+// are found by scanning the output folder for sample dbs, as in the
+// python version, so the catalogue can be rebuilt without re-binning.
+func WriteCatalogue(path string, rows []*SampleRow, opts *Options) error {
+	log.Info().Msgf("catalogue %s", path)
+
+	err := os.Remove(path)
+
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	conn, err := sql.Open(db.Sqlite3DB, path)
+
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	tx, err := conn.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	for _, stmt := range catalogueSchema {
+		_, err = tx.Exec(stmt)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	cat := newCatalogue(tx)
+
+	err = cat.seed()
+
+	if err != nil {
+		return err
+	}
+
+	err = cat.addChromSizes(opts)
+
+	if err != nil {
+		return err
+	}
+
+	err = cat.addSampleDBs(opts)
+
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		switch row.Type {
+		case seqs.SampleTypeBigWig, seqs.SampleTypeRemoteBigWig:
+			err = cat.addTrackDb(row)
+		case seqs.SampleTypeBam:
+			err = cat.addBam(row)
+		default:
+			continue
+		}
+
+		if err != nil {
+			return fmt.Errorf("sample %s: %w", row.Sample, err)
+		}
+	}
+
+	err = cat.addDatasetMetadata(opts)
+
+	if err != nil {
+		return err
+	}
+
+	err = cat.addSearchIndex()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO dataset_permissions (dataset_id, permission_id) SELECT id, 1 FROM datasets`)
+
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (cat *catalogue) seed() error {
+	for i, genome := range genomes {
+		_, err := cat.tx.Exec(`INSERT INTO genomes (id, public_id, name, scientific_name) VALUES (:id, :public_id, :name, :scientific_name)`,
+			sql.Named("id", i+1),
+			sql.Named("public_id", sys.Must(sys.Uuidv7())),
+			sql.Named("name", genome[0]),
+			sql.Named("scientific_name", genome[1]))
+
+		if err != nil {
+			return err
+		}
+
+		cat.genomes[genome[0]] = i + 1
+	}
+
+	for i, assembly := range assemblies {
+		_, err := cat.tx.Exec(`INSERT INTO assemblies (id, public_id, genome_id, name) VALUES (:id, :public_id, :genome_id, :name)`,
+			sql.Named("id", i+1),
+			sql.Named("public_id", sys.Must(sys.Uuidv7())),
+			sql.Named("genome_id", cat.genomes[assembly[1]]),
+			sql.Named("name", assembly[0]))
+
+		if err != nil {
+			return err
+		}
+
+		cat.assemblies[assembly[0]] = i + 1
+	}
+
+	for i, alias := range assemblyAliases {
+		_, err := cat.tx.Exec(`INSERT INTO assembly_aliases (id, assembly_id, name) VALUES (:id, :assembly_id, :name)`,
+			sql.Named("id", i+1),
+			sql.Named("assembly_id", cat.assemblies[alias[0]]),
+			sql.Named("name", alias[1]))
+
+		if err != nil {
+			return err
+		}
+	}
+
+	err := cat.insertNames("technologies", technologies, cat.technologies)
+
+	if err != nil {
+		return err
+	}
+
+	err = cat.insertNames("sample_types", sampleTypes, cat.sampleTypes)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = cat.institution("Columbia")
+
+	if err != nil {
+		return err
+	}
+
+	_, err = cat.tx.Exec(`INSERT INTO permissions (id, public_id, name) VALUES (1, :public_id, :name)`,
+		sql.Named("public_id", sys.Must(sys.Uuidv7())),
+		sql.Named("name", PermissionRdfView))
+
+	return err
+}
+
+// insertNames fills a simple id, public_id, name lookup table
+func (cat *catalogue) insertNames(table string, names []string, ids map[string]int) error {
+	for i, name := range names {
+		_, err := cat.tx.Exec(fmt.Sprintf(`INSERT INTO %s (id, public_id, name) VALUES (:id, :public_id, :name)`, table),
+			sql.Named("id", i+1),
+			sql.Named("public_id", sys.Must(sys.Uuidv7())),
+			sql.Named("name", name))
+
+		if err != nil {
+			return err
+		}
+
+		ids[name] = i + 1
+	}
+
+	return nil
+}
+
+func (cat *catalogue) institution(name string) (int, error) {
+	id, ok := cat.institutions[name]
+
+	if ok {
+		return id, nil
+	}
+
+	id = len(cat.institutions) + 1
+
+	_, err := cat.tx.Exec(`INSERT INTO institutions (id, public_id, name) VALUES (:id, :public_id, :name)`,
+		sql.Named("id", id),
+		sql.Named("public_id", sys.Must(sys.Uuidv7())),
+		sql.Named("name", name))
+
+	if err != nil {
+		return 0, err
+	}
+
+	cat.institutions[name] = id
+
+	return id, nil
+}
+
+func (cat *catalogue) dataset(name string, assembly string, institutionId int) (int, error) {
+	id, ok := cat.datasets[name]
+
+	if ok {
+		return id, nil
+	}
+
+	assemblyId, ok := cat.assemblies[assembly]
+
+	if !ok {
+		return 0, fmt.Errorf("unknown assembly %s", assembly)
+	}
+
+	id = len(cat.datasets) + 1
+
+	_, err := cat.tx.Exec(`INSERT INTO datasets (id, public_id, assembly_id, institution_id, name) VALUES (:id, :public_id, :assembly_id, :institution_id, :name)`,
+		sql.Named("id", id),
+		sql.Named("public_id", sys.Must(sys.Uuidv7())),
+		sql.Named("assembly_id", assemblyId),
+		sql.Named("institution_id", institutionId),
+		sql.Named("name", name))
+
+	if err != nil {
+		return 0, err
+	}
+
+	cat.datasets[name] = id
+
+	return id, nil
+}
+
+type catalogueSample struct {
+	publicId    string
+	technology  string
+	institution string
+	dataset     string
+	assembly    string
+	name        string
+	sampleType  string
+	reads       int
+	url         string
+	tags        []seqs.Tag
+}
+
+func (cat *catalogue) addSample(sample *catalogueSample) error {
+	technologyId, ok := cat.technologies[sample.technology]
+
+	if !ok {
+		return fmt.Errorf("unknown technology %s", sample.technology)
+	}
+
+	typeId, ok := cat.sampleTypes[sample.sampleType]
+
+	if !ok {
+		return fmt.Errorf("unknown sample type %s", sample.sampleType)
+	}
+
+	institutionId, err := cat.institution(sample.institution)
+
+	if err != nil {
+		return err
+	}
+
+	datasetId, err := cat.dataset(sample.dataset, sample.assembly, institutionId)
+
+	if err != nil {
+		return err
+	}
+
+	if sample.tags == nil {
+		sample.tags = []seqs.Tag{}
+	}
+
+	tags, err := json.Marshal(sample.tags)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = cat.tx.Exec(`INSERT INTO samples (public_id, technology_id, institution_id, dataset_id, name, type_id, reads, url, tags) VALUES (
+		:public_id, :technology_id, :institution_id, :dataset_id, :name, :type_id, :reads, :url, jsonb(:tags))`,
+		sql.Named("public_id", sample.publicId),
+		sql.Named("technology_id", technologyId),
+		sql.Named("institution_id", institutionId),
+		sql.Named("dataset_id", datasetId),
+		sql.Named("name", sample.name),
+		sql.Named("type_id", typeId),
+		sql.Named("reads", sample.reads),
+		sql.Named("url", sample.url),
+		sql.Named("tags", string(tags)))
+
+	return err
+}
+
+// addSampleDBs registers every sample db found under the output folder.
+// The folder structure is assembly/technology/institution/dataset.
+func (cat *catalogue) addSampleDBs(opts *Options) error {
+	return filepath.WalkDir(opts.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			if strings.Contains(d.Name(), "trash") {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		filename := d.Name()
+
+		if !strings.HasSuffix(filename, ".db") ||
+			filename == opts.SeqDB ||
+			filename == "seqs.db" ||
+			filename == "samples.db" ||
+			filename == "tracks.db" {
+			return nil
+		}
+
+		relativeDir, err := filepath.Rel(opts.Dir, filepath.Dir(path))
+
+		if err != nil {
+			return err
+		}
+
+		parts := strings.Split(filepath.ToSlash(relativeDir), "/")
+
+		if len(parts) != 4 {
+			log.Debug().Msgf("skipping %s", path)
+			return nil
+		}
+
+		assembly := parts[0]
+		technology := strings.ReplaceAll(parts[1], "_AND_", "&")
+		institution := parts[2]
+		dataset := parts[3]
+
+		log.Info().Msgf("d: %s %s", relativeDir, filename)
+
+		conn, err := sql.Open(db.Sqlite3DB, path+db.SqliteReadOnlySuffix)
+
+		if err != nil {
+			return err
+		}
+
+		defer conn.Close()
+
+		rows, err := conn.Query(SelectSampleDBSql)
+
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		defer rows.Close()
+
+		for rows.Next() {
+			sample := catalogueSample{technology: technology,
+				institution: institution,
+				dataset:     dataset,
+				assembly:    assembly,
+				sampleType:  seqs.SampleTypeSeq,
+				// where to find the sql db
+				url: filepath.ToSlash(filepath.Join(relativeDir, filename))}
+
+			err = rows.Scan(&sample.publicId, &sample.name, &sample.reads)
+
+			if err != nil {
+				return err
+			}
+
+			err = cat.addSample(&sample)
+
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+		}
+
+		return rows.Err()
+	})
+}
+
+// addTrackDb registers the bigwigs listed in a track db file by reading
+// its track and bigDataUrl lines.
+func (cat *catalogue) addTrackDb(row *SampleRow) error {
+	f, err := os.Open(row.File)
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	name := ""
+
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		tokens := strings.Fields(scanner.Text())
+
+		if len(tokens) < 2 {
+			continue
+		}
+
+		switch tokens[0] {
+		case "track":
+			name = tokens[1]
+		case "bigDataUrl":
+			url := tokens[1]
+
+			if !strings.Contains(url, "bw") && !strings.Contains(url, "bigWig") {
+				log.Warn().Msgf("url does not seem to be a bigwig %s", url)
+				continue
+			}
+
+			sampleType := seqs.SampleTypeBigWig
+
+			if bigwig.IsUrl(url) {
+				sampleType = seqs.SampleTypeRemoteBigWig
+			}
+
+			err = cat.addSample(&catalogueSample{publicId: sys.Must(sys.Uuidv7()),
+				technology:  row.Technology,
+				institution: row.Institution,
+				dataset:     row.Dataset,
+				assembly:    row.Assembly,
+				name:        name,
+				sampleType:  sampleType,
+				url:         url,
+				tags:        []seqs.Tag{{Name: "scale", Value: row.Scale}}})
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return scanner.Err()
+}
+
+// addBam registers a bam that is binned on the fly rather than
+// converted to a sample db
+func (cat *catalogue) addBam(row *SampleRow) error {
+	return cat.addSample(&catalogueSample{publicId: sys.Must(sys.Uuidv7()),
+		technology:  row.Technology,
+		institution: row.Institution,
+		dataset:     row.Dataset,
+		assembly:    row.Assembly,
+		name:        row.Sample,
+		sampleType:  seqs.SampleTypeBam,
+		url:         row.File})
+}
+
+// addSearchIndex builds the full text index of the samples once they and
+// their datasets are complete. Without fts5 in sqlite, which needs the
+// sqlite_fts5 build tag, the index is left out and the server uses its
+// simple search.
+func (cat *catalogue) addSearchIndex() error {
+	_, err := cat.tx.Exec(seqs.CreateSamplesFtsSql)
+
+	if err != nil {
+		if strings.Contains(err.Error(), "no such module") {
+			log.Info().Msgf("fts5 not available, build with -tags sqlite_fts5 to write the search index: %s", err)
+			return nil
+		}
+
+		return err
+	}
+
+	log.Info().Msgf("search index")
+
+	_, err = cat.tx.Exec(seqs.PopulateSamplesFtsSql)
+
+	return err
+}
+
+// addChromSizes loads UCSC chrom.sizes files, which are a chromosome
+// name and length per line separated by a tab, in file order.
+func (cat *catalogue) addChromSizes(opts *Options) error {
+	for assembly, path := range opts.ChromSizes {
+		assemblyId, ok := cat.assemblies[assembly]
+
+		if !ok {
+			return fmt.Errorf("chrom sizes for unknown assembly %s", assembly)
+		}
+
+		log.Info().Msgf("chrom sizes %s %s", assembly, path)
+
+		err := cat.addChromSizesFile(assemblyId, path)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (cat *catalogue) addChromSizesFile(assemblyId int, path string) error {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		size, err := strconv.Atoi(fields[1])
+
+		if err != nil {
+			return fmt.Errorf("%s: invalid size %q for %s", path, fields[1], fields[0])
+		}
+
+		_, err = cat.tx.Exec(`INSERT INTO chrom_sizes (assembly_id, name, size) VALUES (:assembly_id, :name, :size)`,
+			sql.Named("assembly_id", assemblyId),
+			sql.Named("name", fields[0]),
+			sql.Named("size", size))
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
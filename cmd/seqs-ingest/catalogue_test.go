@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/antonybholmes/go-dna"
+	"github.com/antonybholmes/go-seqs"
+	"github.com/antonybholmes/go-sys/db"
+)
+
+// newTestCatalogue starts a seeded catalogue in a temporary db. The
+// transaction is rolled back when the test ends.
+func newTestCatalogue(t *testing.T) *catalogue {
+	t.Helper()
+
+	conn, err := sql.Open(db.Sqlite3DB, filepath.Join(t.TempDir(), "seqs.db"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+
+	tx, err := conn.Begin()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { tx.Rollback() })
+
+	for _, stmt := range catalogueSchema {
+		_, err = tx.Exec(stmt)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cat := newCatalogue(tx)
+
+	err = cat.seed()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cat
+}
+
+func TestAddChromSizesFile(t *testing.T) {
+	cat := newTestCatalogue(t)
+
+	path := writeFile(t, "hg19.chrom.sizes", "# comment\nchr1\t249250621\n\nchrM 16571\nchr2\t243199373\textra\n")
+
+	err := cat.addChromSizesFile(cat.assemblies["hg19"], path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := cat.tx.Query(`SELECT cs.name, cs.size, a.name FROM chrom_sizes cs
+		JOIN assemblies a ON cs.assembly_id = a.id ORDER BY cs.id`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer rows.Close()
+
+	want := []string{"chr1 249250621 hg19", "chrM 16571 hg19", "chr2 243199373 hg19"}
+	got := make([]string, 0, len(want))
+
+	for rows.Next() {
+		var name, assembly string
+		var size int
+
+		err = rows.Scan(&name, &size, &assembly)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got = append(got, fmt.Sprintf("%s %d %s", name, size, assembly))
+	}
+
+	if !slices.Equal(got, want) {
+		t.Errorf("chrom sizes %v, want %v (in file order)", got, want)
+	}
+
+	err = cat.addChromSizesFile(cat.assemblies["hg19"], writeFile(t, "bad.sizes", "chr1\tlong\n"))
+
+	if err == nil {
+		t.Errorf("expected an error for a bad size")
+	}
+}
+
+func TestAddTrackDb(t *testing.T) {
+	cat := newTestCatalogue(t)
+
+	path := writeFile(t, "trackDb.txt", "track CB_BCL6\n"+
+		"type bigWig\n"+
+		"bigDataUrl CB_BCL6.bw\n"+
+		"\n"+
+		"track notes\n"+
+		"bigDataUrl notes.txt\n"+
+		"\n"+
+		"track NB_BCL6\n"+
+		"bigDataUrl https://example.com/hub/NB_BCL6.bigWig\n")
+
+	row := &SampleRow{Sample: "hub",
+		File:        path,
+		Genome:      "Human",
+		Assembly:    "hg19",
+		Institution: "Columbia",
+		Dataset:     "Hub",
+		Type:        seqs.SampleTypeBigWig,
+		Technology:  "ChIP-seq",
+		Scale:       "2"}
+
+	err := cat.addTrackDb(row)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := cat.tx.Query(`SELECT s.name, s.url, st.name, json_extract(s.tags, '$[0].value'), d.name
+		FROM samples s
+		JOIN sample_types st ON s.type_id = st.id
+		JOIN datasets d ON s.dataset_id = d.id
+		ORDER BY s.id`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer rows.Close()
+
+	// the url that is not a bigwig is skipped
+	want := []string{"CB_BCL6 CB_BCL6.bw BigWig 2 Hub",
+		"NB_BCL6 https://example.com/hub/NB_BCL6.bigWig RemoteBigWig 2 Hub"}
+	got := make([]string, 0, len(want))
+
+	for rows.Next() {
+		var name, url, sampleType, scale, dataset string
+
+		err = rows.Scan(&name, &url, &sampleType, &scale, &dataset)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got = append(got, strings.Join([]string{name, url, sampleType, scale, dataset}, " "))
+	}
+
+	if !slices.Equal(got, want) {
+		t.Errorf("samples %q, want %q", got, want)
+	}
+}
+
+// TestWriteCatalogue bins the fixture bam into a sample db, catalogues
+// it and reads it back as the server would
+func TestWriteCatalogue(t *testing.T) {
+	dir := t.TempDir()
+
+	row := fixtureRow(true)
+
+	opts := &Options{Dir: dir,
+		SeqDB:         "seqs.db",
+		BinSizes:      []int{100, 1000},
+		Mode:          ModeDefault,
+		CreateSamples: true,
+		ChromSizes:    map[string]string{"hg19": writeFile(t, "hg19.chrom.sizes", "chr1\t10000\nchr2\t5000\n")}}
+
+	err := WriteSampleDB(row, opts)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = WriteCatalogue(filepath.Join(dir, opts.SeqDB), []*SampleRow{row}, opts)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sdb := seqs.NewSeqDB(filepath.Join(dir, opts.SeqDB))
+	defer sdb.Close()
+
+	samples, err := sdb.Search("", "hg19", nil, true, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(samples) != 1 || samples[0].Name != row.Sample || samples[0].Reads != 3 || samples[0].Dataset != row.Dataset {
+		t.Fatalf("unexpected samples %+v", samples)
+	}
+
+	reader, err := sdb.ReaderFromId(samples[0].Id, 1000, seqs.StatMean)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	location, err := dna.NewLocation("chr1", 1, 10000)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts, err := reader.BinCounts(context.Background(), location)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkBins(t, counts.Bins, fixtureBins[true][1000][0])
+
+	if counts.BinReads != 4 || counts.BpmScaleFactor != 250000 || counts.Reads != 3 {
+		t.Errorf("unexpected counts %+v", *counts)
+	}
+}
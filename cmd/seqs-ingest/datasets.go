@@ -0,0 +1,146 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/antonybholmes/go-sys/log"
+)
+
+// DatasetRow is one line of datasets.tsv
+type DatasetRow struct {
+	Dataset     string
+	Description string
+	Accession   string
+	Doi         string
+	Contact     string
+	// ISO 8601, the time the catalogue is built if empty
+	Created string
+}
+
+func ReadDatasetsFile(path string) ([]*DatasetRow, error) {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.Comma = '\t'
+	reader.LazyQuotes = true
+	reader.FieldsPerRecord = -1
+
+	records, err := reader.ReadAll()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return []*DatasetRow{}, nil
+	}
+
+	cols := make(map[string]int)
+
+	for i, name := range records[0] {
+		cols[strings.TrimSpace(name)] = i
+	}
+
+	if _, ok := cols["dataset"]; !ok {
+		return nil, fmt.Errorf("%s is missing column dataset", path)
+	}
+
+	get := func(record []string, name string) string {
+		i, ok := cols[name]
+
+		if !ok || i >= len(record) {
+			return ""
+		}
+
+		return strings.TrimSpace(record[i])
+	}
+
+	ret := make([]*DatasetRow, 0, len(records)-1)
+
+	for _, record := range records[1:] {
+		if len(record) == 0 || (len(record) == 1 && record[0] == "") {
+			continue
+		}
+
+		ret = append(ret, &DatasetRow{
+			Dataset:     get(record, "dataset"),
+			Description: get(record, "description"),
+			Accession:   get(record, "accession"),
+			Doi:         get(record, "doi"),
+			Contact:     get(record, "contact"),
+			Created:     get(record, "created"),
+		})
+	}
+
+	return ret, nil
+}
+
+// addDatasetMetadata fills in the metadata of datasets created from the
+// samples file. Datasets in the file without samples are skipped.
+func (cat *catalogue) addDatasetMetadata(opts *Options) error {
+	if opts.DatasetsFile == "" {
+		return nil
+	}
+
+	rows, err := ReadDatasetsFile(opts.DatasetsFile)
+
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		// datasets of sample dbs are named after their folder so the
+		// same dataset can appear under both names
+		names := []string{row.Dataset}
+
+		if folder := spacesRegex.ReplaceAllString(row.Dataset, "_"); folder != row.Dataset {
+			names = append(names, folder)
+		}
+
+		found := false
+
+		for _, name := range names {
+			id, ok := cat.datasets[name]
+
+			if !ok {
+				continue
+			}
+
+			found = true
+
+			_, err = cat.tx.Exec(`UPDATE datasets SET
+				description = :description,
+				accession = :accession,
+				doi = :doi,
+				contact = :contact,
+				created_at = COALESCE(NULLIF(:created, ''), created_at)
+				WHERE id = :id`,
+				sql.Named("id", id),
+				sql.Named("description", row.Description),
+				sql.Named("accession", row.Accession),
+				sql.Named("doi", row.Doi),
+				sql.Named("contact", row.Contact),
+				sql.Named("created", row.Created))
+
+			if err != nil {
+				return err
+			}
+		}
+
+		if !found {
+			log.Info().Msgf("skipping metadata for dataset %s without samples", row.Dataset)
+		}
+	}
+
+	return nil
+}
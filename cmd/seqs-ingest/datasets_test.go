@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeFile writes a test input file in a temporary folder
+func writeFile(t *testing.T, name string, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+
+	err := os.WriteFile(path, []byte(content), 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestReadDatasetsFile(t *testing.T) {
+	// columns in any order, a short row, a blank line and quotes
+	path := writeFile(t, "datasets.tsv", "accession\tdataset\tdescription\tcontact\tcreated\n"+
+		"GSE1\tRDF CB4\tB cells \"from\" tonsils\tA Person\t2020-01-02\n"+
+		"\n"+
+		"GSE2\tOther\n")
+
+	rows, err := ReadDatasetsFile(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []DatasetRow{
+		{Dataset: "RDF CB4", Description: `B cells "from" tonsils`, Accession: "GSE1", Contact: "A Person", Created: "2020-01-02"},
+		{Dataset: "Other", Accession: "GSE2"},
+	}
+
+	if len(rows) != len(want) {
+		t.Fatalf("rows %v, want %v", rows, want)
+	}
+
+	for i, row := range rows {
+		if *row != want[i] {
+			t.Errorf("row %d is %+v, want %+v", i, *row, want[i])
+		}
+	}
+
+	rows, err = ReadDatasetsFile(writeFile(t, "empty.tsv", ""))
+
+	if err != nil || len(rows) != 0 {
+		t.Errorf("empty file gave %v %v", rows, err)
+	}
+
+	_, err = ReadDatasetsFile(writeFile(t, "bad.tsv", "name\tdescription\nx\ty\n"))
+
+	if err == nil {
+		t.Errorf("expected an error for a file without a dataset column")
+	}
+}
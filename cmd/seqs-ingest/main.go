@@ -0,0 +1,173 @@
+// Command seqs-ingest builds the per sample bin dbs and the samples
+// catalogue db from a samples.tsv file. It replaces step1_bamtosql.py
+// and writes the same schema so the dbs can be read by SeqDB and
+// DBSeqReader.
+//
+// Usage:
+//
+//	seqs-ingest --samples samples.tsv --dir ../data/modules/seqs --seqdb seqs.db
+//
+// Build it with build.sh, which sets the sqlite_fts5 tag, so that the
+// samples search index is written.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/antonybholmes/go-seqs"
+	"github.com/antonybholmes/go-sys/log"
+)
+
+const (
+	DefaultDir    = "../data/modules/seqs"
+	DefaultWidths = "50,100,1000,10000"
+
+	// modes for reducing bin variation
+	ModeDefault = "default"
+	ModeRound2  = "round2"
+)
+
+type Options struct {
+	Dir         string
+	SeqDB       string
+	SamplesFile string
+	// optional metadata for the datasets in the samples file
+	DatasetsFile  string
+	BinSizes      []int
+	Mode          string
+	MinReads      int
+	CreateSamples bool
+	// assembly to the path of a UCSC chrom.sizes file
+	ChromSizes map[string]string
+}
+
+func main() {
+	var opts Options
+	var widths string
+	var chromSizes string
+
+	flag.StringVar(&widths, "widths", DefaultWidths, "comma separated bin sizes")
+	flag.StringVar(&widths, "w", DefaultWidths, "comma separated bin sizes (shorthand)")
+	flag.StringVar(&opts.Dir, "dir", DefaultDir, "output directory")
+	flag.StringVar(&opts.Dir, "d", DefaultDir, "output directory (shorthand)")
+	flag.StringVar(&opts.SeqDB, "seqdb", "seqs.db", "name of the samples catalogue db in the output directory")
+	flag.StringVar(&opts.SamplesFile, "samples", "samples.tsv", "tsv file with columns: sample, file, genome, assembly, institution, dataset, type, technology, paired, scale")
+	flag.StringVar(&opts.DatasetsFile, "datasets", "", "optional tsv file with columns: dataset, description, accession, doi, contact, created")
+	flag.StringVar(&opts.Mode, "mode", ModeDefault, "mode for reducing bin variation to make smaller bins. round2 rounds to nearest multiple of 2")
+	flag.IntVar(&opts.MinReads, "min-reads", 4, "bins must have more than this many reads to be stored")
+
+	flag.StringVar(&chromSizes, "chrom-sizes", "", "comma separated assembly=file of UCSC chrom.sizes files, e.g. hg19=hg19.chrom.sizes")
+
+	noCreateSamples := flag.Bool("no-create-samples", false, "only build the catalogue from existing sample dbs")
+
+	flag.Parse()
+
+	opts.CreateSamples = !*noCreateSamples
+
+	binSizes, err := parseWidths(widths)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	opts.BinSizes = binSizes
+
+	opts.ChromSizes, err = parseChromSizesFiles(chromSizes)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if opts.Mode != ModeDefault && opts.Mode != ModeRound2 {
+		fmt.Fprintf(os.Stderr, "unknown mode %s\n", opts.Mode)
+		os.Exit(1)
+	}
+
+	err = run(&opts)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func parseWidths(widths string) ([]int, error) {
+	ret := make([]int, 0, 4)
+
+	for _, w := range strings.Split(widths, ",") {
+		w = strings.TrimSpace(w)
+
+		if w == "" {
+			continue
+		}
+
+		size, err := strconv.Atoi(w)
+
+		if err != nil || size < 1 {
+			return nil, fmt.Errorf("invalid bin size %q", w)
+		}
+
+		ret = append(ret, size)
+	}
+
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("no bin sizes given")
+	}
+
+	return ret, nil
+}
+
+func parseChromSizesFiles(files string) (map[string]string, error) {
+	ret := make(map[string]string)
+
+	for _, f := range strings.Split(files, ",") {
+		f = strings.TrimSpace(f)
+
+		if f == "" {
+			continue
+		}
+
+		assembly, path, ok := strings.Cut(f, "=")
+
+		if !ok || assembly == "" || path == "" {
+			return nil, fmt.Errorf("invalid chrom sizes %q, expected assembly=file", f)
+		}
+
+		ret[assembly] = path
+	}
+
+	return ret, nil
+}
+
+func run(opts *Options) error {
+	log.Info().Msgf("mode %s create samples %v min reads %d", opts.Mode, opts.CreateSamples, opts.MinReads)
+
+	rows, err := ReadSamplesFile(opts.SamplesFile)
+
+	if err != nil {
+		return err
+	}
+
+	if opts.CreateSamples {
+		for _, row := range rows {
+			if row.Type != seqs.SampleTypeSeq {
+				continue
+			}
+
+			err = WriteSampleDB(row, opts)
+
+			if err != nil {
+				return fmt.Errorf("sample %s: %w", row.Sample, err)
+			}
+		}
+	}
+
+	return WriteCatalogue(filepath.Join(opts.Dir, opts.SeqDB), rows, opts)
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"database/sql"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/antonybholmes/go-seqs/bam"
+	"github.com/antonybholmes/go-sys"
+	"github.com/antonybholmes/go-sys/db"
+	"github.com/antonybholmes/go-sys/log"
+)
+
+const (
+	CreateSampleTableSql = `CREATE TABLE sample (
+		id INTEGER PRIMARY KEY,
+		public_id TEXT NOT NULL UNIQUE,
+		institution TEXT NOT NULL,
+		dataset TEXT NOT NULL,
+		genome TEXT NOT NULL,
+		assembly TEXT NOT NULL,
+		technology TEXT NOT NULL,
+		name TEXT NOT NULL UNIQUE,
+		type TEXT NOT NULL DEFAULT 'Seq',
+		reads INTEGER NOT NULL DEFAULT 0,
+		url TEXT NOT NULL DEFAULT '',
+		public_url TEXT NOT NULL DEFAULT '',
+		tags BLOB NOT NULL DEFAULT (jsonb('[]')));`
+
+	CreateBinsTableSql = `CREATE TABLE bins (
+		id INTEGER PRIMARY KEY,
+		public_id TEXT NOT NULL UNIQUE,
+		size INTEGER NOT NULL UNIQUE,
+		reads INTEGER NOT NULL DEFAULT 0,
+		bpm_scale_factor REAL NOT NULL DEFAULT 1.0);`
+
+	CreateChromosomesTableSql = `CREATE TABLE chromosomes (
+		id INTEGER PRIMARY KEY,
+		public_id TEXT NOT NULL UNIQUE,
+		name TEXT NOT NULL UNIQUE);`
+
+	// store raw read counts per bin which can be scaled by bpm_scale_factor
+	CreateReadsTableSql = `CREATE TABLE reads (
+		id INTEGER PRIMARY KEY,
+		chr_id INTEGER NOT NULL,
+		bin_id INTEGER NOT NULL,
+		start INTEGER NOT NULL,
+		end INTEGER NOT NULL,
+		count INTEGER NOT NULL,
+		UNIQUE(chr_id, bin_id, start),
+		FOREIGN KEY (chr_id) REFERENCES chromosomes(id),
+		FOREIGN KEY (bin_id) REFERENCES bins(id) ON DELETE CASCADE);`
+
+	InsertBinSql = `INSERT INTO bins (id, public_id, size) VALUES (:id, :public_id, :size)`
+
+	UpdateBinSql = `UPDATE bins SET reads = :reads, bpm_scale_factor = :bpm_scale_factor WHERE size = :size`
+
+	InsertChromosomeSql = `INSERT INTO chromosomes (id, public_id, name) VALUES (:id, :public_id, :name)`
+
+	InsertReadsSql = `INSERT INTO reads (chr_id, bin_id, start, end, count) VALUES (:chr_id, :bin_id, :start, :end, :count)`
+
+	InsertSampleSql = `INSERT INTO sample (id, public_id, institution, dataset, genome, assembly, technology, name, reads) VALUES (
+		1, :public_id, :institution, :dataset, :genome, :assembly, :technology, :name, :reads)`
+)
+
+// run of contiguous bins sharing the same count, in 1-based inclusive
+// coordinates
+type readsRun struct {
+	start int
+	end   int
+	count int
+}
+
+// WriteSampleDB bins the reads of a bam for each bin size and writes
+// them to a new sample db, replacing any existing one.
+func WriteSampleDB(row *SampleRow, opts *Options) error {
+	dir := filepath.Join(opts.Dir, row.SampleDir())
+
+	err := os.MkdirAll(dir, 0755)
+
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, row.SampleDBName())
+
+	log.Info().Msgf("sample db %s", path)
+
+	err = os.Remove(path)
+
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	reader, err := bam.Open(row.File, "")
+
+	if err != nil {
+		return err
+	}
+
+	defer reader.Close()
+
+	conn, err := sql.Open(db.Sqlite3DB, path)
+
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	tx, err := conn.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	for _, stmt := range []string{CreateSampleTableSql,
+		CreateBinsTableSql,
+		CreateChromosomesTableSql,
+		CreateReadsTableSql} {
+		_, err = tx.Exec(stmt)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	for bi, size := range opts.BinSizes {
+		_, err = tx.Exec(InsertBinSql,
+			sql.Named("id", bi+1),
+			sql.Named("public_id", sys.Must(sys.Uuidv7())),
+			sql.Named("size", size))
+
+		if err != nil {
+			return err
+		}
+	}
+
+	refs := reader.Refs()
+
+	// chr ids follow the bam ref order, but only official chrs are kept
+	chrIds := make(map[int]int)
+
+	for chri, ref := range refs {
+		if strings.Contains(ref.Name, "_") {
+			continue
+		}
+
+		chr := ref.Name
+
+		// sometimes bam chr does not contain chr prefix
+		if !strings.HasPrefix(chr, "chr") {
+			chr = "chr" + chr
+		}
+
+		_, err = tx.Exec(InsertChromosomeSql,
+			sql.Named("id", chri+1),
+			sql.Named("public_id", sys.Must(sys.Uuidv7())),
+			sql.Named("name", chr))
+
+		if err != nil {
+			return err
+		}
+
+		chrIds[chri] = chri + 1
+	}
+
+	insertReads, err := tx.Prepare(InsertReadsSql)
+
+	if err != nil {
+		return err
+	}
+
+	defer insertReads.Close()
+
+	// for each bin size, the total reads spanning the bins
+	binReads := make([]int, len(opts.BinSizes))
+	totalReads := 0
+
+	currentRef := -1
+	chrReads := 0
+	var counts [][]int32
+
+	flush := func() error {
+		if currentRef < 0 {
+			return nil
+		}
+
+		log.Info().Msgf("chr reads %s %d", refs[currentRef].Name, chrReads)
+
+		totalReads += chrReads
+
+		for bi, size := range opts.BinSizes {
+			for _, run := range smoothRuns(counts[bi], size, opts) {
+				_, err := insertReads.Exec(sql.Named("chr_id", chrIds[currentRef]),
+					sql.Named("bin_id", bi+1),
+					sql.Named("start", run.start),
+					sql.Named("end", run.end),
+					sql.Named("count", run.count))
+
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}
+
+	err = reader.All(func(record *bam.Record) error {
+		if record.RefId != currentRef {
+			err := flush()
+
+			if err != nil {
+				return err
+			}
+
+			currentRef = -1
+
+			if _, ok := chrIds[record.RefId]; !ok {
+				// unmapped or unofficial chr
+				return nil
+			}
+
+			currentRef = record.RefId
+			chrReads = 0
+
+			log.Info().Msgf("processing %s...", refs[currentRef].Name)
+
+			counts = make([][]int32, len(opts.BinSizes))
+
+			for bi, size := range opts.BinSizes {
+				counts[bi] = make([]int32, refs[currentRef].Len/size+1)
+			}
+		}
+
+		if currentRef < 0 {
+			return nil
+		}
+
+		start, readLength, ok := record.Span(row.Paired)
+
+		if !ok {
+			return nil
+		}
+
+		// for all bins calc unique reads per bin
+		for bi, size := range opts.BinSizes {
+			sb := start / size
+			eb := min((start+readLength-1)/size, len(counts[bi])-1)
+
+			for b := sb; b <= eb; b++ {
+				counts[bi][b]++
+			}
+
+			// reads spanning several bins are counted in each
+			binReads[bi] += max(0, eb-sb+1)
+		}
+
+		chrReads++
+
+		if chrReads%100000 == 0 {
+			log.Info().Msgf("processed %d reads...", chrReads)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	err = flush()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(InsertSampleSql,
+		sql.Named("public_id", sys.Must(sys.Uuidv7())),
+		sql.Named("institution", row.Institution),
+		sql.Named("dataset", row.Dataset),
+		sql.Named("genome", row.Genome),
+		sql.Named("assembly", row.Assembly),
+		sql.Named("technology", row.Technology),
+		sql.Named("name", row.Sample),
+		sql.Named("reads", totalReads))
+
+	if err != nil {
+		return err
+	}
+
+	for bi, size := range opts.BinSizes {
+		// BPM = 1000000 * (number of reads per bin) / sum of all reads per bin
+		// where the sum of all reads per bin can be greater than the total
+		// reads in the library since reads can span bins
+		bpmScaleFactor := 0.0
+
+		if binReads[bi] > 0 {
+			bpmScaleFactor = 1000000 / float64(binReads[bi])
+		}
+
+		_, err = tx.Exec(UpdateBinSql,
+			sql.Named("reads", binReads[bi]),
+			sql.Named("bpm_scale_factor", bpmScaleFactor),
+			sql.Named("size", size))
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// smoothRuns removes bins at or below the min reads threshold, which is
+// basically noise, optionally rounds counts and then merges contiguous
+// bins with the same count into runs.
+func smoothRuns(counts []int32, size int, opts *Options) []*readsRun {
+	ret := make([]*readsRun, 0, 100)
+
+	var current *readsRun
+
+	for b, c := range counts {
+		count := int(c)
+
+		if opts.Mode == ModeRound2 {
+			// round to nearest multiple of 2 so that we reduce
+			// bin variation to make smaller bins
+			count = int(math.Ceil(float64(count)*0.5)) * 2
+		}
+
+		if count <= opts.MinReads {
+			current = nil
+			continue
+		}
+
+		// in this 1 based system, start and end are inclusive
+		// thus in 10bp window, it will start at 1 and end at 10 and
+		// the next window will start at 11 etc.
+		start := b*size + 1
+		end := (b + 1) * size
+
+		if current != nil && current.count == count {
+			current.end = end
+			continue
+		}
+
+		current = &readsRun{start: start, end: end, count: count}
+		ret = append(ret, current)
+	}
+
+	return ret
+}
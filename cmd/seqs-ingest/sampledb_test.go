@@ -0,0 +1,197 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/antonybholmes/go-seqs"
+	"github.com/antonybholmes/go-sys/db"
+)
+
+// the bam written by bam/testdata/mkfixture
+const bamFixture = "../../bam/testdata/fixture.bam"
+
+func fixtureRow(paired bool) *SampleRow {
+	return &SampleRow{Sample: "fixture sample",
+		File:        bamFixture,
+		Genome:      "Human",
+		Assembly:    "hg19",
+		Institution: "Columbia",
+		Dataset:     "Fixture",
+		Type:        seqs.SampleTypeSeq,
+		Technology:  "ChIP-seq",
+		Paired:      paired}
+}
+
+// fixtureBins are the runs step1_bamtosql.py stores for the fixture bam
+// with no minimum reads, by whether it is paired, bin size and then
+// chromosome. Paired reads span their fragment and are counted once;
+// single end reads span their alignment, so the spliced read e on chr2
+// covers 990-1044. Secondary and unmapped reads are not counted.
+var fixtureBins = map[bool]map[int][][]seqs.ReadBin{
+	true: {
+		100: {
+			{{Start: 101, End: 300, Count: 1}, {Start: 901, End: 1200, Count: 1}, {Start: 5001, End: 5200, Count: 1}},
+			// the single end reads of chr2 are not fragments
+			{},
+		},
+		1000: {
+			{{Start: 1, End: 1000, Count: 2}, {Start: 1001, End: 2000, Count: 1}, {Start: 5001, End: 6000, Count: 1}},
+			{},
+		},
+	},
+	false: {
+		100: {
+			{{Start: 101, End: 300, Count: 1}, {Start: 901, End: 1000, Count: 1}, {Start: 1101, End: 1200, Count: 1}, {Start: 5001, End: 5200, Count: 1}},
+			{{Start: 1, End: 100, Count: 1}, {Start: 901, End: 1100, Count: 1}},
+		},
+		1000: {
+			{{Start: 1, End: 1000, Count: 3}, {Start: 1001, End: 2000, Count: 1}, {Start: 5001, End: 6000, Count: 2}},
+			{{Start: 1, End: 1000, Count: 2}, {Start: 1001, End: 2000, Count: 1}},
+		},
+	},
+}
+
+func checkBins(t *testing.T, bins []*seqs.ReadBin, want []seqs.ReadBin) {
+	t.Helper()
+
+	if len(bins) != len(want) {
+		t.Fatalf("bins %v, want %v", bins, want)
+	}
+
+	for i, bin := range bins {
+		if *bin != want[i] {
+			t.Errorf("bin %d is %+v, want %+v", i, *bin, want[i])
+		}
+	}
+}
+
+// storedBins reads the runs of a chromosome and bin size of a sample db
+func storedBins(t *testing.T, conn *sql.DB, chr string, size int) []*seqs.ReadBin {
+	t.Helper()
+
+	rows, err := conn.Query(`SELECT r.start, r.end, r.count FROM reads r
+		JOIN chromosomes c ON r.chr_id = c.id
+		JOIN bins b ON r.bin_id = b.id
+		WHERE c.name = ?1 AND b.size = ?2
+		ORDER BY r.start`, chr, size)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer rows.Close()
+
+	ret := make([]*seqs.ReadBin, 0, 10)
+
+	for rows.Next() {
+		var bin seqs.ReadBin
+
+		err = rows.Scan(&bin.Start, &bin.End, &bin.Count)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ret = append(ret, &bin)
+	}
+
+	return ret
+}
+
+func TestWriteSampleDB(t *testing.T) {
+	tests := []struct {
+		paired bool
+		reads  int
+		// sum of the counts of every bin of each size, which reads
+		// spanning several bins add to more than once
+		binReads map[int]int
+	}{
+		{true, 3, map[int]int{100: 7, 1000: 4}},
+		{false, 8, map[int]int{100: 9, 1000: 9}},
+	}
+
+	for _, test := range tests {
+		row := fixtureRow(test.paired)
+
+		opts := &Options{Dir: t.TempDir(), BinSizes: []int{100, 1000}, Mode: ModeDefault}
+
+		err := WriteSampleDB(row, opts)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		path := filepath.Join(opts.Dir, "hg19", "ChIP-seq", "Columbia", "Fixture", "fixture_sample.db")
+
+		conn, err := sql.Open(db.Sqlite3DB, path)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer conn.Close()
+
+		var name string
+		var reads int
+
+		err = conn.QueryRow(`SELECT name, reads FROM sample`).Scan(&name, &reads)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if name != row.Sample || reads != test.reads {
+			t.Errorf("paired %t: sample %s with %d reads, want %s with %d", test.paired, name, reads, row.Sample, test.reads)
+		}
+
+		for size, want := range fixtureBins[test.paired] {
+			for chri, chr := range []string{"chr1", "chr2"} {
+				checkBins(t, storedBins(t, conn, chr, size), want[chri])
+			}
+
+			var binReads int
+			var bpmScaleFactor float64
+
+			err = conn.QueryRow(`SELECT reads, bpm_scale_factor FROM bins WHERE size = ?1`, size).Scan(&binReads, &bpmScaleFactor)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if binReads != test.binReads[size] || bpmScaleFactor != 1000000/float64(test.binReads[size]) {
+				t.Errorf("paired %t: bin size %d has %d reads and scale %f, want %d", test.paired, size, binReads, bpmScaleFactor, test.binReads[size])
+			}
+		}
+	}
+}
+
+func TestSmoothRuns(t *testing.T) {
+	counts := []int32{0, 1, 5, 5, 3, 0, 6}
+
+	tests := []struct {
+		mode string
+		want []readsRun
+	}{
+		// bins must have more than the min reads
+		{ModeDefault, []readsRun{{21, 40, 5}, {61, 70, 6}}},
+		// counts are rounded up to even numbers first, so 3 becomes 4
+		// which is still too few
+		{ModeRound2, []readsRun{{21, 40, 6}, {61, 70, 6}}},
+	}
+
+	for _, test := range tests {
+		runs := smoothRuns(counts, 10, &Options{Mode: test.mode, MinReads: 4})
+
+		if len(runs) != len(test.want) {
+			t.Fatalf("%s runs %v, want %v", test.mode, runs, test.want)
+		}
+
+		for i, run := range runs {
+			if *run != test.want[i] {
+				t.Errorf("%s run %d is %+v, want %+v", test.mode, i, *run, test.want[i])
+			}
+		}
+	}
+}
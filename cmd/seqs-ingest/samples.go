@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var spacesRegex = regexp.MustCompile(` +`)
+
+// SampleRow is one line of samples.tsv
+type SampleRow struct {
+	Sample      string
+	File        string
+	Genome      string
+	Assembly    string
+	Institution string
+	Dataset     string
+	Type        string
+	Technology  string
+	Paired      bool
+	Scale       string
+}
+
+func ReadSamplesFile(path string) ([]*SampleRow, error) {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.Comma = '\t'
+	reader.LazyQuotes = true
+	reader.FieldsPerRecord = -1
+
+	records, err := reader.ReadAll()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return []*SampleRow{}, nil
+	}
+
+	cols := make(map[string]int)
+
+	for i, name := range records[0] {
+		cols[strings.TrimSpace(name)] = i
+	}
+
+	for _, name := range []string{"sample", "file", "genome", "assembly", "institution", "dataset", "type", "technology"} {
+		if _, ok := cols[name]; !ok {
+			return nil, fmt.Errorf("%s is missing column %s", path, name)
+		}
+	}
+
+	get := func(record []string, name string) string {
+		i, ok := cols[name]
+
+		if !ok || i >= len(record) {
+			return ""
+		}
+
+		return strings.TrimSpace(record[i])
+	}
+
+	ret := make([]*SampleRow, 0, len(records)-1)
+
+	for _, record := range records[1:] {
+		if len(record) == 0 || (len(record) == 1 && record[0] == "") {
+			continue
+		}
+
+		paired := strings.ToLower(get(record, "paired"))
+
+		ret = append(ret, &SampleRow{
+			Sample:      get(record, "sample"),
+			File:        get(record, "file"),
+			Genome:      get(record, "genome"),
+			Assembly:    get(record, "assembly"),
+			Institution: get(record, "institution"),
+			Dataset:     get(record, "dataset"),
+			Type:        get(record, "type"),
+			Technology:  get(record, "technology"),
+			Paired:      paired == "paired" || paired == "true" || paired == "yes",
+			Scale:       get(record, "scale"),
+		})
+	}
+
+	return ret, nil
+}
+
+// SampleDir returns the folder, relative to the output folder, in which
+// the sample db of a row is stored
+func (row *SampleRow) SampleDir() string {
+	dir := path.Join(row.Assembly, row.Technology, row.Institution, row.Dataset)
+
+	return strings.ReplaceAll(spacesRegex.ReplaceAllString(dir, "_"), "&", "_AND_")
+}
+
+func (row *SampleRow) SampleDBName() string {
+	return spacesRegex.ReplaceAllString(row.Sample, "_") + ".db"
+}
@@ -0,0 +1,75 @@
+package seqs
+
+import (
+	"strings"
+
+	"github.com/antonybholmes/go-sys/log"
+)
+
+const (
+	DatasetColumnsSql = `SELECT name FROM pragma_table_info('datasets')`
+)
+
+// datasetMetadataColumns were added to the datasets table by seqs-ingest,
+// so catalogues made by older versions of step1_bamtosql.py lack them
+var datasetMetadataColumns = []string{"accession", "doi", "contact", "created_at"}
+
+// loadDatasetColumns checks which of the dataset metadata columns the
+// catalogue has so that queries can use empty values for the others
+func (sdb *SeqDB) loadDatasetColumns() error {
+	rows, err := sdb.db.Query(DatasetColumnsSql)
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+
+	for rows.Next() {
+		var name string
+
+		err := rows.Scan(&name)
+
+		if err != nil {
+			return err
+		}
+
+		columns[strings.ToLower(name)] = true
+	}
+
+	err = rows.Err()
+
+	if err != nil {
+		return err
+	}
+
+	missing := make([]string, 0, len(datasetMetadataColumns))
+	replacements := make([]string, 0, 2*len(datasetMetadataColumns))
+
+	for _, column := range datasetMetadataColumns {
+		if !columns[column] {
+			missing = append(missing, column)
+			replacements = append(replacements, "d."+column, "''")
+		}
+	}
+
+	if len(missing) > 0 {
+		log.Warn().Msgf("catalogue datasets have no %s columns, rebuild it with seqs-ingest to add them", strings.Join(missing, ", "))
+
+		sdb.datasetColumns = strings.NewReplacer(replacements...)
+	}
+
+	return nil
+}
+
+// compatSql replaces dataset metadata columns the catalogue lacks with
+// empty strings
+func (sdb *SeqDB) compatSql(query string) string {
+	if sdb.datasetColumns == nil {
+		return query
+	}
+
+	return sdb.datasetColumns.Replace(query)
+}
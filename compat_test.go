@@ -0,0 +1,115 @@
+package seqs
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/antonybholmes/go-sys/db"
+)
+
+// oldCatalogueSql is the catalogue schema of step1_bamtosql.py before
+// datasets had accession, doi, contact and created_at columns
+const oldCatalogueSql = `CREATE TABLE genomes (id INTEGER PRIMARY KEY, public_id TEXT NOT NULL UNIQUE, name TEXT NOT NULL, scientific_name TEXT NOT NULL);
+	CREATE TABLE assemblies (id INTEGER PRIMARY KEY, public_id TEXT NOT NULL UNIQUE, genome_id INTEGER NOT NULL, name TEXT NOT NULL UNIQUE);
+	CREATE TABLE technologies (id INTEGER PRIMARY KEY, public_id TEXT NOT NULL UNIQUE, name TEXT NOT NULL UNIQUE);
+	CREATE TABLE institutions (id INTEGER PRIMARY KEY, public_id TEXT NOT NULL UNIQUE, name TEXT NOT NULL UNIQUE);
+	CREATE TABLE datasets (id INTEGER PRIMARY KEY, public_id TEXT NOT NULL UNIQUE, assembly_id INTEGER NOT NULL, institution_id INTEGER NOT NULL,
+		name TEXT NOT NULL, description TEXT NOT NULL DEFAULT '', tags TEXT NOT NULL DEFAULT '');
+	CREATE TABLE permissions (id INTEGER PRIMARY KEY ASC, public_id TEXT NOT NULL UNIQUE, name TEXT NOT NULL);
+	CREATE TABLE dataset_permissions (dataset_id INTEGER, permission_id INTEGER, PRIMARY KEY(dataset_id, permission_id));
+	CREATE TABLE sample_types (id INTEGER PRIMARY KEY ASC, public_id TEXT NOT NULL UNIQUE, name TEXT NOT NULL);
+	CREATE TABLE samples (id INTEGER PRIMARY KEY, public_id TEXT NOT NULL UNIQUE, technology_id INTEGER NOT NULL, institution_id INTEGER NOT NULL,
+		dataset_id INTEGER NOT NULL, name TEXT NOT NULL UNIQUE, type_id INTEGER NOT NULL, reads INTEGER NOT NULL DEFAULT 0,
+		url TEXT NOT NULL DEFAULT '', public_url TEXT NOT NULL DEFAULT '', description TEXT NOT NULL DEFAULT '',
+		tags BLOB NOT NULL DEFAULT (jsonb('[]')));
+	INSERT INTO genomes VALUES (1, 'g1', 'Human', 'Homo sapiens');
+	INSERT INTO assemblies VALUES (1, 'a1', 1, 'hg19');
+	INSERT INTO technologies VALUES (1, 't1', 'ChIP-seq');
+	INSERT INTO institutions VALUES (1, 'i1', 'Columbia');
+	INSERT INTO datasets (id, public_id, assembly_id, institution_id, name, description) VALUES (1, 'd1', 1, 1, 'Lymphoma', 'B cells');
+	INSERT INTO permissions VALUES (1, 'p1', 'rdf:view');
+	INSERT INTO dataset_permissions VALUES (1, 1);
+	INSERT INTO sample_types VALUES (1, 'st1', 'Seq');
+	INSERT INTO samples (id, public_id, technology_id, institution_id, dataset_id, name, type_id, url)
+		VALUES (1, 's1', 1, 1, 1, 'CB_H3K27ac', 1, 'hg19/s1.db');`
+
+// openTestCatalogue opens a catalogue made by running statements
+func openTestCatalogue(t *testing.T, statements ...string) *SeqDB {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "seqs.db")
+
+	conn, err := sql.Open(db.Sqlite3DB, path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	for _, statement := range statements {
+		_, err = conn.Exec(statement)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sdb := NewSeqDB(path)
+
+	t.Cleanup(func() { sdb.Close() })
+
+	return sdb
+}
+
+func TestOldCatalogue(t *testing.T) {
+	sdb := openTestCatalogue(t, oldCatalogueSql)
+
+	datasets, err := sdb.Datasets("hg19", true, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(datasets) != 1 || datasets[0].Name != "Lymphoma" || datasets[0].Accession != "" {
+		t.Errorf("unexpected datasets %v", datasets)
+	}
+
+	dataset, err := sdb.Dataset("d1", true, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dataset.SampleCount != 1 {
+		t.Errorf("dataset has %d samples, want 1", dataset.SampleCount)
+	}
+
+	// searches and filters mention the missing columns
+	filter, err := ParseFilter("accession=GSE1 or dataset=Lymphoma")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	samples, err := sdb.Search("h3k27", "hg19", filter, true, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(samples) != 1 || samples[0].Id != "s1" {
+		t.Errorf("unexpected samples %v", samples)
+	}
+
+	facets, err := sdb.Facets("", "hg19", nil, true, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if facets.Total != 1 {
+		t.Errorf("facets of %d samples, want 1", facets.Total)
+	}
+}
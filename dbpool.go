@@ -0,0 +1,309 @@
+package seqs
+
+import (
+	"container/list"
+	"database/sql"
+	"errors"
+	"sync"
+
+	"github.com/antonybholmes/go-sys/db"
+)
+
+const (
+	DefaultSampleDBPoolSize = 64
+)
+
+var ErrPoolClosed = errors.New("sample db pool is closed")
+
+type (
+	// SampleDB is an open read only sample db with its queries prepared
+	SampleDB struct {
+		path string
+		// fileVersion of the db when it was opened. Dbs are opened as
+		// immutable so a rebuilt file must be opened again.
+		version      string
+		db           *sql.DB
+		readsStmt    *sql.Stmt
+		binReadsStmt *sql.Stmt
+		// preferred chromosome names to the names in the db
+		chrs       map[string]string
+		refs       int
+		evicted    bool
+		lruElement *list.Element
+	}
+
+	// sampleDBOpen is a db being opened, which other callers wait for
+	sampleDBOpen struct {
+		done chan struct{}
+		err  error
+	}
+
+	PoolStats struct {
+		Hits      uint64 `json:"hits"`
+		Misses    uint64 `json:"misses"`
+		Evictions uint64 `json:"evictions"`
+		Open      int    `json:"open"`
+	}
+
+	// SampleDBPool keeps a bounded number of sample dbs open so that
+	// they are not reopened on every request. The least recently used
+	// db is closed once the pool is full; dbs still in use are closed
+	// when they are released.
+	SampleDBPool struct {
+		maxSize int
+		entries map[string]*SampleDB
+		opening map[string]*sampleDBOpen
+		// front is most recently used
+		lru    *list.List
+		stats  PoolStats
+		closed bool
+		mu     sync.Mutex
+	}
+)
+
+func NewSampleDBPool(maxSize int) *SampleDBPool {
+	if maxSize < 1 {
+		maxSize = DefaultSampleDBPoolSize
+	}
+
+	return &SampleDBPool{
+		maxSize: maxSize,
+		entries: make(map[string]*SampleDB),
+		opening: make(map[string]*sampleDBOpen),
+		lru:     list.New(),
+	}
+}
+
+// Acquire returns an open db for a path. Callers must Release it when done.
+// Dbs are opened without holding the pool lock so a slow open does not
+// hold up requests for other dbs; concurrent requests for a db that is
+// being opened wait for that open to finish. A db whose file has changed
+// since it was opened is opened again.
+func (pool *SampleDBPool) Acquire(path string) (*SampleDB, error) {
+	// sqlite does not say clearly when a read only db is missing
+	version, err := fileVersion(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pool.mu.Lock()
+
+	for {
+		if pool.closed {
+			pool.mu.Unlock()
+			return nil, ErrPoolClosed
+		}
+
+		sampleDB, ok := pool.entries[path]
+
+		if ok && sampleDB.version != version {
+			// requests using the old db keep it until they release it
+			pool.evict(sampleDB)
+			ok = false
+		}
+
+		if ok {
+			pool.stats.Hits++
+			sampleDB.refs++
+			pool.lru.MoveToFront(sampleDB.lruElement)
+			pool.mu.Unlock()
+			return sampleDB, nil
+		}
+
+		opening, ok := pool.opening[path]
+
+		if !ok {
+			break
+		}
+
+		pool.mu.Unlock()
+
+		<-opening.done
+
+		if opening.err != nil {
+			return nil, opening.err
+		}
+
+		// the db is in the pool now unless it was evicted straight away
+		pool.mu.Lock()
+	}
+
+	pool.stats.Misses++
+
+	opening := &sampleDBOpen{done: make(chan struct{})}
+	pool.opening[path] = opening
+
+	pool.mu.Unlock()
+
+	sampleDB, err := openSampleDB(path)
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	delete(pool.opening, path)
+	opening.err = err
+	close(opening.done)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if pool.closed {
+		sampleDB.close()
+		return nil, ErrPoolClosed
+	}
+
+	sampleDB.refs = 1
+	sampleDB.lruElement = pool.lru.PushFront(sampleDB)
+	pool.entries[path] = sampleDB
+
+	for pool.lru.Len() > pool.maxSize {
+		pool.evict(pool.lru.Back().Value.(*SampleDB))
+	}
+
+	return sampleDB, nil
+}
+
+func (pool *SampleDBPool) Release(sampleDB *SampleDB) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	sampleDB.refs--
+
+	if sampleDB.evicted && sampleDB.refs == 0 {
+		sampleDB.close()
+	}
+}
+
+func (pool *SampleDBPool) Stats() PoolStats {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	stats := pool.stats
+	stats.Open = len(pool.entries)
+
+	return stats
+}
+
+// Close closes all idle dbs and stops new ones being opened. Dbs in use
+// are closed when they are released.
+func (pool *SampleDBPool) Close() error {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	pool.closed = true
+
+	for pool.lru.Len() > 0 {
+		pool.evict(pool.lru.Back().Value.(*SampleDB))
+	}
+
+	return nil
+}
+
+// evict removes a db from the pool, closing it if no one is using it.
+// The pool must be locked.
+func (pool *SampleDBPool) evict(sampleDB *SampleDB) {
+	pool.lru.Remove(sampleDB.lruElement)
+	delete(pool.entries, sampleDB.path)
+	sampleDB.evicted = true
+
+	if !pool.closed {
+		pool.stats.Evictions++
+	}
+
+	if sampleDB.refs == 0 {
+		sampleDB.close()
+	}
+}
+
+func openSampleDB(path string) (*SampleDB, error) {
+	// read before opening so that if the file is replaced while it is
+	// being opened, the db looks out of date and is opened again
+	version, err := fileVersion(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := sql.Open(db.Sqlite3DB, path+db.SqliteDSN)
+
+	if err != nil {
+		return nil, err
+	}
+
+	readsStmt, err := conn.Prepare(ReadsSql)
+
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	binReadsStmt, err := conn.Prepare(TotalBinReadsSql)
+
+	if err != nil {
+		readsStmt.Close()
+		conn.Close()
+		return nil, err
+	}
+
+	sampleDB := SampleDB{path: path,
+		version:      version,
+		db:           conn,
+		readsStmt:    readsStmt,
+		binReadsStmt: binReadsStmt}
+
+	err = sampleDB.loadChrs()
+
+	if err != nil {
+		sampleDB.close()
+		return nil, err
+	}
+
+	return &sampleDB, nil
+}
+
+func (sampleDB *SampleDB) loadChrs() error {
+	rows, err := sampleDB.db.Query(ChromosomesSql)
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	names := make([]string, 0, 30)
+
+	for rows.Next() {
+		var name string
+
+		err := rows.Scan(&name)
+
+		if err != nil {
+			return err
+		}
+
+		names = append(names, name)
+	}
+
+	sampleDB.chrs = defaultNaming.SourceChrs(names)
+
+	return rows.Err()
+}
+
+// sourceChr is the name the db uses for a chromosome
+func (sampleDB *SampleDB) sourceChr(chr string) string {
+	name, ok := sampleDB.chrs[chr]
+
+	if ok {
+		return name
+	}
+
+	return chr
+}
+
+func (sampleDB *SampleDB) close() {
+	sampleDB.readsStmt.Close()
+	sampleDB.binReadsStmt.Close()
+	sampleDB.db.Close()
+}
@@ -0,0 +1,225 @@
+package seqs
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/antonybholmes/go-sys/db"
+)
+
+// writeSampleDB writes a sample db with one 100 base bin on chr1 whose
+// count is given
+func writeSampleDB(t *testing.T, path string, count int) {
+	t.Helper()
+
+	os.Remove(path)
+
+	conn, err := sql.Open(db.Sqlite3DB, path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	_, err = conn.Exec(`CREATE TABLE chromosomes (id INTEGER PRIMARY KEY, name TEXT NOT NULL);
+		CREATE TABLE bins (id INTEGER PRIMARY KEY, size INTEGER NOT NULL, reads INTEGER NOT NULL, bpm_scale_factor REAL NOT NULL);
+		CREATE TABLE reads (id INTEGER PRIMARY KEY, chr_id INTEGER NOT NULL, bin_id INTEGER NOT NULL, start INTEGER NOT NULL, end INTEGER NOT NULL, count INTEGER NOT NULL);
+		INSERT INTO chromosomes (id, name) VALUES (1, 'chr1');`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = conn.Exec(`INSERT INTO bins (id, size, reads, bpm_scale_factor) VALUES (1, 100, ?1, 1000000.0 / ?1)`, count)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = conn.Exec(`INSERT INTO reads (chr_id, bin_id, start, end, count) VALUES (1, 1, 1, 100, ?1)`, count)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSampleDBPoolOpensOnce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sample.db")
+
+	writeSampleDB(t, path, 5)
+
+	pool := NewSampleDBPool(4)
+	defer pool.Close()
+
+	var wg sync.WaitGroup
+
+	dbs := make([]*SampleDB, 16)
+	errs := make([]error, len(dbs))
+
+	for i := range dbs {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			dbs[i], errs[i] = pool.Acquire(path)
+		}()
+	}
+
+	wg.Wait()
+
+	for i, sampleDB := range dbs {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+
+		if sampleDB != dbs[0] {
+			t.Errorf("db %d is a different handle", i)
+		}
+
+		pool.Release(sampleDB)
+	}
+
+	stats := pool.Stats()
+
+	if stats.Misses != 1 || stats.Hits != uint64(len(dbs)-1) || stats.Open != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestSampleDBPoolOpenError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing.db")
+
+	pool := NewSampleDBPool(4)
+	defer pool.Close()
+
+	var wg sync.WaitGroup
+
+	errs := make([]error, 8)
+
+	for i := range errs {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			_, errs[i] = pool.Acquire(path)
+		}()
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("error %v, want %v", err, os.ErrNotExist)
+		}
+	}
+
+	if pool.Stats().Open != 0 {
+		t.Errorf("failed opens should not be pooled")
+	}
+
+	pool.Close()
+
+	path = filepath.Join(t.TempDir(), "sample.db")
+
+	writeSampleDB(t, path, 5)
+
+	_, err := pool.Acquire(path)
+
+	if !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("error %v, want %v", err, ErrPoolClosed)
+	}
+}
+
+// rebuildSampleDB replaces a sample db and moves its modification time
+// on so its version changes however quickly it is rewritten
+func rebuildSampleDB(t *testing.T, path string, count int, age time.Duration) {
+	t.Helper()
+
+	writeSampleDB(t, path, count)
+
+	err := os.Chtimes(path, time.Time{}, time.Now().Add(age))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func sampleDBCount(t *testing.T, sampleDB *SampleDB) int {
+	t.Helper()
+
+	var reads int
+	var factor float64
+
+	err := sampleDB.binReadsStmt.QueryRow(sql.Named("bin_size", 100)).Scan(&reads, &factor)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return reads
+}
+
+func TestSampleDBPoolReopensChangedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sample.db")
+
+	writeSampleDB(t, path, 5)
+
+	pool := NewSampleDBPool(4)
+	defer pool.Close()
+
+	old, err := pool.Acquire(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// still in use when the file is rebuilt
+	rebuildSampleDB(t, path, 9, time.Minute)
+
+	sampleDB, err := pool.Acquire(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sampleDB == old || sampleDB.version == old.version {
+		t.Fatalf("changed db was not opened again")
+	}
+
+	if sampleDBCount(t, sampleDB) != 9 {
+		t.Errorf("new db has %d reads, want 9", sampleDBCount(t, sampleDB))
+	}
+
+	// the old db keeps working until it is released
+	if sampleDBCount(t, old) != 5 {
+		t.Errorf("old db has %d reads, want 5", sampleDBCount(t, old))
+	}
+
+	pool.Release(old)
+	pool.Release(sampleDB)
+
+	stats := pool.Stats()
+
+	if stats.Misses != 2 || stats.Evictions != 1 || stats.Open != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	// unchanged so reused
+	again, err := pool.Acquire(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool.Release(again)
+
+	if again != sampleDB {
+		t.Errorf("unchanged db was opened again")
+	}
+}
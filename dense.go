@@ -0,0 +1,118 @@
+package seqs
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/antonybholmes/go-dna"
+)
+
+const (
+	// bins in the form the sample type has always returned them, which
+	// for sample dbs and bams is runs of bins with the same value,
+	// leaving out empty bins, and for bigwigs is every bin with empty
+	// bins as 0
+	OutputSparse = "sparse"
+	// one value for every bin across the location
+	OutputDense = "dense"
+
+	// empty bins in dense output are 0
+	FillZero = "zero"
+	// empty bins in dense output are null
+	FillNull = "null"
+)
+
+// DenseValues is one value per bin. Empty bins are NaN, which are
+// written as null in json since json has no NaN.
+type DenseValues []float64
+
+func (values DenseValues) MarshalJSON() ([]byte, error) {
+	buf := make([]byte, 0, 2+len(values)*4)
+
+	buf = append(buf, '[')
+
+	for i, v := range values {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+
+		if math.IsNaN(v) {
+			buf = append(buf, "null"...)
+		} else {
+			buf = strconv.AppendFloat(buf, v, 'g', -1, 64)
+		}
+	}
+
+	buf = append(buf, ']')
+
+	return buf, nil
+}
+
+func ParseOutput(output string) (string, error) {
+	output = strings.ToLower(strings.TrimSpace(output))
+
+	switch output {
+	case "":
+		return OutputSparse, nil
+	case OutputSparse, OutputDense:
+		return output, nil
+	default:
+		return "", fmt.Errorf("unknown output %s", output)
+	}
+}
+
+func ParseFill(fill string) (string, error) {
+	fill = strings.ToLower(strings.TrimSpace(fill))
+
+	switch fill {
+	case "":
+		return FillZero, nil
+	case FillZero, FillNull:
+		return fill, nil
+	default:
+		return "", fmt.Errorf("unknown fill %s", fill)
+	}
+}
+
+// Densify replaces the sparse bins with a value for every bin of BinSize
+// overlapping the location. Start is set to the 1-based start of the
+// first bin. Bins not covered by the sparse bins are given 0 or null
+// depending on fill.
+func (counts *SampleBinCounts) Densify(location *dna.Location, fill string) {
+	binSize := counts.BinSize
+
+	if binSize < 1 {
+		return
+	}
+
+	startBin := (location.Start() - 1) / binSize
+	endBin := (location.End() - 1) / binSize
+
+	empty := 0.0
+
+	if fill == FillNull {
+		empty = math.NaN()
+	}
+
+	values := make(DenseValues, endBin-startBin+1)
+
+	for i := range values {
+		values[i] = empty
+	}
+
+	for _, bin := range counts.Bins {
+		// bins are 1-based inclusive
+		sb := max((bin.Start-1)/binSize, startBin)
+		eb := min((bin.End-1)/binSize, endBin)
+
+		for b := sb; b <= eb; b++ {
+			values[b-startBin] = bin.Count
+		}
+	}
+
+	counts.Start = startBin*binSize + 1
+	counts.Values = values
+	counts.Bins = []*ReadBin{}
+}
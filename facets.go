@@ -0,0 +1,112 @@
+package seqs
+
+import "strings"
+
+const (
+	FacetTotal       = "total"
+	FacetTechnology  = "technology"
+	FacetInstitution = "institution"
+	FacetDataset     = "dataset"
+	FacetTag         = "tag"
+
+	// facetsSql counts the samples of a search, which is put in place of
+	// <<SEARCH>> so that facets see the same samples as the search
+	facetsSql = `WITH matches AS (<<SEARCH>>)
+		SELECT 'total' AS facet, '' AS name, '' AS value, COUNT(*) AS n FROM matches
+		UNION ALL
+		SELECT 'technology', technology, '', COUNT(*) FROM matches GROUP BY technology
+		UNION ALL
+		SELECT 'institution', institution, '', COUNT(*) FROM matches GROUP BY institution
+		UNION ALL
+		SELECT 'dataset', dataset_name, '', COUNT(*) FROM matches GROUP BY dataset_name
+		UNION ALL
+		SELECT 'tag',
+			COALESCE(json_extract(tag.value, '$.name'), '') AS tag_name,
+			COALESCE(json_extract(tag.value, '$.value'), '') AS tag_value,
+			COUNT(DISTINCT matches.public_id)
+			FROM matches, json_each(matches.tags) tag
+			GROUP BY tag_name, tag_value
+		ORDER BY n DESC, name, value`
+)
+
+type (
+	Facet struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}
+
+	// TagFacet counts the values of a tag
+	TagFacet struct {
+		Name   string   `json:"name"`
+		Values []*Facet `json:"values"`
+	}
+
+	// SearchFacets are the number of samples of a search with each
+	// technology, institution, dataset and tag value, most common first,
+	// so that clients can offer ways to narrow the search
+	SearchFacets struct {
+		Total        int         `json:"total"`
+		Technologies []*Facet    `json:"technologies"`
+		Institutions []*Facet    `json:"institutions"`
+		Datasets     []*Facet    `json:"datasets"`
+		Tags         []*TagFacet `json:"tags"`
+	}
+)
+
+// Facets counts the samples a search would return by their fields and
+// tags. It takes the same arguments as Search.
+func (sdb *SeqDB) Facets(query string, assembly string, filter *Filter, isAdmin bool, permissions []string) (*SearchFacets, error) {
+	searchQuery, namedArgs := sdb.searchSql(query, assembly, filter, isAdmin, permissions)
+
+	rows, err := sdb.db.Query(strings.Replace(facetsSql, "<<SEARCH>>", searchQuery, 1), namedArgs...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	ret := SearchFacets{Technologies: make([]*Facet, 0, 10),
+		Institutions: make([]*Facet, 0, 10),
+		Datasets:     make([]*Facet, 0, 10),
+		Tags:         make([]*TagFacet, 0, 10)}
+
+	// tags in order of their most common value
+	tags := make(map[string]*TagFacet)
+
+	for rows.Next() {
+		var facet string
+		var name string
+		var value string
+		var count int
+
+		err := rows.Scan(&facet, &name, &value, &count)
+
+		if err != nil {
+			return nil, err
+		}
+
+		switch facet {
+		case FacetTotal:
+			ret.Total = count
+		case FacetTechnology:
+			ret.Technologies = append(ret.Technologies, &Facet{Name: name, Count: count})
+		case FacetInstitution:
+			ret.Institutions = append(ret.Institutions, &Facet{Name: name, Count: count})
+		case FacetDataset:
+			ret.Datasets = append(ret.Datasets, &Facet{Name: name, Count: count})
+		case FacetTag:
+			tag, ok := tags[name]
+
+			if !ok {
+				tag = &TagFacet{Name: name, Values: make([]*Facet, 0, 10)}
+				tags[name] = tag
+				ret.Tags = append(ret.Tags, tag)
+			}
+
+			tag.Values = append(tag.Values, &Facet{Name: value, Count: count})
+		}
+	}
+
+	return &ret, rows.Err()
+}
@@ -0,0 +1,116 @@
+package seqs
+
+import (
+	"testing"
+)
+
+// facetsCatalogueSql adds a second dataset, with its own technology,
+// institution and permission, to the samples of searchSamplesSql
+const facetsCatalogueSql = `INSERT INTO technologies VALUES (2, 't2', 'RNA-seq');
+	INSERT INTO institutions VALUES (2, 'i2', 'Cornell');
+	INSERT INTO datasets (id, public_id, assembly_id, institution_id, name, description) VALUES (2, 'd2', 1, 2, 'Atlas', 'Tonsil');
+	INSERT INTO permissions VALUES (2, 'p2', 'atlas:view');
+	INSERT INTO dataset_permissions VALUES (2, 2);
+	INSERT INTO samples (id, public_id, technology_id, institution_id, dataset_id, name, type_id, url, tags)
+	VALUES (4, 's4', 2, 2, 2, 'Atlas_1', 1, 'hg19/s4.db', jsonb('[{"name": "cell type", "value": "CB"}]'));
+	INSERT INTO samples (id, public_id, technology_id, institution_id, dataset_id, name, type_id, url, tags)
+	VALUES (5, 's5', 2, 2, 2, 'Atlas_2', 1, 'hg19/s5.db', jsonb('[{"name": "cell type", "value": "NB"}]'));
+	UPDATE samples SET reads = CASE id WHEN 1 THEN 5 WHEN 2 THEN 20 WHEN 3 THEN 20 WHEN 4 THEN 30 ELSE 10 END;`
+
+func openFacetsCatalogue(t *testing.T) *SeqDB {
+	return openTestCatalogue(t, oldCatalogueSql, searchSamplesSql, facetsCatalogueSql)
+}
+
+func checkFacets(t *testing.T, what string, facets []*Facet, want []Facet) {
+	t.Helper()
+
+	if len(facets) != len(want) {
+		t.Errorf("%s %v, want %v", what, facets, want)
+		return
+	}
+
+	for i, facet := range facets {
+		if *facet != want[i] {
+			t.Errorf("%s %d is %+v, want %+v", what, i, *facet, want[i])
+		}
+	}
+}
+
+func TestFacets(t *testing.T) {
+	sdb := openFacetsCatalogue(t)
+
+	facets, err := sdb.Facets("", "hg19", nil, true, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if facets.Total != 5 {
+		t.Errorf("total %d, want 5", facets.Total)
+	}
+
+	// most common first
+	checkFacets(t, "technologies", facets.Technologies, []Facet{{"ChIP-seq", 3}, {"RNA-seq", 2}})
+	checkFacets(t, "institutions", facets.Institutions, []Facet{{"Columbia", 3}, {"Cornell", 2}})
+	checkFacets(t, "datasets", facets.Datasets, []Facet{{"Lymphoma", 3}, {"Atlas", 2}})
+
+	// tags in the order of their most common value
+	if len(facets.Tags) != 2 || facets.Tags[0].Name != "cell type" || facets.Tags[1].Name != "antibody" {
+		t.Fatalf("unexpected tags %v", facets.Tags)
+	}
+
+	checkFacets(t, "cell types", facets.Tags[0].Values, []Facet{{"CB", 2}, {"NB", 1}})
+	checkFacets(t, "antibodies", facets.Tags[1].Values, []Facet{{"BCL6", 1}, {"H3K27ac", 1}})
+
+	// facets see the same samples as the search
+	filter, err := ParseFilter("cell_type=CB")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	facets, err = sdb.Facets("", "hg19", filter, true, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if facets.Total != 2 {
+		t.Errorf("filtered total %d, want 2", facets.Total)
+	}
+
+	checkFacets(t, "filtered datasets", facets.Datasets, []Facet{{"Atlas", 1}, {"Lymphoma", 1}})
+
+	facets, err = sdb.Facets("atlas", "GRCh37", nil, true, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if facets.Total != 2 || len(facets.Tags) != 1 {
+		t.Errorf("search facets %+v", *facets)
+	}
+
+	// and only the samples the user can view
+	facets, err = sdb.Facets("", "hg19", nil, false, []string{"rdf:view"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if facets.Total != 3 {
+		t.Errorf("viewable total %d, want 3", facets.Total)
+	}
+
+	checkFacets(t, "viewable datasets", facets.Datasets, []Facet{{"Lymphoma", 3}})
+
+	facets, err = sdb.Facets("nothing", "hg19", nil, true, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if facets.Total != 0 || len(facets.Technologies) != 0 || len(facets.Tags) != 0 {
+		t.Errorf("facets of no samples %+v", *facets)
+	}
+}
@@ -0,0 +1,471 @@
+package seqs
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// A filter narrows a search by tags and core fields, for example
+//
+//	antibody=BCL6 AND cell_type IN (CB, NB) AND technology=ChIP-seq
+//
+// Conditions are field = value, field != value, field IN (values) and
+// field NOT IN (values), joined with AND, OR and NOT and grouped with
+// brackets. AND binds tighter than OR. Values with spaces or symbols are
+// quoted with " or ' and a * in a value matches anything. Fields that are
+// not core fields name a tag, with spaces and underscores in tag names
+// treated as the same. Matching ignores case.
+//
+// A negated condition is the opposite of the condition, so
+// antibody != BCL6 and antibody NOT IN (BCL6) match every sample that
+// does not have an antibody tag of BCL6, including samples with no
+// antibody tag at all. To only match samples that have the tag, add a
+// wildcard condition such as antibody=* AND antibody != BCL6.
+//
+// Filters are translated into sql in which every field and value is a
+// parameter, so user input never becomes part of the query text.
+const (
+	MaxFilterLength = 1024
+	// maximum number of conditions and values in a filter
+	MaxFilterTerms = 64
+
+	filterPlaceholder = "<<FILTER>>"
+
+	// tags are a json array of name, value objects
+	tagFilterSql = `EXISTS (SELECT 1 FROM json_each(s.tags) tag
+		WHERE LOWER(REPLACE(json_extract(tag.value, '$.name'), ' ', '_')) = :%s
+		AND %s)`
+)
+
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// core fields that can be filtered on and their columns
+var filterFields = map[string]string{
+	"technology":  "t.name",
+	"institution": "ins.name",
+	"dataset":     "d.name",
+	"accession":   "d.accession",
+	"sample":      "s.name",
+	"name":        "s.name",
+	"type":        "st.name",
+}
+
+type (
+	// Filter is a parsed filter ready to add to a search
+	Filter struct {
+		sql  string
+		args []any
+	}
+
+	filterToken struct {
+		text string
+		// quoted strings are always values, never keywords or symbols
+		quoted bool
+	}
+
+	filterParser struct {
+		tokens []filterToken
+		pos    int
+		args   []any
+		terms  int
+	}
+)
+
+// ParseFilter parses a filter expression. An empty expression is no
+// filter and returns nil.
+func ParseFilter(expr string) (*Filter, error) {
+	expr = strings.TrimSpace(expr)
+
+	if expr == "" {
+		return nil, nil
+	}
+
+	if len(expr) > MaxFilterLength {
+		return nil, fmt.Errorf("%w: longer than %d characters", ErrInvalidFilter, MaxFilterLength)
+	}
+
+	tokens, err := tokenizeFilter(expr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	parser := filterParser{tokens: tokens, args: make([]any, 0, 10)}
+
+	clause, err := parser.or()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if parser.pos < len(parser.tokens) {
+		return nil, fmt.Errorf("%w: unexpected %q", ErrInvalidFilter, parser.tokens[parser.pos].text)
+	}
+
+	return &Filter{sql: clause, args: parser.args}, nil
+}
+
+// makeFilterSql replaces the filter placeholder of a query with the
+// filter and adds its parameters to the named args. A nil filter matches
+// everything.
+func makeFilterSql(query string, filter *Filter, namedArgs *[]any) string {
+	if filter == nil {
+		return strings.Replace(query, filterPlaceholder, "", 1)
+	}
+
+	*namedArgs = append(*namedArgs, filter.args...)
+
+	return strings.Replace(query, filterPlaceholder, "AND ("+filter.sql+")", 1)
+}
+
+func tokenizeFilter(expr string) ([]filterToken, error) {
+	tokens := make([]filterToken, 0, 20)
+	runes := []rune(expr)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')' || r == ',' || r == '=':
+			tokens = append(tokens, filterToken{text: string(r)})
+			i++
+		case r == '!':
+			if i+1 >= len(runes) || runes[i+1] != '=' {
+				return nil, fmt.Errorf("%w: expected != at %d", ErrInvalidFilter, i)
+			}
+
+			tokens = append(tokens, filterToken{text: "!="})
+			i += 2
+		case r == '"' || r == '\'':
+			end := i + 1
+
+			for end < len(runes) && runes[end] != r {
+				end++
+			}
+
+			if end >= len(runes) {
+				return nil, fmt.Errorf("%w: unclosed quote at %d", ErrInvalidFilter, i)
+			}
+
+			tokens = append(tokens, filterToken{text: string(runes[i+1 : end]), quoted: true})
+			i = end + 1
+		default:
+			end := i
+
+			for end < len(runes) && !unicode.IsSpace(runes[end]) && !strings.ContainsRune(`(),=!"'`, runes[end]) {
+				end++
+			}
+
+			tokens = append(tokens, filterToken{text: string(runes[i:end])})
+			i = end
+		}
+	}
+
+	return tokens, nil
+}
+
+// keyword tests if the next token is an unquoted keyword or symbol and
+// if so consumes it
+func (parser *filterParser) keyword(keyword string) bool {
+	if parser.pos >= len(parser.tokens) {
+		return false
+	}
+
+	token := parser.tokens[parser.pos]
+
+	if token.quoted || !strings.EqualFold(token.text, keyword) {
+		return false
+	}
+
+	parser.pos++
+
+	return true
+}
+
+func (parser *filterParser) expect(keyword string) error {
+	if parser.keyword(keyword) {
+		return nil
+	}
+
+	if parser.pos >= len(parser.tokens) {
+		return fmt.Errorf("%w: expected %s at end", ErrInvalidFilter, keyword)
+	}
+
+	return fmt.Errorf("%w: expected %s but found %q", ErrInvalidFilter, keyword, parser.tokens[parser.pos].text)
+}
+
+// word reads a field name or value
+func (parser *filterParser) word(what string) (string, error) {
+	if parser.pos >= len(parser.tokens) {
+		return "", fmt.Errorf("%w: expected %s at end", ErrInvalidFilter, what)
+	}
+
+	token := parser.tokens[parser.pos]
+
+	if !token.quoted && (token.text == "" || strings.ContainsAny(token.text, "(),=") || token.text == "!=") {
+		return "", fmt.Errorf("%w: expected %s but found %q", ErrInvalidFilter, what, token.text)
+	}
+
+	parser.pos++
+
+	return token.text, nil
+}
+
+func (parser *filterParser) or() (string, error) {
+	clause, err := parser.and()
+
+	if err != nil {
+		return "", err
+	}
+
+	clauses := []string{clause}
+
+	for parser.keyword("OR") {
+		clause, err := parser.and()
+
+		if err != nil {
+			return "", err
+		}
+
+		clauses = append(clauses, clause)
+	}
+
+	return joinFilterClauses(clauses, " OR "), nil
+}
+
+func (parser *filterParser) and() (string, error) {
+	clause, err := parser.not()
+
+	if err != nil {
+		return "", err
+	}
+
+	clauses := []string{clause}
+
+	for parser.keyword("AND") {
+		clause, err := parser.not()
+
+		if err != nil {
+			return "", err
+		}
+
+		clauses = append(clauses, clause)
+	}
+
+	return joinFilterClauses(clauses, " AND "), nil
+}
+
+func (parser *filterParser) not() (string, error) {
+	if parser.keyword("NOT") {
+		clause, err := parser.not()
+
+		if err != nil {
+			return "", err
+		}
+
+		return "NOT " + clause, nil
+	}
+
+	if parser.keyword("(") {
+		clause, err := parser.or()
+
+		if err != nil {
+			return "", err
+		}
+
+		err = parser.expect(")")
+
+		if err != nil {
+			return "", err
+		}
+
+		return "(" + clause + ")", nil
+	}
+
+	return parser.condition()
+}
+
+func (parser *filterParser) condition() (string, error) {
+	field, err := parser.word("field")
+
+	if err != nil {
+		return "", err
+	}
+
+	negate := false
+	var values []string
+
+	switch {
+	case parser.keyword("="):
+		value, err := parser.word("value")
+
+		if err != nil {
+			return "", err
+		}
+
+		values = []string{value}
+	case parser.keyword("!="):
+		value, err := parser.word("value")
+
+		if err != nil {
+			return "", err
+		}
+
+		negate = true
+		values = []string{value}
+	default:
+		negate = parser.keyword("NOT")
+
+		err := parser.expect("IN")
+
+		if err != nil {
+			return "", err
+		}
+
+		values, err = parser.list()
+
+		if err != nil {
+			return "", err
+		}
+	}
+
+	clause, err := parser.match(field, values)
+
+	if err != nil {
+		return "", err
+	}
+
+	if negate {
+		return "NOT " + clause, nil
+	}
+
+	return clause, nil
+}
+
+// list reads the bracketed values of an IN
+func (parser *filterParser) list() ([]string, error) {
+	err := parser.expect("(")
+
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]string, 0, 5)
+
+	for {
+		value, err := parser.word("value")
+
+		if err != nil {
+			return nil, err
+		}
+
+		values = append(values, value)
+
+		if !parser.keyword(",") {
+			break
+		}
+	}
+
+	err = parser.expect(")")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+// match is the sql testing if a field has any of the values
+func (parser *filterParser) match(field string, values []string) (string, error) {
+	field = filterFieldName(field)
+
+	column, ok := filterFields[field]
+
+	if ok {
+		return parser.values("LOWER("+column+")", values)
+	}
+
+	valuesSql, err := parser.values("LOWER(json_extract(tag.value, '$.value'))", values)
+
+	if err != nil {
+		return "", err
+	}
+
+	name, err := parser.arg(field)
+
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf(tagFilterSql, name, valuesSql), nil
+}
+
+func (parser *filterParser) values(column string, values []string) (string, error) {
+	clauses := make([]string, 0, len(values))
+
+	for _, value := range values {
+		value = strings.ToLower(strings.TrimSpace(value))
+
+		if strings.Contains(value, "*") {
+			name, err := parser.arg(likeFilterValue(value))
+
+			if err != nil {
+				return "", err
+			}
+
+			clauses = append(clauses, fmt.Sprintf(`%s LIKE :%s ESCAPE '\'`, column, name))
+		} else {
+			name, err := parser.arg(value)
+
+			if err != nil {
+				return "", err
+			}
+
+			clauses = append(clauses, fmt.Sprintf("%s = :%s", column, name))
+		}
+	}
+
+	return joinFilterClauses(clauses, " OR "), nil
+}
+
+// arg adds a parameter and returns its name
+func (parser *filterParser) arg(value string) (string, error) {
+	parser.terms++
+
+	if parser.terms > MaxFilterTerms {
+		return "", fmt.Errorf("%w: more than %d terms", ErrInvalidFilter, MaxFilterTerms)
+	}
+
+	name := fmt.Sprintf("filter%d", len(parser.args)+1)
+
+	parser.args = append(parser.args, sql.Named(name, value))
+
+	return name, nil
+}
+
+// filterFieldName is the form fields and tag names are compared in
+func filterFieldName(field string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(field)), " ", "_")
+}
+
+// likeFilterValue turns a value with * wildcards into a LIKE pattern
+func likeFilterValue(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, "%", `\%`)
+	value = strings.ReplaceAll(value, "_", `\_`)
+
+	return strings.ReplaceAll(value, "*", "%")
+}
+
+func joinFilterClauses(clauses []string, op string) string {
+	if len(clauses) == 1 {
+		return clauses[0]
+	}
+
+	return "(" + strings.Join(clauses, op) + ")"
+}
@@ -0,0 +1,290 @@
+package seqs
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// filterArgs are the names and values of the parameters of a filter
+func filterArgs(t *testing.T, filter *Filter) ([]string, []string) {
+	t.Helper()
+
+	names := make([]string, 0, len(filter.args))
+	values := make([]string, 0, len(filter.args))
+
+	for _, arg := range filter.args {
+		named, ok := arg.(sql.NamedArg)
+
+		if !ok {
+			t.Fatalf("arg %v is not named", arg)
+		}
+
+		names = append(names, named.Name)
+		values = append(values, named.Value.(string))
+	}
+
+	return names, values
+}
+
+var filterParamRegex = regexp.MustCompile(`:(\w+)`)
+
+// checkFilterParams tests that the sql uses every parameter of a filter
+// and no others
+func checkFilterParams(t *testing.T, expr string, filter *Filter) {
+	t.Helper()
+
+	names, _ := filterArgs(t, filter)
+
+	used := make([]string, 0, len(names))
+
+	for _, match := range filterParamRegex.FindAllStringSubmatch(filter.sql, -1) {
+		if !slices.Contains(used, match[1]) {
+			used = append(used, match[1])
+		}
+	}
+
+	slices.Sort(used)
+	slices.Sort(names)
+
+	if !slices.Equal(used, names) {
+		t.Errorf("%s uses parameters %v but has %v", expr, used, names)
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		expr   string
+		sql    string
+		values []string
+	}{
+		// AND binds tighter than OR
+		{"technology=a OR dataset=b AND sample=c",
+			"(LOWER(t.name) = :filter1 OR (LOWER(d.name) = :filter2 AND LOWER(s.name) = :filter3))",
+			[]string{"a", "b", "c"}},
+		// groups keep their brackets around the joined clauses
+		{"(technology=a OR dataset=b) AND sample=c",
+			"(((LOWER(t.name) = :filter1 OR LOWER(d.name) = :filter2)) AND LOWER(s.name) = :filter3)",
+			[]string{"a", "b", "c"}},
+		{"NOT technology=a",
+			"NOT LOWER(t.name) = :filter1",
+			[]string{"a"}},
+		{"not not (technology=a and (dataset=b or NOT sample=c))",
+			"NOT NOT ((LOWER(t.name) = :filter1 AND ((LOWER(d.name) = :filter2 OR NOT LOWER(s.name) = :filter3))))",
+			[]string{"a", "b", "c"}},
+		// quoted values can be empty
+		{`accession=""`,
+			"LOWER(d.accession) = :filter1",
+			[]string{""}},
+		{"technology != a",
+			"NOT LOWER(t.name) = :filter1",
+			[]string{"a"}},
+		{"technology IN (ChIP-seq, RNA-seq)",
+			"(LOWER(t.name) = :filter1 OR LOWER(t.name) = :filter2)",
+			[]string{"chip-seq", "rna-seq"}},
+		{"technology not in (a)",
+			"NOT LOWER(t.name) = :filter1",
+			[]string{"a"}},
+		// quoted values can have spaces, symbols and keywords
+		{`dataset="Lymphoma AND (B cells)" or accession='GSE"1"'`,
+			"(LOWER(d.name) = :filter1 OR LOWER(d.accession) = :filter2)",
+			[]string{"lymphoma and (b cells)", `gse"1"`}},
+		{`type="OR"`,
+			"LOWER(st.name) = :filter1",
+			[]string{"or"}},
+		// * is the only wildcard, so LIKE wildcards and the escape are
+		// escaped
+		{`sample=*a%b_c\d*`,
+			`LOWER(s.name) LIKE :filter1 ESCAPE '\'`,
+			[]string{`%a\%b\_c\\d%`}},
+		// tag names are a parameter after the values
+		{`"Cell Type" IN (CB, NB*)`,
+			fmt.Sprintf(tagFilterSql, "filter3", "(LOWER(json_extract(tag.value, '$.value')) = :filter1 OR LOWER(json_extract(tag.value, '$.value')) LIKE :filter2 ESCAPE '\\')"),
+			[]string{"cb", "nb%", "cell_type"}},
+		{"antibody != BCL6",
+			"NOT " + fmt.Sprintf(tagFilterSql, "filter2", "LOWER(json_extract(tag.value, '$.value')) = :filter1"),
+			[]string{"bcl6", "antibody"}},
+	}
+
+	for _, test := range tests {
+		filter, err := ParseFilter(test.expr)
+
+		if err != nil {
+			t.Errorf("%s: %s", test.expr, err)
+			continue
+		}
+
+		if filter.sql != test.sql {
+			t.Errorf("%s\ngave %s\nwant %s", test.expr, filter.sql, test.sql)
+		}
+
+		_, values := filterArgs(t, filter)
+
+		if !slices.Equal(values, test.values) {
+			t.Errorf("%s has values %q, want %q", test.expr, values, test.values)
+		}
+
+		checkFilterParams(t, test.expr, filter)
+	}
+
+	filter, err := ParseFilter("  ")
+
+	if filter != nil || err != nil {
+		t.Errorf("empty filter gave %v %v", filter, err)
+	}
+}
+
+func TestParseFilterParams(t *testing.T) {
+	// values that look like sql stay in the parameters
+	expr := `dataset="x' OR 1=1; DROP TABLE samples; --" OR "it's"="*'*"`
+
+	filter, err := ParseFilter(expr)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, text := range []string{"DROP", "1=1", "--", "it"} {
+		if strings.Contains(filter.sql, text) {
+			t.Errorf("%s is in the sql %s", text, filter.sql)
+		}
+	}
+
+	_, values := filterArgs(t, filter)
+
+	if !slices.Equal(values, []string{"x' or 1=1; drop table samples; --", "%'%", "it's"}) {
+		t.Errorf("unexpected values %q", values)
+	}
+
+	checkFilterParams(t, expr, filter)
+}
+
+func TestParseFilterLimits(t *testing.T) {
+	values := func(n int) string {
+		v := make([]string, n)
+
+		for i := range v {
+			v[i] = fmt.Sprintf("v%d", i)
+		}
+
+		return "technology IN (" + strings.Join(v, ",") + ")"
+	}
+
+	_, err := ParseFilter(values(MaxFilterTerms))
+
+	if err != nil {
+		t.Errorf("%d terms: %s", MaxFilterTerms, err)
+	}
+
+	_, err = ParseFilter(values(MaxFilterTerms + 1))
+
+	if !errors.Is(err, ErrInvalidFilter) {
+		t.Errorf("%d terms: error %v", MaxFilterTerms+1, err)
+	}
+
+	// tag names are terms too
+	_, err = ParseFilter(strings.Replace(values(MaxFilterTerms), "technology", "antibody", 1))
+
+	if !errors.Is(err, ErrInvalidFilter) {
+		t.Errorf("%d tag values: error %v", MaxFilterTerms, err)
+	}
+
+	expr := "sample=" + strings.Repeat("a", MaxFilterLength-len("sample="))
+
+	_, err = ParseFilter(expr)
+
+	if err != nil {
+		t.Errorf("%d characters: %s", len(expr), err)
+	}
+
+	_, err = ParseFilter(expr + "a")
+
+	if !errors.Is(err, ErrInvalidFilter) {
+		t.Errorf("%d characters: error %v", len(expr)+1, err)
+	}
+}
+
+func TestParseFilterMalformed(t *testing.T) {
+	for _, expr := range []string{
+		"a=",
+		"=b",
+		"a",
+		"a b",
+		"a==b",
+		"a ! b",
+		"a IN ()",
+		"a IN (x",
+		"a IN (x,)",
+		"a IN x",
+		"a NOT b",
+		`a="x`,
+		`a='x"`,
+		"a=x)",
+		")",
+		"(a=x",
+		"()",
+		"a=x AND",
+		"OR a=x",
+		"a=x b=y",
+		"NOT",
+	} {
+		filter, err := ParseFilter(expr)
+
+		if !errors.Is(err, ErrInvalidFilter) {
+			t.Errorf("%s gave %v, error %v", expr, filter, err)
+		}
+	}
+}
+
+func TestFilterSearch(t *testing.T) {
+	sdb := openTestCatalogue(t, oldCatalogueSql, searchSamplesSql)
+
+	tests := []struct {
+		expr string
+		want []string
+	}{
+		{"antibody=bcl6", []string{"s3"}},
+		{"antibody=h3k27* OR antibody=BCL6", []string{"s2", "s3"}},
+		{`"cell type"=CB AND antibody=H3K27ac`, []string{"s2"}},
+		{"cell_type IN (NB, cb)", []string{"s2"}},
+		// samples without the tag do not have the value either
+		{"antibody != BCL6", []string{"s1", "s2"}},
+		{"antibody NOT IN (BCL6, H3K27ac)", []string{"s1"}},
+		// unless the tag is required
+		{"antibody=* AND antibody != BCL6", []string{"s2"}},
+		{"dataset=lymphoma AND NOT (sample=Sample_2 OR sample=*_3)", []string{"s1"}},
+		{"technology=ChIP-seq AND institution=columbia", []string{"s1", "s2", "s3"}},
+	}
+
+	for _, test := range tests {
+		filter, err := ParseFilter(test.expr)
+
+		if err != nil {
+			t.Errorf("%s: %s", test.expr, err)
+			continue
+		}
+
+		samples, err := sdb.Search("", "hg19", filter, true, nil)
+
+		if err != nil {
+			t.Errorf("%s: %s", test.expr, err)
+			continue
+		}
+
+		ids := make([]string, 0, len(samples))
+
+		for _, sample := range samples {
+			ids = append(ids, sample.Id)
+		}
+
+		slices.Sort(ids)
+
+		if !slices.Equal(ids, test.want) {
+			t.Errorf("%s found %v, want %v", test.expr, ids, test.want)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package seqs
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"sync"
+
+	"github.com/antonybholmes/go-dna"
+	"github.com/antonybholmes/go-seqs/bam"
+	"github.com/antonybholmes/go-seqs/bigwig"
+)
+
+const (
+	BinSizesSql = `SELECT size FROM bins ORDER BY size`
+
+	ChromosomesSql = `SELECT name FROM chromosomes ORDER BY id`
+)
+
+// SampleLayout describes what can be asked of a sample so that requests
+// can be checked before any data is read.
+type SampleLayout struct {
+	// bin sizes stored by the sample, or nil if it can be binned
+	// at any size
+	BinSizes []int
+
+	// bin sizes that can be read efficiently, smallest first: the
+	// stored sizes of a sample db or the zoom levels of a bigwig
+	Resolutions []int
+
+	// chromosome lengths keyed by preferred name (see ParseChr).
+	// The length is 0 if the sample does not record it.
+	Chromosomes map[string]int
+}
+
+// SupportsBinSize tests whether a sample can be read at a bin size,
+// either because it is stored or because it can be resampled from a
+// finer stored size.
+func (layout *SampleLayout) SupportsBinSize(binSize int) bool {
+	if layout.BinSizes == nil {
+		return true
+	}
+
+	_, ok := resampleSource(layout.BinSizes, binSize)
+
+	return ok
+}
+
+// Check tests whether a location can be read from a sample at a bin size
+func (layout *SampleLayout) Check(location *dna.Location, binSize int) error {
+	if !layout.SupportsBinSize(binSize) {
+		return fmt.Errorf("%w: %d, stored sizes are %v", ErrUnsupportedBinSize, binSize, layout.BinSizes)
+	}
+
+	_, ok := layout.Chromosomes[location.Chr()]
+
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrChrNotInSample, location.Chr())
+	}
+
+	return nil
+}
+
+// BestBinSize picks the bin size to use when a location should be shown
+// with bins of about idealSize. The coarsest resolution no larger than
+// idealSize is used so that there are at least as many bins as asked
+// for. If there is none, samples that can be binned at any size use
+// idealSize and the others their finest resolution.
+func (layout *SampleLayout) BestBinSize(idealSize int) int {
+	best := 0
+
+	for _, size := range layout.Resolutions {
+		if size <= idealSize {
+			best = max(best, size)
+		}
+	}
+
+	if best > 0 {
+		return best
+	}
+
+	if layout.BinSizes == nil || len(layout.Resolutions) == 0 {
+		return idealSize
+	}
+
+	return layout.Resolutions[0]
+}
+
+// SampleLayout reads the bin sizes and chromosomes of a sample. It does
+// not check permissions.
+func (sdb *SeqDB) SampleLayout(ctx context.Context, sampleId string) (*SampleLayout, error) {
+	sample, err := sdb.sample(sampleId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var layout *SampleLayout
+
+	switch sample.Type {
+	case SampleTypeBigWig, SampleTypeRemoteBigWig:
+		if bigwig.IsUrl(sample.Url) {
+			// the remote cache keeps the bigwig open so this is cheap
+			layout, err = bigWigLayout(ctx, sample.Url)
+		} else {
+			layout, err = sdb.layouts.layout(sample.Url, fileVersion, func() (*SampleLayout, error) {
+				return bigWigLayout(ctx, sample.Url)
+			})
+		}
+	case SampleTypeBam:
+		path := sdb.samplePath(sample.Url)
+
+		layout, err = sdb.layouts.layout(path, func(path string) (string, error) {
+			return bamIndexVersion(path, bam.IndexPath(path))
+		}, func() (*SampleLayout, error) {
+			return bamLayout(path)
+		})
+	default:
+		path := filepath.Join(sdb.url, sample.Url)
+
+		layout, err = sdb.layouts.layout(path, fileVersion, func() (*SampleLayout, error) {
+			return sdb.sampleDBLayout(ctx, path)
+		})
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// layouts are shared so copy the chromosomes before filling in the
+	// lengths sample dbs do not record from the catalogue
+	ret := *layout
+	ret.Chromosomes = maps.Clone(layout.Chromosomes)
+
+	for chr, size := range ret.Chromosomes {
+		if size == 0 {
+			ret.Chromosomes[chr] = sample.ChrSize(chr)
+		}
+	}
+
+	return &ret, nil
+}
+
+type (
+	cachedLayout struct {
+		version string
+		layout  *SampleLayout
+	}
+
+	// layoutCache keeps the layouts of local sample files until the
+	// files change. Paths come from the catalogue so it is bounded by
+	// the number of samples.
+	layoutCache struct {
+		entries map[string]*cachedLayout
+		mu      sync.Mutex
+	}
+)
+
+func newLayoutCache() *layoutCache {
+	return &layoutCache{entries: make(map[string]*cachedLayout)}
+}
+
+// layout returns the cached layout of a file if its version has not
+// changed, otherwise it reads it again
+func (cache *layoutCache) layout(path string,
+	version func(path string) (string, error),
+	read func() (*SampleLayout, error)) (*SampleLayout, error) {
+
+	v, err := version(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	cache.mu.Lock()
+	entry, ok := cache.entries[path]
+	cache.mu.Unlock()
+
+	if ok && entry.version == v {
+		return entry.layout, nil
+	}
+
+	layout, err := read()
+
+	if err != nil {
+		return nil, err
+	}
+
+	cache.mu.Lock()
+	cache.entries[path] = &cachedLayout{version: v, layout: layout}
+	cache.mu.Unlock()
+
+	return layout, nil
+}
+
+func (sdb *SeqDB) sampleDBLayout(ctx context.Context, path string) (*SampleLayout, error) {
+	sampleDB, err := sdb.pool.Acquire(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer sdb.pool.Release(sampleDB)
+
+	binSizes, err := sampleDB.binSizes(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	layout := SampleLayout{BinSizes: binSizes,
+		Resolutions: binSizes,
+		Chromosomes: make(map[string]int, len(sampleDB.chrs))}
+
+	// already in preferred form
+	for chr := range sampleDB.chrs {
+		layout.Chromosomes[chr] = 0
+	}
+
+	return &layout, nil
+}
+
+func bigWigLayout(ctx context.Context, url string) (*SampleLayout, error) {
+	var bw *bigwig.BigWig
+	var err error
+
+	if bigwig.IsUrl(url) {
+		bw, err = defaultRemoteBigWigCache.BigWig(ctx, url)
+	} else {
+		bw, err = bigwig.OpenFile(url)
+
+		if err == nil {
+			defer bw.Close()
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	layout := SampleLayout{Resolutions: make([]int, 0, len(bw.ZoomHeaders())),
+		Chromosomes: make(map[string]int)}
+
+	// zoom levels are stored from finest to coarsest
+	for _, zoom := range bw.ZoomHeaders() {
+		layout.Resolutions = append(layout.Resolutions, int(zoom.ReductionLevel))
+	}
+
+	for _, chr := range bw.Chroms() {
+		addLayoutChr(&layout, chr.Name, int(chr.Size))
+	}
+
+	return &layout, nil
+}
+
+func bamLayout(path string) (*SampleLayout, error) {
+	reader, _, err := defaultBamIndexCache.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer reader.Close()
+
+	layout := SampleLayout{Chromosomes: make(map[string]int)}
+
+	for _, ref := range reader.Refs() {
+		addLayoutChr(&layout, ref.Name, ref.Len)
+	}
+
+	return &layout, nil
+}
+
+func addLayoutChr(layout *SampleLayout, name string, size int) {
+	layout.Chromosomes[ParseChr(name)] = size
+}
@@ -0,0 +1,110 @@
+package seqs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/antonybholmes/go-dna"
+)
+
+func TestSampleLayoutCheck(t *testing.T) {
+	layout := SampleLayout{BinSizes: []int{100, 1000},
+		Resolutions: []int{100, 1000},
+		Chromosomes: map[string]int{"chr1": 0}}
+
+	location, err := dna.NewLocation("chr1", 1, 10000)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 500 can be made from 100 base bins
+	for _, binSize := range []int{100, 500, 1000} {
+		err = layout.Check(location, binSize)
+
+		if err != nil {
+			t.Errorf("bin size %d: %s", binSize, err)
+		}
+	}
+
+	err = layout.Check(location, 50)
+
+	if SampleStatus(err) != SampleStatusUnsupportedBinSize {
+		t.Errorf("error %v has status %s", err, SampleStatus(err))
+	}
+
+	location, err = dna.NewLocation("chr2", 1, 10000)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = layout.Check(location, 100)
+
+	if SampleStatus(err) != SampleStatusNotFound {
+		t.Errorf("error %v has status %s", err, SampleStatus(err))
+	}
+}
+
+func TestLayoutCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sample.db")
+
+	err := os.WriteFile(path, []byte("v1"), 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache := newLayoutCache()
+
+	reads := 0
+
+	read := func() (*SampleLayout, error) {
+		reads++
+		return &SampleLayout{Chromosomes: map[string]int{}}, nil
+	}
+
+	for range 3 {
+		_, err = cache.layout(path, fileVersion, read)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if reads != 1 {
+		t.Errorf("layout read %d times, want 1", reads)
+	}
+
+	// a rebuilt file is read again
+	err = os.WriteFile(path, []byte("v2 longer"), 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Chtimes(path, time.Time{}, time.Now().Add(time.Minute))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = cache.layout(path, fileVersion, read)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reads != 2 {
+		t.Errorf("layout read %d times, want 2", reads)
+	}
+
+	_, err = cache.layout(filepath.Join(t.TempDir(), "missing.db"), fileVersion, read)
+
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("error %v, want %v", err, os.ErrNotExist)
+	}
+}
@@ -0,0 +1,289 @@
+package seqs
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+)
+
+const (
+	// optional table of other names for the assemblies of the catalogue
+	AssemblyAliasesSql = `SELECT a.name, aa.name
+		FROM assembly_aliases aa
+		JOIN assemblies a ON aa.assembly_id = a.id
+		ORDER BY a.name, aa.name`
+
+	AssemblyNamesSql = `SELECT name FROM assemblies ORDER BY name`
+
+	TableExistsSql = `SELECT name FROM sqlite_master WHERE type = 'table' AND name = :name`
+)
+
+type (
+	// Naming maps the different names of assemblies and chromosomes to
+	// one name each, so that for example GRCh37 finds hg19 samples and
+	// chr1 can be read from a bigwig that calls it 1.
+	//
+	// A Naming is not safe to change while it is being used, so it
+	// should be set up before the server starts.
+	Naming struct {
+		// lower case name or alias to assembly name
+		assemblies map[string]string
+		// chromosome key (see chrKey) to the key of its preferred name
+		chrs map[string]string
+	}
+
+	// NamingConfig is the file format of a naming registry. Each entry
+	// lists a preferred name followed by its aliases.
+	NamingConfig struct {
+		Assemblies  [][]string `json:"assemblies"`
+		Chromosomes [][]string `json:"chromosomes"`
+	}
+)
+
+var defaultNaming = DefaultNaming()
+
+func NewNaming() *Naming {
+	return &Naming{assemblies: make(map[string]string),
+		chrs: make(map[string]string)}
+}
+
+// DefaultNaming knows the UCSC and GRC names of common assemblies and
+// the Ensembl name of the mitochondrial chromosome.
+func DefaultNaming() *Naming {
+	naming := NewNaming()
+
+	naming.AddAssembly("hg19", "GRCh37")
+	naming.AddAssembly("hg38", "GRCh38")
+	naming.AddAssembly("mm10", "GRCm38")
+	naming.AddAssembly("mm39", "GRCm39")
+
+	naming.AddChr("chrM", "MT")
+
+	return naming
+}
+
+// SetNaming replaces the registry used by readers and queries. It should
+// be called before the server starts.
+func SetNaming(naming *Naming) {
+	defaultNaming = naming
+}
+
+func CurrentNaming() *Naming {
+	return defaultNaming
+}
+
+// ParseAssembly returns the preferred name of an assembly using the
+// current registry.
+func ParseAssembly(name string) string {
+	return defaultNaming.Assembly(name)
+}
+
+// ParseChr returns the preferred name of a chromosome using the current
+// registry.
+func ParseChr(name string) string {
+	return defaultNaming.Chr(name)
+}
+
+// LoadNamingFile reads a json NamingConfig on top of the defaults
+func LoadNamingFile(path string) (*Naming, error) {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var config NamingConfig
+
+	err = json.Unmarshal(data, &config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	naming := DefaultNaming()
+
+	for _, names := range config.Assemblies {
+		if len(names) > 0 {
+			naming.AddAssembly(names[0], names[1:]...)
+		}
+	}
+
+	for _, names := range config.Chromosomes {
+		if len(names) > 0 {
+			naming.AddChr(names[0], names[1:]...)
+		}
+	}
+
+	return naming, nil
+}
+
+// AddAssembly makes name the preferred name of itself and its aliases.
+// If any of them already belong to a group of names, the whole group
+// now uses name, so that adding the names actually used by a catalogue
+// re-points the built in aliases to them.
+func (naming *Naming) AddAssembly(name string, aliases ...string) {
+	addNames(naming.assemblies, strings.TrimSpace(name), aliases, func(s string) string {
+		return strings.ToLower(strings.TrimSpace(s))
+	})
+}
+
+// AddChr is the chromosome version of AddAssembly
+func (naming *Naming) AddChr(name string, aliases ...string) {
+	addNames(naming.chrs, chrKey(name), aliases, chrKey)
+}
+
+func addNames(names map[string]string, name string, aliases []string, key func(string) string) {
+	all := append([]string{name}, aliases...)
+
+	// groups being merged into this one
+	groups := make(map[string]struct{})
+
+	for _, alias := range all {
+		group, ok := names[key(alias)]
+
+		if ok {
+			groups[group] = struct{}{}
+		}
+	}
+
+	for alias, group := range names {
+		_, ok := groups[group]
+
+		if ok {
+			names[alias] = name
+		}
+	}
+
+	for _, alias := range all {
+		names[key(alias)] = name
+	}
+}
+
+// Assembly returns the preferred name of an assembly, or the name
+// itself if it is not known. Names are matched case insensitively.
+func (naming *Naming) Assembly(name string) string {
+	preferred, ok := naming.assemblies[strings.ToLower(strings.TrimSpace(name))]
+
+	if ok {
+		return preferred
+	}
+
+	return strings.TrimSpace(name)
+}
+
+// Chr returns the preferred name of a chromosome in the chr1, chrX,
+// chrM style of dna.ParseChr.
+func (naming *Naming) Chr(name string) string {
+	key := chrKey(name)
+
+	preferred, ok := naming.chrs[key]
+
+	if ok {
+		key = preferred
+	}
+
+	return "chr" + key
+}
+
+// SourceChrs maps preferred chromosome names to the names a sample file
+// uses for them
+func (naming *Naming) SourceChrs(names []string) map[string]string {
+	ret := make(map[string]string, len(names))
+
+	for _, name := range names {
+		chr := naming.Chr(name)
+
+		// keep the first if a file somehow has two names for
+		// the same chromosome
+		_, ok := ret[chr]
+
+		if !ok {
+			ret[chr] = name
+		}
+	}
+
+	return ret
+}
+
+// SourceChr finds the name a sample file uses for a chromosome, which
+// is the chromosome unchanged if the file does not have it, so that the
+// reader can report it missing.
+func (naming *Naming) SourceChr(chr string, names []string) string {
+	chr = naming.Chr(chr)
+
+	for _, name := range names {
+		if naming.Chr(name) == chr {
+			return name
+		}
+	}
+
+	return chr
+}
+
+// chrKey is a chromosome name without the chr prefix in upper case
+func chrKey(name string) string {
+	name = strings.ToUpper(strings.TrimSpace(name))
+
+	return strings.TrimPrefix(name, "CHR")
+}
+
+// loadNaming adds the assembly names and aliases of the catalogue to a
+// registry. Catalogues without an aliases table are fine.
+func (sdb *SeqDB) loadNaming(naming *Naming) error {
+	rows, err := sdb.db.Query(AssemblyNamesSql)
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+
+		err := rows.Scan(&name)
+
+		if err != nil {
+			return err
+		}
+
+		naming.AddAssembly(name)
+	}
+
+	var table string
+
+	err = sdb.db.QueryRow(TableExistsSql, sql.Named("name", "assembly_aliases")).Scan(&table)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+
+		return err
+	}
+
+	aliasRows, err := sdb.db.Query(AssemblyAliasesSql)
+
+	if err != nil {
+		return err
+	}
+
+	defer aliasRows.Close()
+
+	for aliasRows.Next() {
+		var name string
+		var alias string
+
+		err := aliasRows.Scan(&name, &alias)
+
+		if err != nil {
+			return err
+		}
+
+		naming.AddAssembly(name, alias)
+	}
+
+	return nil
+}
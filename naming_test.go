@@ -0,0 +1,148 @@
+package seqs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAddAssembly(t *testing.T) {
+	naming := DefaultNaming()
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"hg19", "hg19"},
+		{" GRCH37 ", "hg19"},
+		{"grch38", "hg38"},
+		// unknown names are kept
+		{"dm6", "dm6"},
+	}
+
+	for _, test := range tests {
+		if got := naming.Assembly(test.name); got != test.want {
+			t.Errorf("%q is %q, want %q", test.name, got, test.want)
+		}
+	}
+
+	// a catalogue calling hg38 GRCh38 re-points the group to its name
+	naming.AddAssembly("GRCh38")
+
+	for _, name := range []string{"hg38", "HG38", "GRCh38"} {
+		if got := naming.Assembly(name); got != "GRCh38" {
+			t.Errorf("%q is %q, want GRCh38", name, got)
+		}
+	}
+
+	// an alias shared with another group merges the groups
+	naming.AddAssembly("mouse", "mm10", "GRCm39")
+
+	for _, name := range []string{"mm10", "GRCm38", "mm39", "grcm39", "mouse"} {
+		if got := naming.Assembly(name); got != "mouse" {
+			t.Errorf("%q is %q, want mouse", name, got)
+		}
+	}
+
+	// other groups are untouched
+	if got := naming.Assembly("GRCh37"); got != "hg19" {
+		t.Errorf("GRCh37 is %q, want hg19", got)
+	}
+}
+
+func TestAddChr(t *testing.T) {
+	naming := DefaultNaming()
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"1", "chr1"},
+		{"chr1", "chr1"},
+		{"CHRX", "chrX"},
+		{"MT", "chrM"},
+		{"chrMT", "chrM"},
+		{" chrm ", "chrM"},
+	}
+
+	for _, test := range tests {
+		if got := naming.Chr(test.name); got != test.want {
+			t.Errorf("%q is %q, want %q", test.name, got, test.want)
+		}
+	}
+
+	// a registry preferring the Ensembl name re-points chrM too
+	naming.AddChr("MT", "chrM")
+
+	for _, name := range []string{"M", "chrM", "MT"} {
+		if got := naming.Chr(name); got != "chrMT" {
+			t.Errorf("%q is %q, want chrMT", name, got)
+		}
+	}
+
+	// scaffolds can be given friendlier names
+	naming.AddChr("chrEBV", "NC_007605")
+
+	if got := naming.Chr("nc_007605"); got != "chrEBV" {
+		t.Errorf("NC_007605 is %q, want chrEBV", got)
+	}
+}
+
+func TestSourceChr(t *testing.T) {
+	naming := DefaultNaming()
+
+	// a file with Ensembl names and two names for chr1
+	names := []string{"1", "chr1", "X", "MT"}
+
+	tests := []struct {
+		chr  string
+		want string
+	}{
+		{"chr1", "1"},
+		{"chrX", "X"},
+		{"chrM", "MT"},
+		// missing chromosomes are unchanged so readers report them
+		{"chr2", "chr2"},
+	}
+
+	for _, test := range tests {
+		if got := naming.SourceChr(test.chr, names); got != test.want {
+			t.Errorf("%s is %q in the file, want %q", test.chr, got, test.want)
+		}
+	}
+
+	chrs := naming.SourceChrs(names)
+
+	if len(chrs) != 3 || chrs["chr1"] != "1" || chrs["chrM"] != "MT" {
+		t.Errorf("unexpected chromosomes %v", chrs)
+	}
+}
+
+func TestLoadNamingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "naming.json")
+
+	err := os.WriteFile(path, []byte(`{"assemblies": [["GRCh37", "hg19"], []], "chromosomes": [["chrEBV", "EBV"]]}`), 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	naming, err := LoadNamingFile(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := naming.Assembly("hg19"); got != "GRCh37" {
+		t.Errorf("hg19 is %q, want GRCh37", got)
+	}
+
+	// defaults are kept
+	if got := naming.Assembly("GRCh38"); got != "hg38" {
+		t.Errorf("GRCh38 is %q, want hg38", got)
+	}
+
+	if got := naming.Chr("ebv"); got != "chrEBV" {
+		t.Errorf("ebv is %q, want chrEBV", got)
+	}
+}
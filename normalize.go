@@ -0,0 +1,86 @@
+package seqs
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// counts are returned as stored
+	NormRaw = "raw"
+	// bins per million, i.e. scaled by the total reads across all bins
+	NormBPM = "bpm"
+	// counts per million library reads
+	NormCPM = "cpm"
+	// reads per kilobase of bin per million library reads
+	NormRPKM = "rpkm"
+	// multiply by a user supplied scale factor
+	NormScale = "scale"
+)
+
+// ParseNorm checks a normalization mode. If no mode is given, a scale
+// other than 0 or 1 implies NormScale for clients that only send a scale.
+func ParseNorm(norm string, scale float64) (string, error) {
+	norm = strings.ToLower(strings.TrimSpace(norm))
+
+	switch norm {
+	case "":
+		if scale != 0 && scale != 1 {
+			return NormScale, nil
+		}
+
+		return NormRaw, nil
+	case NormRaw, NormBPM, NormCPM, NormRPKM, NormScale:
+		return norm, nil
+	default:
+		return "", fmt.Errorf("unknown normalization %s", norm)
+	}
+}
+
+// Normalize scales the counts and ymax in place. If the data needed for
+// a mode is not available, such as the library size of a bigwig, the
+// counts are left as they are. Norm records what was actually applied.
+func (counts *SampleBinCounts) Normalize(norm string, scale float64) {
+	factor := 1.0
+	applied := NormRaw
+
+	// coverage is a fraction so scaling it makes no sense
+	if counts.Stat == StatCoverage {
+		norm = NormRaw
+	}
+
+	switch norm {
+	case NormBPM:
+		if counts.BpmScaleFactor > 0 {
+			factor = counts.BpmScaleFactor
+			applied = NormBPM
+		}
+	case NormCPM:
+		if counts.Reads > 0 {
+			factor = 1000000 / float64(counts.Reads)
+			applied = NormCPM
+		}
+	case NormRPKM:
+		// each bin holds the reads in BinSize bases, even when
+		// consecutive bins have been merged
+		if counts.Reads > 0 && counts.BinSize > 0 {
+			factor = 1000000000 / (float64(counts.Reads) * float64(counts.BinSize))
+			applied = NormRPKM
+		}
+	case NormScale:
+		if scale > 0 {
+			factor = scale
+			applied = NormScale
+		}
+	}
+
+	if factor != 1 {
+		for _, bin := range counts.Bins {
+			bin.Count *= factor
+		}
+
+		counts.YMax *= factor
+	}
+
+	counts.Norm = applied
+}
@@ -0,0 +1,94 @@
+package seqs
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		norm    string
+		scale   float64
+		stat    string
+		want    float64
+		applied string
+	}{
+		{NormRaw, 1, StatMean, 10, NormRaw},
+		// bpm scale factor of 0.5
+		{NormBPM, 1, StatMean, 5, NormBPM},
+		// 2 million reads
+		{NormCPM, 1, StatMean, 5, NormCPM},
+		// 500 base bins
+		{NormRPKM, 1, StatMean, 10, NormRPKM},
+		{NormScale, 3, StatMean, 30, NormScale},
+		{NormScale, 0, StatMean, 10, NormRaw},
+		// fractions are not scaled
+		{NormCPM, 1, StatCoverage, 10, NormRaw},
+	}
+
+	for _, test := range tests {
+		counts := &SampleBinCounts{Bins: []*ReadBin{{Start: 1, End: 500, Count: 10}},
+			YMax:           10,
+			BinSize:        500,
+			Reads:          2000000,
+			BpmScaleFactor: 0.5,
+			Stat:           test.stat}
+
+		counts.Normalize(test.norm, test.scale)
+
+		if counts.Bins[0].Count != test.want || counts.YMax != test.want || counts.Norm != test.applied {
+			t.Errorf("%s %f: count %f ymax %f norm %s, want %f %s", test.norm, test.scale,
+				counts.Bins[0].Count, counts.YMax, counts.Norm, test.want, test.applied)
+		}
+	}
+
+	// without the library size there is nothing to scale by
+	counts := &SampleBinCounts{Bins: []*ReadBin{{Start: 1, End: 500, Count: 10}}, BinSize: 500}
+
+	counts.Normalize(NormRPKM, 1)
+
+	if counts.Bins[0].Count != 10 || counts.Norm != NormRaw {
+		t.Errorf("count %f norm %s, want 10 %s", counts.Bins[0].Count, counts.Norm, NormRaw)
+	}
+}
+
+func TestNormalizeResampled(t *testing.T) {
+	dir := t.TempDir()
+
+	stored := filepath.Join(dir, "stored.db")
+	resampled := filepath.Join(dir, "resampled.db")
+
+	writeBinsDB(t, stored, map[int][]*ReadBin{100: sourceBins, 500: storedBins[500]})
+	writeBinsDB(t, resampled, map[int][]*ReadBin{100: sourceBins})
+
+	pool := NewSampleDBPool(4)
+	defer pool.Close()
+
+	for _, norm := range []string{NormRPKM, NormCPM, NormBPM} {
+		want := readBins(t, pool, stored, 500, StatMean)
+		got := readBins(t, pool, resampled, 500, StatMean)
+
+		want.Normalize(norm, 1)
+		got.Normalize(norm, 1)
+
+		if got.Norm != norm {
+			t.Errorf("%s applied as %s", norm, got.Norm)
+		}
+
+		for i, bin := range got.Bins {
+			if math.Abs(bin.Count-want.Bins[i].Count) > 1e-9 {
+				t.Errorf("%s bin %d is %f, want %f", norm, i, bin.Count, want.Bins[i].Count)
+			}
+		}
+	}
+
+	// 13 reads in 500 bases of a library of a million reads
+	counts := readBins(t, pool, resampled, 500, StatMean)
+
+	counts.Normalize(NormRPKM, 1)
+
+	if counts.Bins[0].Count != 26 {
+		t.Errorf("rpkm %f, want 26", counts.Bins[0].Count)
+	}
+}
@@ -0,0 +1,179 @@
+package seqs
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	DefaultSearchLimit = 100
+	MaxSearchLimit     = 1000
+
+	// the order of the search, which is by relevance when there is a
+	// full text index and otherwise by technology, institution, dataset
+	// and name
+	SortDefault     = ""
+	SortName        = "name"
+	SortDataset     = "dataset"
+	SortTechnology  = "technology"
+	SortInstitution = "institution"
+	SortType        = "type"
+	SortReads       = "reads"
+
+	SortAsc  = "asc"
+	SortDesc = "desc"
+
+	countSearchSql = `SELECT COUNT(*) FROM (<<SEARCH>>)`
+
+	// sorting by a field re-orders the search, with names and ids
+	// breaking ties so that pages do not overlap
+	sortedSearchSql = `SELECT * FROM (<<SEARCH>>)
+		ORDER BY <<SORT>>, dataset_name, sample_name, public_id
+		LIMIT :limit OFFSET :offset`
+
+	pagedSearchSql = `<<SEARCH>>
+		LIMIT :limit OFFSET :offset`
+)
+
+var ErrInvalidSearchPage = errors.New("invalid search page")
+
+// columns of SelectSampleSql each sort uses
+var sortColumns = map[string]string{
+	SortName:        "sample_name",
+	SortDataset:     "dataset_name",
+	SortTechnology:  "technology",
+	SortInstitution: "institution",
+	SortType:        "sample_type",
+	SortReads:       "reads",
+}
+
+type (
+	// SearchPage is which part of a search to return and in what order
+	SearchPage struct {
+		Sort   string
+		Order  string
+		Offset int
+		Limit  int
+	}
+
+	SearchResults struct {
+		Samples []*Sample `json:"samples"`
+		// number of samples matching the search across all pages
+		Total  int    `json:"total"`
+		Offset int    `json:"offset"`
+		Limit  int    `json:"limit"`
+		Sort   string `json:"sort,omitempty"`
+		Order  string `json:"order,omitempty"`
+	}
+)
+
+// NewSearchPage checks the page of a search. A limit of 0 is the
+// default limit and larger limits are capped at MaxSearchLimit.
+func NewSearchPage(sort string, order string, offset int, limit int) (*SearchPage, error) {
+	sort = strings.ToLower(strings.TrimSpace(sort))
+	order = strings.ToLower(strings.TrimSpace(order))
+
+	if sort != SortDefault {
+		_, ok := sortColumns[sort]
+
+		if !ok {
+			return nil, fmt.Errorf("%w: cannot sort by %s", ErrInvalidSearchPage, sort)
+		}
+	}
+
+	switch order {
+	case "":
+		order = SortAsc
+	case SortAsc, SortDesc:
+	default:
+		return nil, fmt.Errorf("%w: unknown order %s", ErrInvalidSearchPage, order)
+	}
+
+	// the default order has no direction
+	if sort == SortDefault {
+		order = ""
+	}
+
+	if offset < 0 {
+		return nil, fmt.Errorf("%w: negative offset %d", ErrInvalidSearchPage, offset)
+	}
+
+	if limit < 0 {
+		return nil, fmt.Errorf("%w: negative limit %d", ErrInvalidSearchPage, limit)
+	}
+
+	if limit == 0 {
+		limit = DefaultSearchLimit
+	}
+
+	return &SearchPage{Sort: sort, Order: order, Offset: offset, Limit: min(limit, MaxSearchLimit)}, nil
+}
+
+// PagedSearch returns one page of a search with the number of samples on
+// all pages. It takes the same arguments as Search. A nil page is the
+// first page in the default order.
+func (sdb *SeqDB) PagedSearch(query string, assembly string, filter *Filter, page *SearchPage, isAdmin bool, permissions []string) (*SearchResults, error) {
+	if page == nil {
+		page = &SearchPage{Limit: DefaultSearchLimit}
+	}
+
+	searchQuery, namedArgs := sdb.searchSql(query, assembly, filter, isAdmin, permissions)
+
+	ret := SearchResults{Samples: make([]*Sample, 0, min(page.Limit, 100)),
+		Offset: page.Offset,
+		Limit:  page.Limit,
+		Sort:   page.Sort,
+		Order:  page.Order}
+
+	err := sdb.db.QueryRow(strings.Replace(countSearchSql, "<<SEARCH>>", searchQuery, 1), namedArgs...).Scan(&ret.Total)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var pageQuery string
+
+	if page.Sort == SortDefault {
+		pageQuery = strings.Replace(pagedSearchSql, "<<SEARCH>>", searchQuery, 1)
+	} else {
+		// only known columns and directions reach the sql
+		column, ok := sortColumns[page.Sort]
+
+		if !ok {
+			return nil, fmt.Errorf("%w: cannot sort by %s", ErrInvalidSearchPage, page.Sort)
+		}
+
+		order := "ASC"
+
+		if page.Order == SortDesc {
+			order = "DESC"
+		}
+
+		pageQuery = strings.Replace(sortedSearchSql, "<<SEARCH>>", searchQuery, 1)
+		pageQuery = strings.Replace(pageQuery, "<<SORT>>", column+" "+order, 1)
+	}
+
+	namedArgs = append(namedArgs, sql.Named("limit", page.Limit), sql.Named("offset", page.Offset))
+
+	rows, err := sdb.db.Query(pageQuery, namedArgs...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		sample, err := rowsToSample(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		ret.Samples = append(ret.Samples, sample)
+	}
+
+	return &ret, rows.Err()
+}
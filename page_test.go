@@ -0,0 +1,146 @@
+package seqs
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestNewSearchPage(t *testing.T) {
+	tests := []struct {
+		sort   string
+		order  string
+		offset int
+		limit  int
+		want   SearchPage
+	}{
+		{"", "", 0, 0, SearchPage{Limit: DefaultSearchLimit}},
+		// the default order has no direction
+		{"", "desc", 10, 5, SearchPage{Offset: 10, Limit: 5}},
+		{" Reads ", "", 0, 0, SearchPage{Sort: SortReads, Order: SortAsc, Limit: DefaultSearchLimit}},
+		{"name", "DESC", 0, MaxSearchLimit + 1, SearchPage{Sort: SortName, Order: SortDesc, Limit: MaxSearchLimit}},
+	}
+
+	for _, test := range tests {
+		page, err := NewSearchPage(test.sort, test.order, test.offset, test.limit)
+
+		if err != nil {
+			t.Errorf("%+v: %s", test, err)
+			continue
+		}
+
+		if *page != test.want {
+			t.Errorf("page %+v, want %+v", *page, test.want)
+		}
+	}
+
+	// only the sort fields and directions that are listed are allowed
+	// since they become part of the sql
+	for _, bad := range []struct {
+		sort   string
+		order  string
+		offset int
+		limit  int
+	}{
+		{"s.name", "", 0, 0},
+		{"reads; DROP TABLE samples", "", 0, 0},
+		{"public_id", "", 0, 0},
+		{"name", "sideways", 0, 0},
+		{"name", "asc, s.id", 0, 0},
+		{"", "", -1, 0},
+		{"", "", 0, -1},
+	} {
+		_, err := NewSearchPage(bad.sort, bad.order, bad.offset, bad.limit)
+
+		if !errors.Is(err, ErrInvalidSearchPage) {
+			t.Errorf("%+v: error %v, want %v", bad, err, ErrInvalidSearchPage)
+		}
+	}
+}
+
+func TestPagedSearch(t *testing.T) {
+	sdb := openFacetsCatalogue(t)
+
+	tests := []struct {
+		sort   string
+		order  string
+		offset int
+		limit  int
+		want   []string
+	}{
+		// by technology, institution, dataset and name
+		{"", "", 0, 0, []string{"s1", "s2", "s3", "s4", "s5"}},
+		{"", "", 1, 2, []string{"s2", "s3"}},
+		{"", "", 5, 2, []string{}},
+		{"name", "", 0, 0, []string{"s4", "s5", "s1", "s2", "s3"}},
+		// ties are broken by dataset and name
+		{"reads", "desc", 0, 0, []string{"s4", "s2", "s3", "s5", "s1"}},
+		{"reads", "asc", 2, 2, []string{"s2", "s3"}},
+		{"dataset", "desc", 0, 1, []string{"s1"}},
+	}
+
+	for _, test := range tests {
+		page, err := NewSearchPage(test.sort, test.order, test.offset, test.limit)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		results, err := sdb.PagedSearch("", "hg19", nil, page, true, nil)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ids := make([]string, 0, len(results.Samples))
+
+		for _, sample := range results.Samples {
+			ids = append(ids, sample.Id)
+		}
+
+		if !slices.Equal(ids, test.want) {
+			t.Errorf("%+v found %v, want %v", *page, ids, test.want)
+		}
+
+		// the total counts every page
+		if results.Total != 5 || results.Offset != page.Offset || results.Limit != page.Limit ||
+			results.Sort != page.Sort || results.Order != page.Order {
+			t.Errorf("%+v results %+v", *page, *results)
+		}
+	}
+
+	// totals follow the filter and permissions
+	filter, err := ParseFilter("technology=RNA-seq OR antibody=BCL6")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := sdb.PagedSearch("", "hg19", filter, &SearchPage{Limit: 1}, false, []string{"rdf:view"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if results.Total != 1 || len(results.Samples) != 1 || results.Samples[0].Id != "s3" {
+		t.Errorf("unexpected results %+v", *results)
+	}
+
+	// a nil page is the first default page
+	results, err = sdb.PagedSearch("", "hg19", nil, nil, true, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if results.Total != 5 || results.Limit != DefaultSearchLimit || len(results.Samples) != 5 {
+		t.Errorf("unexpected results %+v", *results)
+	}
+
+	// pages not made by NewSearchPage are checked too
+	_, err = sdb.PagedSearch("", "hg19", nil, &SearchPage{Sort: "s.id", Limit: 1}, true, nil)
+
+	if !errors.Is(err, ErrInvalidSearchPage) {
+		t.Errorf("error %v, want %v", err, ErrInvalidSearchPage)
+	}
+}
@@ -0,0 +1,276 @@
+package seqs
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/antonybholmes/go-dna"
+	"github.com/antonybholmes/go-seqs/bigwig"
+	"github.com/antonybholmes/go-sys/log"
+	basemath "github.com/antonybholmes/go-sys/math"
+)
+
+const (
+	RemoteBigWigTimeout = 30 * time.Second
+
+	DefaultRemoteBigWigCacheSize = 64
+	// how long a remote bigwig is used before checking it has not
+	// been replaced
+	DefaultRemoteBigWigTTL = 5 * time.Minute
+)
+
+type (
+	RemoteBigWigSeqReader struct {
+		sample  *Sample
+		url     string
+		binSize int
+		stat    string
+		cache   *RemoteBigWigCache
+	}
+
+	remoteBigWig struct {
+		url        string
+		bw         *bigwig.BigWig
+		reader     *bigwig.HttpReaderAt
+		checked    time.Time
+		lruElement *list.Element
+	}
+
+	// RemoteBigWigCache keeps the parsed header and index of a bounded
+	// number of remote bigwigs so that they are only fetched once per
+	// url, dropping the least recently used. Once an entry is older than
+	// the ttl, a one byte request checks that the file has the same ETag
+	// or Last-Modified before it is used again, and files without either
+	// are reopened.
+	RemoteBigWigCache struct {
+		client  *http.Client
+		maxSize int
+		ttl     time.Duration
+		entries map[string]*remoteBigWig
+		// front is most recently used
+		lru *list.List
+		mu  sync.Mutex
+	}
+)
+
+var defaultRemoteBigWigCache = NewRemoteBigWigCache(&http.Client{Timeout: RemoteBigWigTimeout})
+
+func NewRemoteBigWigCache(client *http.Client) *RemoteBigWigCache {
+	return NewRemoteBigWigCacheWithSize(client, DefaultRemoteBigWigCacheSize, DefaultRemoteBigWigTTL)
+}
+
+// NewRemoteBigWigCacheWithSize makes a cache of at most maxSize bigwigs
+// that are checked for changes every ttl. Values less than 1 use the
+// defaults.
+func NewRemoteBigWigCacheWithSize(client *http.Client, maxSize int, ttl time.Duration) *RemoteBigWigCache {
+	if maxSize < 1 {
+		maxSize = DefaultRemoteBigWigCacheSize
+	}
+
+	if ttl <= 0 {
+		ttl = DefaultRemoteBigWigTTL
+	}
+
+	return &RemoteBigWigCache{
+		client:  client,
+		maxSize: maxSize,
+		ttl:     ttl,
+		entries: make(map[string]*remoteBigWig),
+		lru:     list.New(),
+	}
+}
+
+// BigWig returns the cached bigwig for a url, opening it if necessary.
+func (cache *RemoteBigWigCache) BigWig(ctx context.Context, url string) (*bigwig.BigWig, error) {
+	cache.mu.Lock()
+	entry, ok := cache.entries[url]
+
+	if ok {
+		cache.lru.MoveToFront(entry.lruElement)
+	}
+
+	cache.mu.Unlock()
+
+	if ok {
+		if time.Since(entry.checked) < cache.ttl {
+			return entry.bw, nil
+		}
+
+		if cache.revalidate(ctx, entry) {
+			return entry.bw, nil
+		}
+
+		cache.remove(entry)
+	}
+
+	// open outside the lock so one slow server does not block others;
+	// if two requests race, the last one wins which is harmless
+	reader := bigwig.NewHttpReaderAt(url, cache.client)
+
+	bw, err := bigwig.OpenContext(ctx, reader)
+
+	if err != nil {
+		return nil, err
+	}
+
+	entry = &remoteBigWig{url: url, bw: bw, reader: reader, checked: time.Now()}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	old, ok := cache.entries[url]
+
+	if ok {
+		cache.lru.Remove(old.lruElement)
+	}
+
+	entry.lruElement = cache.lru.PushFront(entry)
+	cache.entries[url] = entry
+
+	for cache.lru.Len() > cache.maxSize {
+		back := cache.lru.Back().Value.(*remoteBigWig)
+		cache.lru.Remove(back.lruElement)
+		delete(cache.entries, back.url)
+	}
+
+	return bw, nil
+}
+
+// revalidate tests whether an entry is still the remote file, in which
+// case it can be used for another ttl
+func (cache *RemoteBigWigCache) revalidate(ctx context.Context, entry *remoteBigWig) bool {
+	// without validators there is no way to tell if the file changed
+	if entry.reader.Version() == "" {
+		return false
+	}
+
+	_, err := entry.reader.ReadAtContext(ctx, make([]byte, 1), 0)
+
+	if err != nil {
+		log.Debug().Msgf("remote bigwig %s needs reopening: %s", entry.url, err)
+		return false
+	}
+
+	cache.mu.Lock()
+	entry.checked = time.Now()
+	cache.mu.Unlock()
+
+	return true
+}
+
+// remove drops an entry unless it has already been replaced
+func (cache *RemoteBigWigCache) remove(entry *remoteBigWig) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	current, ok := cache.entries[entry.url]
+
+	if ok && current == entry {
+		cache.lru.Remove(entry.lruElement)
+		delete(cache.entries, entry.url)
+	}
+}
+
+// Remove drops a url from the cache, for example if the remote file
+// is known to have changed.
+func (cache *RemoteBigWigCache) Remove(url string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	entry, ok := cache.entries[url]
+
+	if ok {
+		cache.lru.Remove(entry.lruElement)
+		delete(cache.entries, url)
+	}
+}
+
+// Len is the number of bigwigs in the cache
+func (cache *RemoteBigWigCache) Len() int {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	return len(cache.entries)
+}
+
+func NewRemoteBigWigReader(sample *Sample, binSize int, stat string) (SeqReader, error) {
+	return NewRemoteBigWigReaderWithCache(sample, binSize, stat, defaultRemoteBigWigCache)
+}
+
+func NewRemoteBigWigReaderWithCache(sample *Sample, binSize int, stat string, cache *RemoteBigWigCache) (SeqReader, error) {
+	return &RemoteBigWigSeqReader{
+		sample:  sample,
+		url:     sample.Url,
+		binSize: binSize,
+		stat:    stat,
+		cache:   cache,
+	}, nil
+}
+
+func (reader *RemoteBigWigSeqReader) BinCounts(ctx context.Context, location *dna.Location) (*SampleBinCounts, error) {
+
+	log.Debug().Msgf("getting remote bigwig summary for location %s with bin size %d and url %s", location, reader.binSize, reader.url)
+
+	// we return something for every call, even if data not available
+	ret := SampleBinCounts{
+		Id:      reader.sample.Id,
+		Bins:    make([]*ReadBin, 0, reader.binSize),
+		YMax:    0,
+		BinSize: reader.binSize,
+		Reads:   reader.sample.Reads,
+		Norm:    NormRaw,
+		Stat:    reader.stat,
+		Status:  SampleStatusOk,
+	}
+
+	chrSize := reader.sample.ChrSize(location.Chr())
+
+	location, err := clampLocation(location, chrSize)
+
+	if err != nil {
+		return &ret, err
+	}
+
+	locBinSizeAligned, err := alignLocToBinSize(location, reader.binSize)
+
+	if err != nil {
+		return &ret, err
+	}
+
+	readBins, err := reader.summaryBins(ctx, locBinSizeAligned)
+
+	// the file was replaced since it was opened so try once more with
+	// its new header and index
+	if errors.Is(err, bigwig.ErrRemoteChanged) {
+		reader.cache.Remove(reader.url)
+		readBins, err = reader.summaryBins(ctx, locBinSizeAligned)
+	}
+
+	if err != nil {
+		log.Debug().Msgf("error reading remote bigwig summary %s %s", reader.url, err)
+		return &ret, err
+	}
+
+	ret.Bins = clipBins(readBins, chrSize)
+
+	for _, bin := range ret.Bins {
+		ret.YMax = basemath.Max(ret.YMax, bin.Count)
+	}
+
+	return &ret, nil
+}
+
+func (reader *RemoteBigWigSeqReader) summaryBins(ctx context.Context, location *dna.Location) ([]*ReadBin, error) {
+	bw, err := reader.cache.BigWig(ctx, reader.url)
+
+	if err != nil {
+		log.Debug().Msgf("error opening remote bigwig %s %s", reader.url, err)
+		return nil, err
+	}
+
+	return bigWigSummaryBins(ctx, bw, location, reader.binSize, reader.stat)
+}
@@ -0,0 +1,171 @@
+package seqs
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/antonybholmes/go-dna"
+)
+
+// bigWigServer serves the bigwig fixture at any path, counting the
+// requests for each range
+type bigWigServer struct {
+	*httptest.Server
+	etag   string
+	ranges map[string]int
+	mu     sync.Mutex
+}
+
+func newBigWigServer(t *testing.T) *bigWigServer {
+	t.Helper()
+
+	data, err := os.ReadFile("bigwig/testdata/fixture.bw")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &bigWigServer{etag: `"v1"`, ranges: make(map[string]int)}
+
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.mu.Lock()
+		server.ranges[r.URL.Path+" "+r.Header.Get("Range")]++
+		w.Header().Set("ETag", server.etag)
+		server.mu.Unlock()
+
+		http.ServeContent(w, r, "fixture.bw", time.Time{}, bytes.NewReader(data))
+	}))
+
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func (server *bigWigServer) count(path string, r string) int {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	return server.ranges[path+" "+r]
+}
+
+func remoteBinCounts(t *testing.T, cache *RemoteBigWigCache, url string) *SampleBinCounts {
+	t.Helper()
+
+	sample := &Sample{Id: url, Url: url, Type: SampleTypeRemoteBigWig}
+
+	reader, err := NewRemoteBigWigReaderWithCache(sample, 1000, StatMean, cache)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	location, err := dna.NewLocation("chr1", 1, 8000)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts, err := reader.BinCounts(context.Background(), location)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// same bins as the local file in TestBigWigBinCounts
+	if len(counts.Bins) != 8 || counts.YMax != 10 {
+		t.Errorf("unexpected bins %v", counts.Bins)
+	}
+
+	return counts
+}
+
+func TestRemoteBigWigCacheOpensOnce(t *testing.T) {
+	server := newBigWigServer(t)
+
+	cache := NewRemoteBigWigCache(server.Client())
+
+	for i := 0; i < 3; i++ {
+		remoteBinCounts(t, cache, server.URL+"/a.bw")
+	}
+
+	if n := server.count("/a.bw", "bytes=0-63"); n != 1 {
+		t.Errorf("header read %d times, want 1", n)
+	}
+}
+
+func TestRemoteBigWigCacheBounded(t *testing.T) {
+	server := newBigWigServer(t)
+
+	cache := NewRemoteBigWigCacheWithSize(server.Client(), 1, time.Hour)
+
+	remoteBinCounts(t, cache, server.URL+"/a.bw")
+	remoteBinCounts(t, cache, server.URL+"/b.bw")
+
+	if cache.Len() != 1 {
+		t.Errorf("cache has %d bigwigs, want 1", cache.Len())
+	}
+
+	// a.bw was dropped so it is opened again
+	remoteBinCounts(t, cache, server.URL+"/a.bw")
+
+	if n := server.count("/a.bw", "bytes=0-63"); n != 2 {
+		t.Errorf("header of a.bw read %d times, want 2", n)
+	}
+}
+
+func TestRemoteBigWigCacheRevalidates(t *testing.T) {
+	server := newBigWigServer(t)
+
+	cache := NewRemoteBigWigCacheWithSize(server.Client(), 10, time.Nanosecond)
+
+	remoteBinCounts(t, cache, server.URL+"/a.bw")
+
+	// still the same file so a one byte check is enough
+	remoteBinCounts(t, cache, server.URL+"/a.bw")
+
+	if n := server.count("/a.bw", "bytes=0-0"); n != 1 {
+		t.Errorf("%d revalidations, want 1", n)
+	}
+
+	if n := server.count("/a.bw", "bytes=0-63"); n != 1 {
+		t.Errorf("header read %d times, want 1", n)
+	}
+
+	// a replaced file is opened again
+	server.mu.Lock()
+	server.etag = `"v2"`
+	server.mu.Unlock()
+
+	remoteBinCounts(t, cache, server.URL+"/a.bw")
+
+	if n := server.count("/a.bw", "bytes=0-63"); n != 2 {
+		t.Errorf("header read %d times, want 2", n)
+	}
+}
+
+func TestRemoteBigWigChangedWhileCached(t *testing.T) {
+	server := newBigWigServer(t)
+
+	cache := NewRemoteBigWigCacheWithSize(server.Client(), 10, time.Hour)
+
+	remoteBinCounts(t, cache, server.URL+"/a.bw")
+
+	// the index nodes are cached but the data blocks are not, so the
+	// change is seen when they are read and the reader retries with the
+	// new file
+	server.mu.Lock()
+	server.etag = `"v2"`
+	server.mu.Unlock()
+
+	remoteBinCounts(t, cache, server.URL+"/a.bw")
+
+	if n := server.count("/a.bw", "bytes=0-63"); n != 2 {
+		t.Errorf("header read %d times, want 2", n)
+	}
+}
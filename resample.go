@@ -0,0 +1,87 @@
+package seqs
+
+import (
+	"github.com/antonybholmes/go-dna"
+)
+
+// resampleSource picks the stored bin size to build a requested bin size
+// from. Sizes that divide the requested size are preferred since each
+// requested bin is then made of whole stored bins; otherwise any smaller
+// size is used. Of those, the finest is chosen. It returns false if every
+// stored size is larger than the requested one.
+func resampleSource(stored []int, binSize int) (int, bool) {
+	source := 0
+	divides := false
+
+	for _, size := range stored {
+		if size < 1 || size > binSize {
+			continue
+		}
+
+		d := binSize%size == 0
+
+		switch {
+		case source == 0,
+			d && !divides,
+			d == divides && size < source:
+			source = size
+			divides = d
+		}
+	}
+
+	return source, source > 0
+}
+
+// resampleBins aggregates runs of stored bins of sourceSize, as read from
+// a sample db, into genome aligned bins of binSize covering the location
+// using a stat. The result is in the same merged run form as the stored
+// bins. chrSize, if known, shortens the final bin of the chromosome so
+// that its stat only counts the bases that exist.
+//
+// The mean of a stored bin is the reads in it, so the mean of a
+// resampled bin is too, rather than the mean of the stored bins it is
+// made of. This keeps counts, and so RPKM, on the same scale whichever
+// bin sizes the sample stores.
+func resampleBins(runs []*ReadBin, sourceSize int, location *dna.Location, binSize int, chrSize int, stat string) []*ReadBin {
+	if stat == StatMean {
+		stat = StatSum
+	}
+
+	startBin := (location.Start() - 1) / binSize
+	endBin := (location.End() - 1) / binSize
+
+	stats := make([]binStat, endBin-startBin+1)
+
+	for _, run := range runs {
+		// runs are 1-based inclusive
+		start0 := run.Start - 1
+		end := run.End
+
+		sb := max(start0/binSize, startBin)
+		eb := min((end-1)/binSize, endBin)
+
+		for b := sb; b <= eb; b++ {
+			// how many stored bins of this run fall in the requested
+			// bin, which can be fractional if the sizes do not divide
+			overlap := min(end, (b+1)*binSize) - max(start0, b*binSize)
+
+			stats[b-startBin].add(run.Count, float64(overlap)/float64(sourceSize))
+		}
+	}
+
+	values := make([]float64, len(stats))
+
+	for i := range stats {
+		b := startBin + i
+		width := binSize
+
+		if chrSize > 0 {
+			width = min((b+1)*binSize, chrSize) - b*binSize
+		}
+
+		// stored bins per requested bin
+		values[i] = stats[i].value(stat, float64(width)/float64(sourceSize))
+	}
+
+	return mergeBinCounts(values, startBin, binSize)
+}
@@ -0,0 +1,163 @@
+package seqs
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/antonybholmes/go-sys/db"
+)
+
+// sourceBins are 100 base bins of chr1 with a gap and a run of two
+var sourceBins = []*ReadBin{
+	{Start: 1, End: 100, Count: 2},
+	{Start: 101, End: 200, Count: 3},
+	{Start: 301, End: 500, Count: 4},
+	{Start: 501, End: 600, Count: 1},
+}
+
+// storedBins are sourceBins binned at other sizes as step1_bamtosql.py
+// would, by adding the reads in each bin
+var storedBins = map[int][]*ReadBin{
+	500:  {{Start: 1, End: 500, Count: 13}, {Start: 501, End: 1000, Count: 1}},
+	1000: {{Start: 1, End: 1000, Count: 14}},
+}
+
+// writeBinsDB writes a sample db of chr1 storing the bins of each size
+func writeBinsDB(t *testing.T, path string, sizes map[int][]*ReadBin) {
+	t.Helper()
+
+	conn, err := sql.Open(db.Sqlite3DB, path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	_, err = conn.Exec(`CREATE TABLE chromosomes (id INTEGER PRIMARY KEY, name TEXT NOT NULL);
+		CREATE TABLE bins (id INTEGER PRIMARY KEY, size INTEGER NOT NULL, reads INTEGER NOT NULL, bpm_scale_factor REAL NOT NULL);
+		CREATE TABLE reads (id INTEGER PRIMARY KEY, chr_id INTEGER NOT NULL, bin_id INTEGER NOT NULL, start INTEGER NOT NULL, end INTEGER NOT NULL, count INTEGER NOT NULL);
+		INSERT INTO chromosomes (id, name) VALUES (1, 'chr1');`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for size, bins := range sizes {
+		reads := 0
+
+		for _, bin := range bins {
+			reads += int(bin.Count) * (bin.End - bin.Start + 1) / size
+
+			_, err = conn.Exec(`INSERT INTO reads (chr_id, bin_id, start, end, count) VALUES (1, ?1, ?2, ?3, ?4)`,
+				size, bin.Start, bin.End, bin.Count)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		_, err = conn.Exec(`INSERT INTO bins (id, size, reads, bpm_scale_factor) VALUES (?1, ?1, ?2, 1000000.0 / ?2)`, size, reads)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readBins reads chr1:1-1000 of a sample db at a bin size
+func readBins(t *testing.T, pool *SampleDBPool, path string, binSize int, stat string) *SampleBinCounts {
+	t.Helper()
+
+	reader, err := NewDBSeqReader(&Sample{Id: "sample", Reads: 1000000}, path, binSize, stat, pool)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts, err := reader.BinCounts(context.Background(), binsLocation(t, "chr1", 1, 1000))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return counts
+}
+
+func sameBins(got []*ReadBin, want []*ReadBin) bool {
+	if len(got) != len(want) {
+		return false
+	}
+
+	for i, bin := range got {
+		if *bin != *want[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestResampleMatchesStored(t *testing.T) {
+	dir := t.TempDir()
+
+	stored := filepath.Join(dir, "stored.db")
+	resampled := filepath.Join(dir, "resampled.db")
+
+	writeBinsDB(t, stored, map[int][]*ReadBin{100: sourceBins, 500: storedBins[500], 1000: storedBins[1000]})
+	writeBinsDB(t, resampled, map[int][]*ReadBin{100: sourceBins})
+
+	pool := NewSampleDBPool(4)
+	defer pool.Close()
+
+	for _, binSize := range []int{500, 1000} {
+		for _, stat := range []string{StatMean, StatSum} {
+			want := readBins(t, pool, stored, binSize, stat)
+			got := readBins(t, pool, resampled, binSize, stat)
+
+			if !sameBins(want.Bins, storedBins[binSize]) {
+				t.Errorf("%d %s stored bins %v", binSize, stat, want.Bins)
+			}
+
+			if !sameBins(got.Bins, want.Bins) {
+				t.Errorf("%d %s resampled bins %v, want %v", binSize, stat, got.Bins, want.Bins)
+			}
+
+			if got.YMax != want.YMax || got.BinReads != want.BinReads {
+				t.Errorf("%d %s resampled %+v, want %+v", binSize, stat, *got, *want)
+			}
+		}
+	}
+
+	// stats of the stored bins within a bin are still per stored bin
+	counts := readBins(t, pool, resampled, 500, StatMax)
+
+	if !sameBins(counts.Bins, []*ReadBin{{Start: 1, End: 500, Count: 4}, {Start: 501, End: 1000, Count: 1}}) {
+		t.Errorf("max bins %v", counts.Bins)
+	}
+}
+
+func TestResampleSource(t *testing.T) {
+	tests := []struct {
+		stored  []int
+		binSize int
+		want    int
+		ok      bool
+	}{
+		{[]int{100, 1000}, 500, 100, true},
+		// dividing sizes beat finer ones
+		{[]int{30, 100}, 500, 100, true},
+		{[]int{30, 70}, 500, 30, true},
+		{[]int{1000}, 500, 0, false},
+	}
+
+	for _, test := range tests {
+		source, ok := resampleSource(test.stored, test.binSize)
+
+		if source != test.want || ok != test.ok {
+			t.Errorf("%v to %d gave %d %t, want %d %t", test.stored, test.binSize, source, ok, test.want, test.ok)
+		}
+	}
+}
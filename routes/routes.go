@@ -1,8 +1,12 @@
 package routes
 
 import (
+	"context"
 	"errors"
-	"strings"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
 
 	"github.com/antonybholmes/go-dna"
 	seq "github.com/antonybholmes/go-seqs"
@@ -14,31 +18,113 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+const (
+	DefaultMaxBinWorkers = 8
+)
+
 var (
 	ErrNoGenomeSupplied = errors.New("must supply a genome")
 )
 
+// maximum number of samples read at the same time per request
+var maxBinWorkers = DefaultMaxBinWorkers
+
 type (
 	ReqSeqParams struct {
 		Locations []string `json:"locations"`
 		Scale     float64  `json:"scale"`
-		BinSizes  []int    `json:"binSizes"`
-		Samples   []string `json:"samples"`
+		// one of raw, bpm, cpm, rpkm or scale
+		Norm string `json:"norm"`
+		// how each bin is summarized: mean (default), max, min, sum,
+		// coverage or std
+		Stat     string `json:"stat"`
+		BinSizes []int  `json:"binSizes"`
+		// instead of bin sizes, roughly how many bins to show each
+		// location with, usually the pixel width of the view. The
+		// server then picks a bin size for each sample.
+		TargetBins int `json:"targetBins"`
+		// sparse (default) or dense
+		Output string `json:"output"`
+		// zero (default) or null for empty bins in dense output
+		Fill    string   `json:"fill"`
+		Samples []string `json:"samples"`
 	}
 
 	SeqParams struct {
 		Locations []*dna.Location
 		Scale     float64
+		Norm      string
+		Stat      string
 		BinSizes  []int
-		Samples   []string
+		// used when there are no bin sizes
+		TargetBins int
+		Output     string
+		Fill       string
+		Samples    []string
+		// bin size of each sample at each location when picked
+		// from TargetBins
+		sampleBinSizes [][]int
+		// layout of each sample, nil if it could not be read or
+		// the request was not validated against the samples
+		layouts []*seq.SampleLayout
 	}
 
-	SeqResp struct {
-		Location *dna.Location          `json:"location"`
-		Samples  []*seq.SampleBinCounts `json:"samples"`
-	}
+	SeqResp = seq.LocationBinCounts
 )
 
+// SetMaxBinWorkers sets how many samples each bins request can read in
+// parallel. Values less than 1 reset it to the default. It should be
+// called before the server starts.
+func SetMaxBinWorkers(workers int) {
+	if workers < 1 {
+		workers = DefaultMaxBinWorkers
+	}
+
+	maxBinWorkers = workers
+}
+
+// BinSize is the bin size for a sample at a location
+func (params *SeqParams) BinSize(location int, sample int) int {
+	if params.sampleBinSizes != nil {
+		return params.sampleBinSizes[location][sample]
+	}
+
+	return params.BinSizes[location]
+}
+
+// layout is the layout of a sample if validateSamples read it
+func (params *SeqParams) layout(sample int) *seq.SampleLayout {
+	if params.layouts == nil {
+		return nil
+	}
+
+	return params.layouts[sample]
+}
+
+// resolveBinSizes picks a bin size for each sample at each location so
+// that there are about TargetBins bins, using resolutions the samples
+// can read efficiently. Layouts may be nil for samples that cannot be
+// read.
+func (params *SeqParams) resolveBinSizes(layouts []*seq.SampleLayout) {
+	params.sampleBinSizes = make([][]int, len(params.Locations))
+
+	for li, location := range params.Locations {
+		idealSize := max(1, (location.Len()+params.TargetBins-1)/params.TargetBins)
+
+		sizes := make([]int, len(layouts))
+
+		for si, layout := range layouts {
+			if layout != nil {
+				sizes[si] = layout.BestBinSize(idealSize)
+			} else {
+				sizes[si] = idealSize
+			}
+		}
+
+		params.sampleBinSizes[li] = sizes
+	}
+}
+
 func ParseSeqParamsFromPost(c *gin.Context) (*SeqParams, error) {
 
 	var params ReqSeqParams
@@ -52,7 +138,7 @@ func ParseSeqParamsFromPost(c *gin.Context) (*SeqParams, error) {
 	locations := make([]*dna.Location, 0, len(params.Locations))
 
 	for _, loc := range params.Locations {
-		location, err := dna.ParseLocation(loc)
+		location, err := parseLocation(loc)
 
 		if err != nil {
 			return nil, err
@@ -61,12 +147,48 @@ func ParseSeqParamsFromPost(c *gin.Context) (*SeqParams, error) {
 		locations = append(locations, location)
 	}
 
-	return &SeqParams{
-			Locations: locations,
-			BinSizes:  params.BinSizes,
-			Samples:   params.Samples,
-			Scale:     params.Scale},
-		nil
+	norm, err := seq.ParseNorm(params.Norm, params.Scale)
+
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := seq.ParseStat(params.Stat)
+
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := seq.ParseOutput(params.Output)
+
+	if err != nil {
+		return nil, err
+	}
+
+	fill, err := seq.ParseFill(params.Fill)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ret := SeqParams{
+		Locations:  locations,
+		BinSizes:   params.BinSizes,
+		TargetBins: params.TargetBins,
+		Samples:    params.Samples,
+		Scale:      params.Scale,
+		Norm:       norm,
+		Stat:       stat,
+		Output:     output,
+		Fill:       fill}
+
+	err = validateSeqParams(&ret)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &ret, nil
 }
 
 // func GenomesRoute(c *gin.Context) {
@@ -119,7 +241,133 @@ func ParseSeqParamsFromPost(c *gin.Context) (*SeqParams, error) {
 // 	})
 // }
 
+// parseSearchParams reads the assembly, query and filter shared by the
+// search routes
+func parseSearchParams(c *gin.Context) (string, string, *seq.Filter, error) {
+	assembly := c.Param("assembly")
+
+	if assembly == "" {
+		return "", "", nil, ErrNoGenomeSupplied
+	}
+
+	// tag and field conditions such as antibody=BCL6 AND technology=ChIP-seq
+	filter, err := seq.ParseFilter(c.Query("filter"))
+
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	return assembly, c.Query("q"), filter, nil
+}
+
+// parseSearchPage reads the offset, limit, sort and order of a search
+func parseSearchPage(c *gin.Context) (*seq.SearchPage, error) {
+	offset := 0
+	limit := 0
+
+	var err error
+
+	if s := c.Query("offset"); s != "" {
+		offset, err = strconv.Atoi(s)
+
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s is not a valid offset", seq.ErrInvalidSearchPage, s)
+		}
+	}
+
+	if s := c.Query("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s is not a valid limit", seq.ErrInvalidSearchPage, s)
+		}
+	}
+
+	return seq.NewSearchPage(c.Query("sort"), c.Query("order"), offset, limit)
+}
+
+// isPagedSearch is whether a search asks for a page of results. Clients
+// that predate paging get every matching sample as a plain list.
+func isPagedSearch(c *gin.Context) bool {
+	for _, param := range []string{"offset", "limit", "sort", "order"} {
+		if _, ok := c.GetQuery(param); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SearchSamplesRoute returns the samples matching a search. If any of
+// offset, limit, sort or order are given it returns a page of them with
+// the total number of matches instead.
 func SearchSamplesRoute(c *gin.Context) {
+	middleware.JwtUserWithPermissionsRoute(c, func(c *gin.Context, isAdmin bool, user *token.AuthUserJwtClaims) {
+		assembly, query, filter, err := parseSearchParams(c)
+
+		if err != nil {
+			web.BadReqResp(c, err)
+			return
+		}
+
+		// aliases such as GRCh37 are resolved by the search
+		log.Debug().Msgf("searching for %s in %s", query, assembly)
+
+		if !isPagedSearch(c) {
+			samples, err := seqdb.SearchSamples(query, assembly, filter, isAdmin, user.Permissions)
+
+			if err != nil {
+				c.Error(err)
+				return
+			}
+
+			web.MakeDataResp(c, "", samples)
+			return
+		}
+
+		page, err := parseSearchPage(c)
+
+		if err != nil {
+			web.BadReqResp(c, err)
+			return
+		}
+
+		results, err := seqdb.PagedSearchSamples(query, assembly, filter, page, isAdmin, user.Permissions)
+
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		web.MakeDataResp(c, "", results)
+	})
+}
+
+// SearchFacetsRoute counts the samples of a search by technology,
+// institution, dataset and tag value. It takes the same parameters as
+// SearchSamplesRoute.
+func SearchFacetsRoute(c *gin.Context) {
+	middleware.JwtUserWithPermissionsRoute(c, func(c *gin.Context, isAdmin bool, user *token.AuthUserJwtClaims) {
+		assembly, query, filter, err := parseSearchParams(c)
+
+		if err != nil {
+			web.BadReqResp(c, err)
+			return
+		}
+
+		facets, err := seqdb.SearchFacets(query, assembly, filter, isAdmin, user.Permissions)
+
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		web.MakeDataResp(c, "", facets)
+	})
+}
+
+// DatasetsRoute lists the datasets of an assembly the user can view
+func DatasetsRoute(c *gin.Context) {
 	middleware.JwtUserWithPermissionsRoute(c, func(c *gin.Context, isAdmin bool, user *token.AuthUserJwtClaims) {
 		assembly := c.Param("assembly")
 
@@ -128,25 +376,70 @@ func SearchSamplesRoute(c *gin.Context) {
 			return
 		}
 
-		// normalize assembly names to be more flexible
-		assembly = strings.ToLower(assembly)
+		datasets, err := seqdb.Datasets(assembly, isAdmin, user.Permissions)
 
-		if assembly == "grch37" {
-			assembly = "hg19"
+		if err != nil {
+			c.Error(err)
+			return
 		}
 
-		log.Debug().Msgf("searching for %s in %s", c.Query("q"), assembly)
+		web.MakeDataResp(c, "", datasets)
+	})
+}
+
+// DatasetRoute returns a dataset with its samples nested so clients can
+// show a dataset to sample tree
+func DatasetRoute(c *gin.Context) {
+	middleware.JwtUserWithPermissionsRoute(c, func(c *gin.Context, isAdmin bool, user *token.AuthUserJwtClaims) {
+		dataset, err := seqdb.Dataset(c.Param("dataset"), isAdmin, user.Permissions)
+
+		if err != nil {
+			accessErrorResp(c, err)
+			return
+		}
+
+		web.MakeDataResp(c, "", dataset)
+	})
+}
+
+// accessErrorResp reports missing or forbidden samples and datasets with
+// their http status
+func accessErrorResp(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, seq.ErrPermissionDenied):
+		web.ForbiddenResp(c, err)
+	case errors.Is(err, seq.ErrSampleNotFound),
+		errors.Is(err, seq.ErrDatasetNotFound):
+		web.ErrorResp(c, http.StatusNotFound, err)
+	default:
+		c.Error(err)
+	}
+}
 
-		query := c.Query("q")
+// ChromSizesRoute lists the chromosomes and their lengths for an
+// assembly, which clients need to know how far they can scroll
+func ChromSizesRoute(c *gin.Context) {
+	middleware.JwtUserWithPermissionsRoute(c, func(c *gin.Context, isAdmin bool, user *token.AuthUserJwtClaims) {
+		assembly := c.Param("assembly")
 
-		tracks, err := seqdb.SearchSamples(query, assembly, isAdmin, user.Permissions)
+		if assembly == "" {
+			web.BadReqResp(c, ErrNoGenomeSupplied)
+			return
+		}
+
+		chrs, err := seqdb.ChromSizes(assembly)
 
 		if err != nil {
-			c.Error(err)
+			if errors.Is(err, seq.ErrAssemblyNotFound) {
+				web.ErrorResp(c, http.StatusNotFound, err)
+			} else {
+				c.Error(err)
+			}
+
 			return
 		}
 
-		web.MakeDataResp(c, "", tracks)
+		web.MakeDataResp(c, "", chrs)
 	})
 }
 
@@ -156,53 +449,237 @@ func BinsRoute(c *gin.Context) {
 
 		if err != nil {
 			log.Debug().Msgf("err %s", err)
-			c.Error(err)
+			web.BadReqResp(c, err)
 			return
 		}
 
 		log.Debug().Msgf("bin %v %v %v", params.Locations, params.BinSizes, params.Samples)
 
-		ret := make([]*SeqResp, 0, len(params.Locations)) //make([]*seq.BinCounts, 0, len(params.Tracks))
+		// the request context is cancelled if the client disconnects
+		ctx := c.Request.Context()
 
-		for li, location := range params.Locations {
-			resp := SeqResp{Location: location, Samples: make([]*seq.SampleBinCounts, 0, len(params.Samples))}
+		err = validateSamples(ctx, params, isAdmin, user.Permissions)
 
-			for _, sample := range params.Samples {
-				err := seqdb.CanViewSample(sample, isAdmin, user.Permissions)
+		if err != nil {
+			if errors.Is(err, ErrInvalidBinsRequest) {
+				web.BadReqResp(c, err)
+			} else {
+				c.Error(err)
+			}
 
-				if err != nil {
-					log.Debug().Msgf("no permission for sample %s: %s", sample, err)
-					continue
-				}
+			return
+		}
+
+		// json unless the client asks for another format
+		format := c.NegotiateFormat(gin.MIMEJSON, seq.BinsBinaryContentType, NdjsonContentType)
+
+		if format == NdjsonContentType {
+			streamBins(ctx, c, params, isAdmin, user.Permissions)
+			return
+		}
+
+		ret, err := binCounts(ctx, params, isAdmin, user.Permissions)
+
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		//log.Debug().Msgf("ret %v", len(ret))
+
+		if format == seq.BinsBinaryContentType {
+			c.Status(http.StatusOK)
+			c.Header("Content-Type", seq.BinsBinaryContentType)
+
+			err = seq.EncodeBinsBinary(c.Writer, ret)
+
+			if err != nil {
+				c.Error(err)
+			}
+
+			return
+		}
+
+		web.MakeDataResp(c, "", ret)
+	})
+}
+
+type binJob struct {
+	location int
+	sample   int
+}
+
+// eachBinCounts reads every sample at every location using a bounded
+// number of workers and calls fn with each result as soon as it is
+// ready, so results arrive in no particular order. fn is only called
+// from the calling goroutine. Samples that cannot be read are still
+// passed to fn with a status explaining why. Reading stops if ctx is
+// cancelled or fn returns an error.
+func eachBinCounts(ctx context.Context,
+	params *SeqParams,
+	isAdmin bool,
+	permissions []string,
+	fn func(job binJob, counts *seq.SampleBinCounts) error) error {
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type binResult struct {
+		job    binJob
+		counts *seq.SampleBinCounts
+	}
+
+	jobs := make(chan binJob)
+	results := make(chan binResult)
 
-				reader, err := seqdb.ReaderFromId(sample, params.BinSizes[li])
+	var wg sync.WaitGroup
 
-				if err != nil {
-					log.Debug().Msgf("getting bins for %s %v", sample, err)
+	workers := min(maxBinWorkers, len(params.Locations)*len(params.Samples))
 
-					c.Error(err)
+	for range workers {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for job := range jobs {
+				counts := sampleBinCounts(ctx,
+					params.Locations[job.location],
+					params.Samples[job.sample],
+					params.layout(job.sample),
+					params.BinSize(job.location, job.sample),
+					params,
+					isAdmin,
+					permissions)
+
+				select {
+				case results <- binResult{job: job, counts: counts}:
+				case <-ctx.Done():
 					return
 				}
+			}
+		}()
+	}
 
-				log.Debug().Msgf("getting bins for %s %s", sample, location.String())
+	go func() {
+		defer close(jobs)
 
-				// guarantees something is returned even with error
-				// so we can ignore the errors for now to make the api
-				// more robus
-				sampleBinCounts, _ := reader.BinCounts(location)
+		for li := range params.Locations {
+			for si := range params.Samples {
+				select {
+				case jobs <- binJob{location: li, sample: si}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
 
-				// if err != nil {
-				// 	return web.ErrorReq(err)
-				// }
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
 
-				resp.Samples = append(resp.Samples, sampleBinCounts)
-			}
+	var err error
+
+	for result := range results {
+		if err != nil {
+			// drain so the workers can finish
+			continue
+		}
 
-			ret = append(ret, &resp)
+		err = fn(result.job, result.counts)
+
+		if err != nil {
+			cancel()
 		}
+	}
 
-		//log.Debug().Msgf("ret %v", len(ret))
+	if err != nil {
+		return err
+	}
 
-		web.MakeDataResp(c, "", ret)
+	// client has gone away so there is no one to respond to
+	return ctx.Err()
+}
+
+// binCounts reads every sample at every location. Results are placed by
+// index so the response keeps the order of the request regardless of
+// which reads finish first.
+func binCounts(ctx context.Context, params *SeqParams, isAdmin bool, permissions []string) ([]*SeqResp, error) {
+	results := make([][]*seq.SampleBinCounts, len(params.Locations))
+
+	for li := range params.Locations {
+		results[li] = make([]*seq.SampleBinCounts, len(params.Samples))
+	}
+
+	err := eachBinCounts(ctx, params, isAdmin, permissions, func(job binJob, counts *seq.SampleBinCounts) error {
+		results[job.location][job.sample] = counts
+		return nil
 	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]*SeqResp, 0, len(params.Locations))
+
+	for li, location := range params.Locations {
+		ret = append(ret, &SeqResp{Location: location, Samples: results[li]})
+	}
+
+	return ret, nil
+}
+
+// sampleBinCounts reads one sample, always returning something so that
+// one bad sample does not fail the whole request. If the layout of the
+// sample is known, the location and bin size are checked against it
+// first.
+func sampleBinCounts(ctx context.Context,
+	location *dna.Location,
+	sample string,
+	layout *seq.SampleLayout,
+	binSize int,
+	params *SeqParams,
+	isAdmin bool,
+	permissions []string) *seq.SampleBinCounts {
+
+	err := seqdb.CanViewSample(sample, isAdmin, permissions)
+
+	if err != nil {
+		log.Debug().Msgf("no permission for sample %s: %s", sample, err)
+		return seq.NewSampleBinCountsError(sample, binSize, err)
+	}
+
+	if layout != nil {
+		err = layout.Check(location, binSize)
+
+		if err != nil {
+			log.Debug().Msgf("cannot read sample %s at %s: %s", sample, location, err)
+			return seq.NewSampleBinCountsError(sample, binSize, err)
+		}
+	}
+
+	reader, err := seqdb.ReaderFromId(sample, binSize, params.Stat)
+
+	if err != nil {
+		log.Debug().Msgf("getting bins for %s %v", sample, err)
+		return seq.NewSampleBinCountsError(sample, binSize, err)
+	}
+
+	log.Debug().Msgf("getting bins for %s %s", sample, location.String())
+
+	// readers return what they could read even if there is an error
+	sampleBinCounts, err := seqdb.BinCounts(ctx, reader, location, params.Norm, params.Scale)
+
+	if err != nil {
+		log.Debug().Msgf("error reading bins for %s %s", sample, err)
+		sampleBinCounts.SetError(err)
+	}
+
+	if params.Output == seq.OutputDense {
+		sampleBinCounts.Densify(location, params.Fill)
+	}
+
+	return sampleBinCounts
 }
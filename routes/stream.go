@@ -0,0 +1,102 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/antonybholmes/go-dna"
+	seq "github.com/antonybholmes/go-seqs"
+	"github.com/antonybholmes/go-sys/log"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	NdjsonContentType = "application/x-ndjson"
+
+	StreamLineSample  = "sample"
+	StreamLineSummary = "summary"
+)
+
+type (
+	// BinsStreamLine is one line of a streamed bins response. Sample
+	// lines arrive in the order they are read, so the indices say where
+	// each belongs in the request.
+	BinsStreamLine struct {
+		Type          string               `json:"type"`
+		Location      *dna.Location        `json:"location"`
+		LocationIndex int                  `json:"locationIndex"`
+		SampleIndex   int                  `json:"sampleIndex"`
+		Sample        *seq.SampleBinCounts `json:"sample"`
+	}
+
+	// BinsStreamSummary is the last line of a streamed bins response.
+	// Clients that do not see it should assume the stream was cut short.
+	BinsStreamSummary struct {
+		Type      string         `json:"type"`
+		Locations int            `json:"locations"`
+		Samples   int            `json:"samples"`
+		Results   int            `json:"results"`
+		Statuses  map[string]int `json:"statuses"`
+		ElapsedMs int64          `json:"elapsedMs"`
+		Error     string         `json:"error,omitempty"`
+	}
+)
+
+// streamBins writes one json line per location and sample as soon as it
+// is read, followed by a summary line, so that clients can draw tracks
+// as they arrive.
+func streamBins(ctx context.Context, c *gin.Context, params *SeqParams, isAdmin bool, permissions []string) {
+	start := time.Now()
+
+	c.Header("Content-Type", NdjsonContentType)
+	// stop proxies such as nginx holding back lines
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	enc := json.NewEncoder(c.Writer)
+
+	summary := BinsStreamSummary{
+		Type:      StreamLineSummary,
+		Locations: len(params.Locations),
+		Samples:   len(params.Samples),
+		Statuses:  make(map[string]int),
+	}
+
+	err := eachBinCounts(ctx, params, isAdmin, permissions, func(job binJob, counts *seq.SampleBinCounts) error {
+		err := enc.Encode(BinsStreamLine{
+			Type:          StreamLineSample,
+			Location:      params.Locations[job.location],
+			LocationIndex: job.location,
+			SampleIndex:   job.sample,
+			Sample:        counts,
+		})
+
+		if err != nil {
+			return err
+		}
+
+		c.Writer.Flush()
+
+		summary.Results++
+		summary.Statuses[counts.Status]++
+
+		return nil
+	})
+
+	if err != nil {
+		log.Debug().Msgf("bins stream stopped: %s", err)
+
+		summary.Error = err.Error()
+	}
+
+	summary.ElapsedMs = time.Since(start).Milliseconds()
+
+	// this fails harmlessly if the client has gone
+	err = enc.Encode(summary)
+
+	if err == nil {
+		c.Writer.Flush()
+	}
+}
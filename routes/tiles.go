@@ -0,0 +1,242 @@
+package routes
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/antonybholmes/go-dna"
+	seq "github.com/antonybholmes/go-seqs"
+	"github.com/antonybholmes/go-seqs/seqdb"
+	"github.com/antonybholmes/go-sys/log"
+	"github.com/antonybholmes/go-web"
+	"github.com/antonybholmes/go-web/auth/token"
+	"github.com/antonybholmes/go-web/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// bins per tile
+	TileBins = 1024
+
+	// tiles are behind auth so by default only the browser may keep
+	// them. Deployments whose CDN keys on the Authorization header can
+	// make them public.
+	DefaultTileCacheControl = "private, max-age=604800"
+
+	// longer than any chromosome, so tiles past it are rejected
+	// before their coordinates can overflow
+	maxTileEnd = 1 << 31
+)
+
+var tileCacheControl = DefaultTileCacheControl
+
+// SetTileCacheControl sets the Cache-Control header of tiles. An empty
+// value resets it to the default. It should be called before the server
+// starts.
+func SetTileCacheControl(cacheControl string) {
+	if cacheControl == "" {
+		cacheControl = DefaultTileCacheControl
+	}
+
+	tileCacheControl = cacheControl
+}
+
+// TileLocation is the location covered by a tile. Tiles are TileBins
+// bins of zoom bp each, with tile 0 starting at base 1, so every request
+// for the same tile asks for exactly the same bins and can be cached.
+func TileLocation(chr string, zoom int, tile int) (*dna.Location, error) {
+	width := zoom * TileBins
+
+	return dna.NewLocation(seq.ParseChr(chr), tile*width+1, (tile+1)*width)
+}
+
+func parseTileParams(c *gin.Context) (*SeqParams, error) {
+	zoom, err := strconv.Atoi(c.Param("zoom"))
+
+	if err != nil || zoom < 1 || zoom > maxTileEnd/TileBins {
+		return nil, invalidf("%s is not a valid zoom", c.Param("zoom"))
+	}
+
+	tile, err := strconv.Atoi(c.Param("tile"))
+
+	if err != nil || tile < 0 {
+		return nil, invalidf("%s is not a valid tile", c.Param("tile"))
+	}
+
+	if tile >= maxTileEnd/(zoom*TileBins) {
+		return nil, invalidf("tile %d is beyond the end of any chromosome", tile)
+	}
+
+	location, err := TileLocation(c.Param("chr"), zoom, tile)
+
+	if err != nil {
+		return nil, invalidf("%s", err)
+	}
+
+	scale := 0.0
+
+	if s := c.Query("scale"); s != "" {
+		scale, err = strconv.ParseFloat(s, 64)
+
+		if err != nil {
+			return nil, invalidf("%s is not a valid scale", s)
+		}
+	}
+
+	norm, err := seq.ParseNorm(c.Query("norm"), scale)
+
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := seq.ParseStat(c.Query("stat"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := seq.ParseOutput(c.Query("output"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	fill, err := seq.ParseFill(c.Query("fill"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	ret := SeqParams{
+		Locations: []*dna.Location{location},
+		BinSizes:  []int{zoom},
+		Samples:   []string{c.Param("sample")},
+		Scale:     scale,
+		Norm:      norm,
+		Stat:      stat,
+		Output:    output,
+		Fill:      fill}
+
+	err = validateSeqParams(&ret)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &ret, nil
+}
+
+// TileRoute serves one tile of a sample at
+// /:sample/:chr/:zoom/:tile, where zoom is the bin size. The norm,
+// scale, stat, output and fill query parameters work as they do for
+// bins. Responses have a strong ETag so that clients and caches can
+// revalidate them cheaply.
+func TileRoute(c *gin.Context) {
+	middleware.JwtUserWithPermissionsRoute(c, func(c *gin.Context, isAdmin bool, user *token.AuthUserJwtClaims) {
+		params, err := parseTileParams(c)
+
+		if err != nil {
+			web.BadReqResp(c, err)
+			return
+		}
+
+		sample := params.Samples[0]
+
+		err = seqdb.CanViewSample(sample, isAdmin, user.Permissions)
+
+		if err != nil {
+			accessErrorResp(c, err)
+			return
+		}
+
+		ctx := c.Request.Context()
+
+		err = validateSamples(ctx, params, isAdmin, user.Permissions)
+
+		if err != nil {
+			if errors.Is(err, ErrInvalidBinsRequest) {
+				web.BadReqResp(c, err)
+			} else {
+				c.Error(err)
+			}
+
+			return
+		}
+
+		location := params.Locations[0]
+
+		counts := sampleBinCounts(ctx, location, sample, params.layout(0), params.BinSizes[0], params, isAdmin, user.Permissions)
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		ret := SeqResp{Location: location, Samples: []*seq.SampleBinCounts{counts}}
+
+		format := c.NegotiateFormat(gin.MIMEJSON, seq.BinsBinaryContentType)
+
+		var buf bytes.Buffer
+
+		if format == seq.BinsBinaryContentType {
+			err = seq.EncodeBinsBinary(&buf, []*SeqResp{&ret})
+		} else {
+			format = gin.MIMEJSON
+
+			err = json.NewEncoder(&buf).Encode(web.DataResp{
+				StatusMessageResp: web.StatusMessageResp{Status: http.StatusOK},
+				Data:              ret})
+		}
+
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		writeTile(c, format, buf.Bytes(), counts.Status == seq.SampleStatusOk)
+	})
+}
+
+// writeTile sends a tile, or 304 if the client already has it. The ETag
+// is a hash of the body so it changes whenever the data does. Tiles
+// with missing or partial data are not cached.
+func writeTile(c *gin.Context, contentType string, body []byte, cacheable bool) {
+	hash := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(hash[:16]) + `"`
+
+	c.Header("ETag", etag)
+	c.Header("Vary", "Accept, Authorization")
+
+	if cacheable {
+		c.Header("Cache-Control", tileCacheControl)
+	} else {
+		c.Header("Cache-Control", "no-store")
+	}
+
+	if etagMatches(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	log.Debug().Msgf("tile %s %d bytes", c.Request.URL.Path, len(body))
+
+	c.Data(http.StatusOK, contentType, body)
+}
+
+// etagMatches tests an If-None-Match header, which uses weak comparison
+func etagMatches(header string, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,315 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/antonybholmes/go-dna"
+	seq "github.com/antonybholmes/go-seqs"
+	"github.com/antonybholmes/go-seqs/seqdb"
+	"github.com/antonybholmes/go-sys/log"
+)
+
+const (
+	DefaultMaxLocations = 100
+	DefaultMaxSamples   = 100
+	DefaultMaxBins      = 100000
+)
+
+// BinsLimits caps the size of a bins request
+type BinsLimits struct {
+	MaxLocations int
+	MaxSamples   int
+	// bins per location per sample
+	MaxBins int
+}
+
+// ErrInvalidBinsRequest is wrapped by all validation errors so they can
+// be reported as bad requests.
+var ErrInvalidBinsRequest = errors.New("invalid bins request")
+
+var binsLimits = DefaultBinsLimits()
+
+func DefaultBinsLimits() BinsLimits {
+	return BinsLimits{
+		MaxLocations: DefaultMaxLocations,
+		MaxSamples:   DefaultMaxSamples,
+		MaxBins:      DefaultMaxBins,
+	}
+}
+
+// SetBinsLimits sets the limits for a deployment. Fields less than 1
+// use the defaults. It should be called before the server starts.
+func SetBinsLimits(limits BinsLimits) {
+	defaults := DefaultBinsLimits()
+
+	if limits.MaxLocations < 1 {
+		limits.MaxLocations = defaults.MaxLocations
+	}
+
+	if limits.MaxSamples < 1 {
+		limits.MaxSamples = defaults.MaxSamples
+	}
+
+	if limits.MaxBins < 1 {
+		limits.MaxBins = defaults.MaxBins
+	}
+
+	binsLimits = limits
+}
+
+func invalidf(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalidBinsRequest, fmt.Sprintf(format, args...))
+}
+
+// parseLocation is stricter than dna.ParseLocation, which silently
+// swaps or truncates coordinates, since a reversed location is almost
+// certainly a client bug.
+func parseLocation(location string) (*dna.Location, error) {
+	loc := strings.ReplaceAll(strings.TrimSpace(location), ",", "")
+
+	chr, startEnd, ok := strings.Cut(loc, ":")
+
+	if !ok || chr == "" {
+		return nil, invalidf("%s is not a valid location", location)
+	}
+
+	startToken, endToken, ok := strings.Cut(startEnd, "-")
+
+	if !ok {
+		return nil, invalidf("%s is not a valid location", location)
+	}
+
+	start, err := strconv.Atoi(startToken)
+
+	if err != nil {
+		return nil, invalidf("%s is not a valid start", startToken)
+	}
+
+	end, err := strconv.Atoi(endToken)
+
+	if err != nil {
+		return nil, invalidf("%s is not a valid end", endToken)
+	}
+
+	if start < 1 {
+		return nil, invalidf("start %d in %s is less than 1", start, location)
+	}
+
+	if end < start {
+		return nil, invalidf("end %d in %s is before start %d", end, location, start)
+	}
+
+	ret, err := dna.NewLocation(seq.ParseChr(chr), start, end)
+
+	if err != nil {
+		return nil, invalidf("%s", err)
+	}
+
+	return ret, nil
+}
+
+// binCount is the number of genome aligned bins a location covers
+func binCount(location *dna.Location, binSize int) int {
+	return (location.End()-1)/binSize - (location.Start()-1)/binSize + 1
+}
+
+// validateSeqParams checks the parts of a request that do not depend
+// on the samples.
+func validateSeqParams(params *SeqParams) error {
+	if len(params.Locations) == 0 {
+		return invalidf("no locations supplied")
+	}
+
+	if len(params.Samples) == 0 {
+		return invalidf("no samples supplied")
+	}
+
+	if len(params.Locations) > binsLimits.MaxLocations {
+		return invalidf("%d locations requested, the maximum is %d", len(params.Locations), binsLimits.MaxLocations)
+	}
+
+	if len(params.Samples) > binsLimits.MaxSamples {
+		return invalidf("%d samples requested, the maximum is %d", len(params.Samples), binsLimits.MaxSamples)
+	}
+
+	if params.TargetBins < 0 {
+		return invalidf("target bins %d must be positive", params.TargetBins)
+	}
+
+	if params.TargetBins > 0 {
+		if len(params.BinSizes) > 0 {
+			return invalidf("supply either bin sizes or target bins, not both")
+		}
+
+		if params.TargetBins > binsLimits.MaxBins {
+			return invalidf("%d target bins requested, the maximum is %d", params.TargetBins, binsLimits.MaxBins)
+		}
+
+		// bin sizes are picked per sample later
+		return nil
+	}
+
+	// each location needs a bin size
+	if len(params.BinSizes) != len(params.Locations) {
+		return invalidf("%d bin sizes supplied for %d locations", len(params.BinSizes), len(params.Locations))
+	}
+
+	for li, location := range params.Locations {
+		binSize := params.BinSizes[li]
+
+		if binSize < 1 {
+			return invalidf("bin size %d for %s must be at least 1", binSize, location)
+		}
+
+		bins := binCount(location, binSize)
+
+		if bins > binsLimits.MaxBins {
+			return invalidf("%s with bin size %d needs %d bins, the maximum is %d", location, binSize, bins, binsLimits.MaxBins)
+		}
+	}
+
+	return nil
+}
+
+// validateSamples reads the layout of each sample, using up to
+// maxBinWorkers at once, and checks the request against them, picking
+// bin sizes first if the request only gives target bins. Problems with
+// one sample, such as a bin size it does not store or a chromosome it
+// lacks, are reported with a status for that sample by sampleBinCounts
+// rather than failing the request, as are samples the user cannot view
+// or that cannot be opened.
+func validateSamples(ctx context.Context, params *SeqParams, isAdmin bool, permissions []string) error {
+	layouts := sampleLayouts(ctx, params.Samples, isAdmin, permissions)
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	params.layouts = layouts
+
+	// the longest length seen for each chromosome across samples
+	chrs := make(map[string]int)
+	found := 0
+
+	for _, layout := range layouts {
+		if layout == nil {
+			continue
+		}
+
+		found++
+
+		for chr, size := range layout.Chromosomes {
+			chrs[chr] = max(chrs[chr], size)
+		}
+	}
+
+	if params.TargetBins > 0 {
+		params.resolveBinSizes(layouts)
+
+		// samples may only store bins finer than asked for
+		for si, layout := range layouts {
+			if layout == nil {
+				continue
+			}
+
+			for li, location := range params.Locations {
+				binSize := params.BinSize(li, si)
+
+				bins := binCount(location, binSize)
+
+				if bins > binsLimits.MaxBins {
+					return invalidf("%s with bin size %d for sample %s needs %d bins, the maximum is %d", location, binSize, params.Samples[si], bins, binsLimits.MaxBins)
+				}
+			}
+		}
+	}
+
+	// nothing to check against
+	if found == 0 {
+		return nil
+	}
+
+	for li, location := range params.Locations {
+		size, ok := chrs[location.Chr()]
+
+		// samples without the chromosome are reported individually
+		// but if none have it the request is wrong
+		if !ok {
+			return invalidf("unknown chromosome %s", location.Chr())
+		}
+
+		if size > 0 && location.Start() > size {
+			return invalidf("%s starts after the end of %s at %d", location, location.Chr(), size)
+		}
+
+		// so the response says what was actually read
+		if size > 0 && location.End() > size {
+			clamped, err := dna.NewLocation(location.Chr(), location.Start(), size)
+
+			if err != nil {
+				return invalidf("%s", err)
+			}
+
+			params.Locations[li] = clamped
+		}
+	}
+
+	return nil
+}
+
+// sampleLayouts reads the layouts of the samples in parallel. Layouts
+// are nil for samples the user cannot view or that cannot be read.
+func sampleLayouts(ctx context.Context, samples []string, isAdmin bool, permissions []string) []*seq.SampleLayout {
+	layouts := make([]*seq.SampleLayout, len(samples))
+
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+
+	for range min(maxBinWorkers, len(samples)) {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for si := range jobs {
+				sample := samples[si]
+
+				err := seqdb.CanViewSample(sample, isAdmin, permissions)
+
+				if err != nil {
+					continue
+				}
+
+				layout, err := seqdb.SampleLayout(ctx, sample)
+
+				if err != nil {
+					log.Debug().Msgf("error reading layout of %s: %s", sample, err)
+					continue
+				}
+
+				// each worker writes different samples
+				layouts[si] = layout
+			}
+		}()
+	}
+
+	for si := range samples {
+		if ctx.Err() != nil {
+			break
+		}
+
+		jobs <- si
+	}
+
+	close(jobs)
+
+	wg.Wait()
+
+	return layouts
+}
@@ -461,11 +461,16 @@ cursor.execute(f""" CREATE TABLE datasets (
     institution_id INTEGER NOT NULL,
     name TEXT NOT NULL, 
     description TEXT NOT NULL DEFAULT '',
+    accession TEXT NOT NULL DEFAULT '',
+    doi TEXT NOT NULL DEFAULT '',
+    contact TEXT NOT NULL DEFAULT '',
+    created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
     tags TEXT NOT NULL DEFAULT '',
 	FOREIGN KEY(assembly_id) REFERENCES assemblies(id) ON DELETE CASCADE,
     FOREIGN KEY(institution_id) REFERENCES institutions(id) ON DELETE CASCADE);
 """)
 cursor.execute(f"CREATE INDEX idx_datasets_name_id ON datasets(LOWER(name));")
+cursor.execute("CREATE INDEX idx_datasets_accession ON datasets(LOWER(accession));")
 cursor.execute("CREATE INDEX idx_datasets_assembly_id ON datasets(assembly_id);")
 cursor.execute("CREATE INDEX idx_datasets_institution_id ON datasets(institution_id);")
 
@@ -0,0 +1,203 @@
+package seqs
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/antonybholmes/go-sys/log"
+	"github.com/antonybholmes/go-web"
+	"github.com/antonybholmes/go-web/auth/sqlite"
+)
+
+// Full text search of samples uses an FTS5 table built by seqs-ingest.
+// FTS5 is only compiled into go-sqlite3 with the sqlite_fts5 build tag,
+// so catalogues without the table, or servers built without the tag,
+// fall back to SearchSamplesSql.
+const (
+	SamplesFtsTable = "samples_fts"
+
+	// metadata is the searchable text of the dataset such as its
+	// accession and description
+	CreateSamplesFtsSql = `CREATE VIRTUAL TABLE samples_fts USING fts5(
+		name,
+		dataset,
+		institution,
+		technology,
+		tags,
+		metadata,
+		tokenize = 'unicode61 remove_diacritics 2',
+		prefix = '2 3')`
+
+	// rowid is the id of the sample
+	PopulateSamplesFtsSql = `INSERT INTO samples_fts (rowid, name, dataset, institution, technology, tags, metadata)
+		SELECT
+		s.id,
+		s.name,
+		d.name,
+		ins.name,
+		t.name,
+		COALESCE((SELECT group_concat(json_extract(tag.value, '$.name') || ' ' || json_extract(tag.value, '$.value'), ' ')
+			FROM json_each(s.tags) tag), ''),
+		d.accession || ' ' || d.doi || ' ' || d.contact || ' ' || d.description
+		FROM samples s
+		JOIN datasets d ON s.dataset_id = d.id
+		JOIN institutions ins ON d.institution_id = ins.id
+		JOIN technologies t ON s.technology_id = t.id`
+
+	// bm25 is lower for better matches. Columns are weighted in the
+	// order of the fts table so that sample names count most.
+	FtsSearchSamplesSql = SelectSampleSql +
+		` JOIN dataset_permissions dp ON d.id = dp.dataset_id
+		JOIN permissions p ON dp.permission_id = p.id
+		LEFT JOIN (
+			SELECT rowid, bm25(samples_fts, 10.0, 5.0, 1.0, 2.0, 3.0, 2.0) AS rank
+			FROM samples_fts
+			WHERE samples_fts MATCH :match) f ON f.rowid = s.id
+		WHERE
+			<<PERMISSIONS>>
+			AND LOWER(a.name) = :assembly
+			<<FILTER>>
+			AND (
+				f.rowid IS NOT NULL
+				OR s.public_id = :id
+				OR d.public_id = :id)
+		ORDER BY
+			s.public_id = :id DESC,
+			d.public_id = :id DESC,
+			f.rank,
+			d.name,
+			s.name,
+			s.public_id`
+
+	// one word of a search without the index, which must be in at least
+	// one of the columns the index would have
+	searchWordSql = `(s.name LIKE <<WORD>>
+		OR d.name LIKE <<WORD>>
+		OR ins.name LIKE <<WORD>>
+		OR t.name LIKE <<WORD>>
+		OR d.accession LIKE <<WORD>>
+		OR d.doi LIKE <<WORD>>
+		OR d.contact LIKE <<WORD>>
+		OR d.description LIKE <<WORD>>
+		OR EXISTS (SELECT 1 FROM json_each(s.tags) tag
+			WHERE json_extract(tag.value, '$.name') LIKE <<WORD>>
+			OR json_extract(tag.value, '$.value') LIKE <<WORD>>))`
+
+	// words after this are ignored by searches without the index so
+	// that long queries do not make huge statements
+	MaxSearchWords = 32
+)
+
+// searchWords splits what a user types into words of letters and numbers
+// in the same way as the search index does
+func searchWords(q string) []string {
+	return strings.FieldsFunc(q, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// searchWordsSql matches samples with every word of a query in any of
+// the columns the search index has, for catalogues or builds without
+// the index. Words contain no LIKE wildcards since they are only
+// letters and numbers.
+func searchWordsSql(q string, namedArgs *[]any) string {
+	words := searchWords(q)
+
+	if len(words) > MaxSearchWords {
+		words = words[:MaxSearchWords]
+	}
+
+	// only the id matches apply
+	if len(words) == 0 {
+		return "0"
+	}
+
+	clauses := make([]string, 0, len(words))
+
+	for i, word := range words {
+		param := fmt.Sprintf("word%d", i+1)
+
+		*namedArgs = append(*namedArgs, sql.Named(param, "%"+word+"%"))
+
+		clauses = append(clauses, strings.ReplaceAll(searchWordSql, "<<WORD>>", ":"+param))
+	}
+
+	return strings.Join(clauses, " AND ")
+}
+
+// FtsQuery turns what a user types into an FTS5 query in which every
+// word must match the start of a word in the sample, so "BCL6 CB" finds
+// samples with BCL6 and CB in any of the indexed columns. Words are
+// quoted so FTS5 syntax in the input is treated as text. It returns ""
+// if there are no words.
+func FtsQuery(q string) string {
+	words := searchWords(q)
+
+	terms := make([]string, 0, len(words))
+
+	for _, word := range words {
+		terms = append(terms, `"`+word+`"*`)
+	}
+
+	return strings.Join(terms, " ")
+}
+
+// hasSamplesFts tests whether the catalogue has a search index that this
+// build of sqlite can read. It is called once when the catalogue is
+// opened and says why if ranked search is unavailable.
+func (sdb *SeqDB) hasSamplesFts() bool {
+	var table string
+
+	err := sdb.db.QueryRow(TableExistsSql, sql.Named("name", SamplesFtsTable)).Scan(&table)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Warn().Msgf("ranked search is unavailable since the catalogue has no %s table, rebuild it with seqs-ingest", SamplesFtsTable)
+		} else {
+			log.Warn().Msgf("ranked search is unavailable, error looking for the search index: %s", err)
+		}
+
+		return false
+	}
+
+	// fails with no such module if fts5 is not compiled in
+	rows, err := sdb.db.Query(`SELECT rowid FROM samples_fts LIMIT 0`)
+
+	if err != nil {
+		log.Warn().Msgf("ranked search is unavailable, build with -tags sqlite_fts5 to use the search index: %s", err)
+		return false
+	}
+
+	rows.Close()
+
+	return true
+}
+
+// searchSql picks the query for a search. Queries are matched against
+// the full text index if there is one, otherwise against names and
+// dataset metadata, and an empty query lists every sample.
+func (sdb *SeqDB) searchSql(query string, assembly string, filter *Filter, isAdmin bool, permissions []string) (string, []any) {
+	query = strings.TrimSpace(query)
+
+	namedArgs := []any{sql.Named("assembly", web.FormatParam(ParseAssembly(assembly)))}
+
+	var sqlQuery string
+
+	switch {
+	case query == "":
+		sqlQuery = AllSamplesSql
+	case sdb.hasFts && FtsQuery(query) != "":
+		namedArgs = append(namedArgs, sql.Named("id", query), sql.Named("match", FtsQuery(query)))
+		sqlQuery = FtsSearchSamplesSql
+	default:
+		namedArgs = append(namedArgs, sql.Named("id", query))
+		sqlQuery = strings.Replace(SearchSamplesSql, "<<WORDS>>", searchWordsSql(query, &namedArgs), 1)
+	}
+
+	sqlQuery = sdb.compatSql(makeFilterSql(sqlQuery, filter, &namedArgs))
+
+	return sqlite.MakePermissionsSql(sqlQuery, isAdmin, permissions, &namedArgs), namedArgs
+}
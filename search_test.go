@@ -0,0 +1,75 @@
+package seqs
+
+import (
+	"slices"
+	"testing"
+)
+
+const searchSamplesSql = `INSERT INTO samples (id, public_id, technology_id, institution_id, dataset_id, name, type_id, url, tags)
+	VALUES (2, 's2', 1, 1, 1, 'Sample_2', 1, 'hg19/s2.db',
+		jsonb('[{"name": "cell type", "value": "CB"}, {"name": "antibody", "value": "H3K27ac"}]'));
+	INSERT INTO samples (id, public_id, technology_id, institution_id, dataset_id, name, type_id, url, tags)
+	VALUES (3, 's3', 1, 1, 1, 'Sample_3', 1, 'hg19/s3.db',
+		jsonb('[{"name": "antibody", "value": "BCL6"}]'));`
+
+func TestSearchWithoutIndex(t *testing.T) {
+	sdb := openTestCatalogue(t, oldCatalogueSql, searchSamplesSql)
+
+	if sdb.hasFts {
+		t.Skip("the catalogue has no search index so the simple search is always used")
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		// every word must match, in any column or tag
+		{"CB h3k27ac", []string{"s1", "s2"}},
+		{"lymphoma BCL6", []string{"s3"}},
+		{"BCL6 H3K27ac", []string{}},
+		{"chip columbia", []string{"s1", "s2", "s3"}},
+		// punctuation separates words
+		{"sample_2", []string{"s2"}},
+		// ids match exactly
+		{"s3", []string{"s3"}},
+		// no words, so only ids could match
+		{"%_", []string{}},
+	}
+
+	for _, test := range tests {
+		samples, err := sdb.Search(test.query, "hg19", nil, true, nil)
+
+		if err != nil {
+			t.Errorf("%s: %s", test.query, err)
+			continue
+		}
+
+		ids := make([]string, 0, len(samples))
+
+		for _, sample := range samples {
+			ids = append(ids, sample.Id)
+		}
+
+		slices.Sort(ids)
+
+		if !slices.Equal(ids, test.want) {
+			t.Errorf("%s found %v, want %v", test.query, ids, test.want)
+		}
+	}
+}
+
+func TestSearchWords(t *testing.T) {
+	words := searchWords("BCL6, CB-H3K27ac  naïve")
+
+	if !slices.Equal(words, []string{"BCL6", "CB", "H3K27ac", "naïve"}) {
+		t.Errorf("unexpected words %v", words)
+	}
+
+	var namedArgs []any
+
+	searchWordsSql("a b c d e f g h i j k l m n o p q r s t u v w x y z 1 2 3 4 5 6 7 8 9", &namedArgs)
+
+	if len(namedArgs) != MaxSearchWords {
+		t.Errorf("%d words used, want %d", len(namedArgs), MaxSearchWords)
+	}
+}
@@ -1,8 +1,10 @@
 package seqdb
 
 import (
+	"context"
 	"sync"
 
+	"github.com/antonybholmes/go-dna"
 	"github.com/antonybholmes/go-seqs"
 )
 
@@ -37,18 +39,58 @@ func Datasets(assembly string, isAdmin bool, permissions []string) ([]*seqs.Data
 	return instance.Datasets(assembly, isAdmin, permissions)
 }
 
+func Dataset(datasetId string, isAdmin bool, permissions []string) (*seqs.Dataset, error) {
+	return instance.Dataset(datasetId, isAdmin, permissions)
+}
+
+func Samples(datasetId string, isAdmin bool, permissions []string) ([]*seqs.Sample, error) {
+	return instance.Samples(datasetId, isAdmin, permissions)
+}
+
 // func PlatformDatasets(platform string, assembly string, isAdmin bool, permissions []string) ([]*seqs.Dataset, error) {
 // 	return instance.PlatformDatasets(platform, assembly, isAdmin, permissions)
 // }
 
-func SearchSamples(query string, assembly string, isAdmin bool, permissions []string) ([]*seqs.Sample, error) {
-	return instance.Search(query, assembly, isAdmin, permissions)
+func SearchSamples(query string, assembly string, filter *seqs.Filter, isAdmin bool, permissions []string) ([]*seqs.Sample, error) {
+	return instance.Search(query, assembly, filter, isAdmin, permissions)
+}
+
+func PagedSearchSamples(query string, assembly string, filter *seqs.Filter, page *seqs.SearchPage, isAdmin bool, permissions []string) (*seqs.SearchResults, error) {
+	return instance.PagedSearch(query, assembly, filter, page, isAdmin, permissions)
+}
+
+func SearchFacets(query string, assembly string, filter *seqs.Filter, isAdmin bool, permissions []string) (*seqs.SearchFacets, error) {
+	return instance.Facets(query, assembly, filter, isAdmin, permissions)
 }
 
-func ReaderFromId(sampleId string, binWidth int) (seqs.SeqReader, error) {
-	return instance.ReaderFromId(sampleId, binWidth)
+func ReaderFromId(sampleId string, binWidth int, stat string) (seqs.SeqReader, error) {
+	return instance.ReaderFromId(sampleId, binWidth, stat)
 }
 
 func CanViewSample(sampleId string, isAdmin bool, permissions []string) error {
 	return instance.CanViewSample(sampleId, isAdmin, permissions)
 }
+
+func PoolStats() seqs.PoolStats {
+	return instance.PoolStats()
+}
+
+func SampleLayout(ctx context.Context, sampleId string) (*seqs.SampleLayout, error) {
+	return instance.SampleLayout(ctx, sampleId)
+}
+
+func SetBinCache(cache *seqs.BinCache) {
+	instance.SetBinCache(cache)
+}
+
+func BinCounts(ctx context.Context, reader seqs.SeqReader, location *dna.Location, norm string, scale float64) (*seqs.SampleBinCounts, error) {
+	return instance.BinCounts(ctx, reader, location, norm, scale)
+}
+
+func BinCacheStats() seqs.BinCacheStats {
+	return instance.BinCacheStats()
+}
+
+func ChromSizes(assembly string) ([]*seqs.ChromSize, error) {
+	return instance.ChromSizes(assembly)
+}
@@ -1,19 +1,20 @@
 package seqs
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
-	"path/filepath"
+	"errors"
+	"fmt"
 	"sort"
 
 	"github.com/antonybholmes/go-dna"
-	"github.com/antonybholmes/go-sys/db"
 	"github.com/antonybholmes/go-sys/log"
 	basemath "github.com/antonybholmes/go-sys/math"
 )
 
 const (
-	TotalBinReadsSql = `SELECT reads FROM bins WHERE size = :bin_size`
+	TotalBinReadsSql = `SELECT reads, bpm_scale_factor FROM bins WHERE size = :bin_size`
 
 	ReadsSql = `SELECT 
 		r.start, 
@@ -33,16 +34,21 @@ type DBSeqReader struct {
 	sample  *Sample
 	url     string
 	binSize int
+	// one of the Stat values
+	stat string
+	pool *SampleDBPool
 	//defaultBinCount int
 	//scale           float64
 }
 
-func NewDBSeqReader(sample *Sample, url string, binSize int) (*DBSeqReader, error) {
+func NewDBSeqReader(sample *Sample, url string, binSize int, stat string, pool *SampleDBPool) (*DBSeqReader, error) {
 
 	return &DBSeqReader{
 		sample:  sample,
 		url:     url,
 		binSize: binSize,
+		stat:    stat,
+		pool:    pool,
 
 		// estimate the number of bins to represent a region
 		//defaultBinCount: binSize * 4,
@@ -53,7 +59,15 @@ func NewDBSeqReader(sample *Sample, url string, binSize int) (*DBSeqReader, erro
 // 	return filepath.Join(reader.Dir, fmt.Sprintf("bin%d", reader.BinSize), fmt.Sprintf("%s_bin%d_%s.db?mode=ro", location.Chr, reader.BinSize, reader.Track.Genome))
 // }
 
-func (reader *DBSeqReader) BinCounts(location *dna.Location) (*SampleBinCounts, error) {
+func (reader *DBSeqReader) Version() (string, error) {
+	return fileVersion(reader.url)
+}
+
+func (reader *DBSeqReader) CacheKey(version string) string {
+	return readerCacheKey(reader.sample, version, reader.binSize, reader.stat)
+}
+
+func (reader *DBSeqReader) BinCounts(ctx context.Context, location *dna.Location) (*SampleBinCounts, error) {
 
 	//var startBin uint = (location.Start - 1) / reader.BinSize
 	//var endBin uint = (location.End - 1) / reader.BinSize
@@ -69,36 +83,68 @@ func (reader *DBSeqReader) BinCounts(location *dna.Location) (*SampleBinCounts,
 		Bins:    make([]*ReadBin, 0, reader.binSize),
 		YMax:    0,
 		BinSize: reader.binSize,
-		//Reads:   reader.sample.Reads,
+		Reads:   reader.sample.Reads,
+		Norm:    NormRaw,
+		Stat:    reader.stat,
+		Status:  SampleStatusOk,
 	}
 
 	// path := filepath.Join(reader.url,
 	// 	fmt.Sprintf("%s.db?mode=ro", location.Chr()))
 
-	path := filepath.Join(reader.url + db.SqliteDSN)
+	path := reader.url
+
+	chrSize := reader.sample.ChrSize(location.Chr())
+
+	location, err := clampLocation(location, chrSize)
+
+	if err != nil {
+		return &ret, err
+	}
 
 	//log.Debug().Msgf("track path %s", path)
 
-	db, err := sql.Open(db.Sqlite3DB, path)
+	sampleDB, err := reader.pool.Acquire(path)
 
 	if err != nil {
 		return &ret, err
 	}
 
-	defer db.Close()
+	defer reader.pool.Release(sampleDB)
+
+	ret.version = sampleDB.version
+
+	// the stored resolution to read, which differs from the bin size
+	// if the sample was not binned at that size
+	sourceSize := reader.binSize
+
+	err = sampleDB.binReadsStmt.QueryRowContext(ctx, sql.Named("bin_size", sourceSize)).Scan(&ret.BinReads, &ret.BpmScaleFactor)
 
-	//var bpmReads int
-	//var scaleFactor float64
+	if errors.Is(err, sql.ErrNoRows) {
+		sourceSize, err = sampleDB.resampleSource(ctx, reader.binSize)
 
-	err = db.QueryRow(TotalBinReadsSql, reader.binSize).Scan(&ret.BinReads) ///endBin)
+		if err == nil {
+			log.Debug().Msgf("resampling %s from bin size %d to %d", path, sourceSize, reader.binSize)
+
+			err = sampleDB.binReadsStmt.QueryRowContext(ctx, sql.Named("bin_size", sourceSize)).Scan(&ret.BinReads, &ret.BpmScaleFactor)
+		}
+	}
 
 	if err != nil {
 		log.Debug().Msgf("error scale factor %s %s", path, err)
 		return &ret, err
 	}
 
-	//ret.BinReads = bpmReads
-	//ret.BpmScaleFactor = scaleFactor
+	queryLoc := location
+
+	if sourceSize != reader.binSize {
+		// read whole requested bins so the edge bins are complete
+		queryLoc, err = alignLocToBinSize(location, reader.binSize)
+
+		if err != nil {
+			return &ret, err
+		}
+	}
 
 	//var binSql string
 
@@ -117,17 +163,19 @@ func (reader *DBSeqReader) BinCounts(location *dna.Location) (*SampleBinCounts,
 	// 	binSql = BIN_16384_SQL
 	// }
 
-	rows, err := db.Query(ReadsSql,
-		sql.Named("chr", location.Chr()),
-		sql.Named("bin", reader.binSize),
-		sql.Named("start", location.Start()), //	startBin,
-		sql.Named("end", location.End()))     ///endBin)
+	rows, err := sampleDB.readsStmt.QueryContext(ctx,
+		sql.Named("chr", sampleDB.sourceChr(queryLoc.Chr())),
+		sql.Named("bin", sourceSize),
+		sql.Named("start", queryLoc.Start()), //	startBin,
+		sql.Named("end", queryLoc.End()))     ///endBin)
 
 	if err != nil {
 		log.Debug().Msgf("error reading reads %s %s", path, err)
 		return &ret, err
 	}
 
+	defer rows.Close()
+
 	for rows.Next() {
 		var bin ReadBin
 		// read the location
@@ -140,6 +188,16 @@ func (reader *DBSeqReader) BinCounts(location *dna.Location) (*SampleBinCounts,
 		ret.Bins = append(ret.Bins, &bin)
 	}
 
+	if sourceSize != reader.binSize {
+		ret.Bins = resampleBins(ret.Bins, sourceSize, location, reader.binSize, chrSize, reader.stat)
+	} else {
+		for _, bin := range ret.Bins {
+			bin.Count = statValue(bin.Count, reader.stat)
+		}
+	}
+
+	ret.Bins = clipBins(ret.Bins, chrSize)
+
 	for _, bin := range ret.Bins {
 		ret.YMax = basemath.Max(ret.YMax, bin.Count)
 	}
@@ -147,6 +205,50 @@ func (reader *DBSeqReader) BinCounts(location *dna.Location) (*SampleBinCounts,
 	return &ret, nil
 }
 
+// resampleSource picks the stored bin size to build binSize from
+func (sampleDB *SampleDB) resampleSource(ctx context.Context, binSize int) (int, error) {
+	stored, err := sampleDB.binSizes(ctx)
+
+	if err != nil {
+		return 0, err
+	}
+
+	sourceSize, ok := resampleSource(stored, binSize)
+
+	if !ok {
+		return 0, fmt.Errorf("%w: %d, sizes are %v", ErrUnsupportedBinSize, binSize, stored)
+	}
+
+	return sourceSize, nil
+}
+
+// binSizes lists the bin sizes stored in a sample db, smallest first
+func (sampleDB *SampleDB) binSizes(ctx context.Context) ([]int, error) {
+	rows, err := sampleDB.db.QueryContext(ctx, BinSizesSql)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	ret := make([]int, 0, 10)
+
+	for rows.Next() {
+		var size int
+
+		err := rows.Scan(&size)
+
+		if err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, size)
+	}
+
+	return ret, nil
+}
+
 // Creates the IN clause for permissions and appends named args
 // for use in sql query so it can be done in a safe way
 // func MakePermissionsInClause(permissions []string, namedArgs *[]any) string {
@@ -1,7 +1,9 @@
 package seqs
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"path/filepath"
 	"strings"
@@ -9,6 +11,7 @@ import (
 	"github.com/antonybholmes/go-dna"
 	"github.com/antonybholmes/go-sys"
 	"github.com/antonybholmes/go-sys/db"
+	"github.com/antonybholmes/go-sys/log"
 	"github.com/antonybholmes/go-web"
 	"github.com/antonybholmes/go-web/auth/sqlite"
 	_ "github.com/mattn/go-sqlite3"
@@ -24,15 +27,38 @@ type (
 	SampleBinCounts struct {
 		Id string `json:"id"`
 		//Name    string     `json:"name"`
-		Bins    []*ReadBin `json:"bins"`
-		YMax    float64    `json:"ymax"`
-		BinSize int        `json:"binSize"`
-		//BpmScaleFactor float64    `json:"bpmScaleFactor"`
-		//Reads int `json:"reads,"`
+		Bins           []*ReadBin `json:"bins"`
+		YMax           float64    `json:"ymax"`
+		BinSize        int        `json:"binSize"`
+		BpmScaleFactor float64    `json:"bpmScaleFactor,omitempty"`
+		// true if BinReads and BpmScaleFactor are estimated rather than
+		// counted, as they are for bams
+		BpmEstimate bool `json:"bpmEstimate,omitempty"`
+		// total reads in the sample library
+		Reads int `json:"reads,omitempty"`
 
 		// sum of all reads falling in all bins, which
 		// can be higher than total reads in sample if some reads fall in multiple bins
 		BinReads int `json:"binReads,omitempty"`
+
+		// normalization applied to the counts
+		Norm string `json:"norm"`
+
+		// statistic used to summarize each bin
+		Stat string `json:"stat,omitempty"`
+
+		// one of the SampleStatus values so clients can tell an empty
+		// region from a sample that could not be read
+		Status  string `json:"status"`
+		Message string `json:"message,omitempty"`
+
+		// dense output: the value of every bin from Start, used
+		// instead of Bins
+		Start  int         `json:"start,omitempty"`
+		Values DenseValues `json:"values,omitempty"`
+
+		// version of the file that was read, for the bin cache
+		version string
 	}
 
 	Platform struct {
@@ -42,13 +68,21 @@ type (
 	}
 
 	Dataset struct {
-		Id          string    `json:"id"`
-		Genome      string    `json:"genome"`
-		Assembly    string    `json:"assembly"`
-		Platform    string    `json:"platform"`
-		Institution string    `json:"institution"`
-		Name        string    `json:"name"`
-		Samples     []*Sample `json:"samples"`
+		Id          string `json:"id"`
+		Genome      string `json:"genome"`
+		Assembly    string `json:"assembly"`
+		Institution string `json:"institution"`
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		// GEO or SRA accession, e.g. GSE12345
+		Accession string `json:"accession"`
+		// DOI of the publication describing the data
+		Doi     string `json:"doi"`
+		Contact string `json:"contact"`
+		// ISO 8601 time the dataset was added
+		CreatedAt   string    `json:"createdAt"`
+		SampleCount int       `json:"sampleCount"`
+		Samples     []*Sample `json:"samples,omitempty"`
 	}
 
 	Tag struct {
@@ -73,11 +107,25 @@ type (
 		PublicUrl string `json:"url,omitempty"`
 		Tags      []Tag  `json:"tags"`
 		Reads     int    `json:"reads,omitempty"`
+		// chromosome sizes of the assembly, nil if not in the catalogue
+		chromSizes *assemblyChromSizes
 	}
 
 	SeqDB struct {
-		db  *sql.DB
-		url string
+		db   *sql.DB
+		pool *SampleDBPool
+		// optional, nil if results are not cached
+		binCache *BinCache
+		// keyed by lower case assembly name
+		chromSizes map[string]*assemblyChromSizes
+		// layouts of local samples by file version
+		layouts *layoutCache
+		// replaces dataset columns older catalogues lack, nil if
+		// they have them all
+		datasetColumns *strings.Replacer
+		// whether searches can use the samples_fts index
+		hasFts bool
+		url    string
 	}
 )
 
@@ -91,6 +139,8 @@ const (
 	SampleTypeSeq          = "Seq"
 	SampleTypeBigWig       = "BigWig"
 	SampleTypeRemoteBigWig = "RemoteBigWig"
+	// a coordinate sorted bam with a bai index that is binned on the fly
+	SampleTypeBam = "Bam"
 
 	//SampleTypeLocalBigWig = "BigWig"
 
@@ -113,12 +163,20 @@ const (
 	// 		a.name,
 	// 		t.name`
 
-	DatasetsSql = `SELECT DISTINCT
-		d.public_id,
+	// columns read by scanDataset
+	datasetColumnsSql = `d.public_id,
 		g.name AS genome,
 		a.name AS assembly,
 		ins.name AS institution,
-		d.name
+		d.name,
+		d.description,
+		d.accession,
+		d.doi,
+		d.contact,
+		d.created_at,
+		(SELECT COUNT(*) FROM samples s WHERE s.dataset_id = d.id) AS sample_count`
+
+	DatasetsSql = `SELECT DISTINCT ` + datasetColumnsSql + `
 		FROM datasets d
 		JOIN institutions ins ON d.institution_id = ins.id
 		JOIN assemblies a ON d.assembly_id = a.id
@@ -164,6 +222,26 @@ const (
 			<<PERMISSIONS>>
 			AND s.public_id = :id`
 
+	SampleExistsSql = `SELECT public_id FROM samples WHERE public_id = :id`
+
+	CanViewDatasetSql = `SELECT DISTINCT
+		d.public_id
+		FROM datasets d
+		JOIN dataset_permissions dp ON d.id = dp.dataset_id
+		JOIN permissions p ON dp.permission_id = p.id
+		WHERE
+			<<PERMISSIONS>>
+			AND d.public_id = :id`
+
+	DatasetExistsSql = `SELECT public_id FROM datasets WHERE public_id = :id`
+
+	DatasetSql = `SELECT ` + datasetColumnsSql + `
+		FROM datasets d
+		JOIN institutions ins ON d.institution_id = ins.id
+		JOIN assemblies a ON d.assembly_id = a.id
+		JOIN genomes g ON a.genome_id = g.id
+		WHERE d.public_id = :id`
+
 	SelectSampleSql = `SELECT DISTINCT
 		s.public_id,
 		g.name AS genome,
@@ -197,33 +275,47 @@ const (
 		JOIN permissions p ON dp.permission_id = p.id
 		WHERE 
 			<<PERMISSIONS>>
-			AND LOWER(a.name) = :assembly`
+			AND LOWER(a.name) = :assembly
+			<<FILTER>>`
 
 	AllSamplesSql = BaseSearchSamplesSql +
 		` ORDER BY 
 			t.name,
 			ins.name,
 			d.name, 
-			s.name`
+			s.name,
+			s.public_id`
 
+	// <<WORDS>> requires every word of the query to be in one of the
+	// columns the search index has, see searchWordsSql
 	SearchSamplesSql = BaseSearchSamplesSql +
 		` AND (
 			s.public_id = :id 
 			OR d.public_id = :id 
 			OR LOWER(t.name) = :id 
-			OR d.name LIKE :q 
-			OR s.name LIKE :q)
+			OR (<<WORDS>>))
 		ORDER BY 
 			t.name,
 			ins.name,
 			d.name, 
-			s.name`
+			s.name,
+			s.public_id`
 )
 
 func (sdb *SeqDB) Dir() string {
 	return sdb.url
 }
 
+// samplePath returns the path of a sample file, which is relative to
+// the db folder unless absolute
+func (sdb *SeqDB) samplePath(url string) string {
+	if filepath.IsAbs(url) {
+		return url
+	}
+
+	return filepath.Join(sdb.url, url)
+}
+
 func NewSeqDB(dbpath string) *SeqDB {
 	db := sys.Must(sql.Open(db.Sqlite3DB, dbpath+db.SqliteDSN))
 
@@ -232,13 +324,70 @@ func NewSeqDB(dbpath string) *SeqDB {
 
 	//x := sys.Must(db.Prepare(ALL_TRACKS_SQL))
 
-	return &SeqDB{url: path, db: db}
+	sdb := SeqDB{url: path,
+		db:      db,
+		pool:    NewSampleDBPool(DefaultSampleDBPoolSize),
+		layouts: newLayoutCache()}
+
+	// so that aliases point at the names the catalogue uses
+	err := sdb.loadNaming(defaultNaming)
+
+	if err != nil {
+		log.Warn().Msgf("error loading assembly names: %s", err)
+	}
+
+	err = sdb.loadChromSizes()
+
+	if err != nil {
+		log.Warn().Msgf("error loading chromosome sizes: %s", err)
+	}
+
+	err = sdb.loadDatasetColumns()
+
+	if err != nil {
+		log.Warn().Msgf("error reading dataset columns: %s", err)
+	}
+
+	sdb.hasFts = sdb.hasSamplesFts()
+
+	return &sdb
 }
 
 func (sdb *SeqDB) Close() error {
+	err := sdb.pool.Close()
+
+	if err != nil {
+		return err
+	}
+
 	return sdb.db.Close()
 }
 
+// SetBinCache turns on caching of bins. It should be called before the
+// server starts.
+func (sdb *SeqDB) SetBinCache(cache *BinCache) {
+	sdb.binCache = cache
+}
+
+// BinCounts reads the normalized bins of a sample, using the bin cache
+// if there is one.
+func (sdb *SeqDB) BinCounts(ctx context.Context, reader SeqReader, location *dna.Location, norm string, scale float64) (*SampleBinCounts, error) {
+	return sdb.binCache.BinCounts(ctx, reader, location, norm, scale)
+}
+
+func (sdb *SeqDB) BinCacheStats() BinCacheStats {
+	if sdb.binCache == nil {
+		return BinCacheStats{}
+	}
+
+	return sdb.binCache.Stats()
+}
+
+// PoolStats reports how well the sample db pool is being reused
+func (sdb *SeqDB) PoolStats() PoolStats {
+	return sdb.pool.Stats()
+}
+
 // func (sdb *SeqDB) Genomes(permissions []string) ([]string, error) {
 // 	rows, err := sdb.db.Query(DatasetsSql)
 
@@ -273,14 +422,28 @@ func (sdb *SeqDB) CanViewSample(sampleId string, isAdmin bool, permissions []str
 	var id string
 	err := sdb.db.QueryRow(query, namedArgs...).Scan(&id)
 
-	// no rows means no permission
 	if err != nil {
-		return err
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		// no rows means no permission, unless the sample does not exist
+		err = sdb.db.QueryRow(SampleExistsSql, sql.Named("id", sampleId)).Scan(&id)
+
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("%w: %s", ErrSampleNotFound, sampleId)
+			}
+
+			return err
+		}
+
+		return fmt.Errorf("%w: %s", ErrPermissionDenied, sampleId)
 	}
 
 	// sanity
 	if id != sampleId {
-		return fmt.Errorf("permission denied to view sample %s", sampleId)
+		return fmt.Errorf("%w: %s", ErrPermissionDenied, sampleId)
 	}
 
 	return nil
@@ -320,9 +483,9 @@ func (sdb *SeqDB) CanViewSample(sampleId string, isAdmin bool, permissions []str
 
 func (sdb *SeqDB) Datasets(assembly string, isAdmin bool, permissions []string) ([]*Dataset, error) {
 	// build sql.Named args
-	namedArgs := []any{sql.Named("assembly", web.FormatParam(assembly))}
+	namedArgs := []any{sql.Named("assembly", web.FormatParam(ParseAssembly(assembly)))}
 
-	query := sqlite.MakePermissionsSql(DatasetsSql, isAdmin, permissions, &namedArgs)
+	query := sqlite.MakePermissionsSql(sdb.compatSql(DatasetsSql), isAdmin, permissions, &namedArgs)
 
 	// execute query
 
@@ -337,19 +500,13 @@ func (sdb *SeqDB) Datasets(assembly string, isAdmin bool, permissions []string)
 	ret := make([]*Dataset, 0, 10)
 
 	for rows.Next() {
-		var dataset Dataset
-
-		err := rows.Scan(&dataset.Id,
-			&dataset.Assembly,
-			&dataset.Platform,
-			&dataset.Institution,
-			&dataset.Name)
+		dataset, err := scanDataset(rows.Scan)
 
 		if err != nil {
 			return nil, err //fmt.Errorf("there was an error with the database records")
 		}
 
-		ret = append(ret, &dataset)
+		ret = append(ret, dataset)
 	}
 
 	return ret, nil
@@ -394,7 +551,97 @@ func (sdb *SeqDB) Datasets(assembly string, isAdmin bool, permissions []string)
 // 	return ret, nil
 // }
 
-func (sdb *SeqDB) Samples(datasetId string) ([]*Sample, error) {
+// CanViewDataset returns ErrDatasetNotFound or ErrPermissionDenied if
+// the user cannot view a dataset
+func (sdb *SeqDB) CanViewDataset(datasetId string, isAdmin bool, permissions []string) error {
+	namedArgs := []any{sql.Named("id", datasetId)}
+
+	query := sqlite.MakePermissionsSql(CanViewDatasetSql, isAdmin, permissions, &namedArgs)
+
+	var id string
+	err := sdb.db.QueryRow(query, namedArgs...).Scan(&id)
+
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		// no rows means no permission, unless the dataset does not exist
+		err = sdb.db.QueryRow(DatasetExistsSql, sql.Named("id", datasetId)).Scan(&id)
+
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("%w: %s", ErrDatasetNotFound, datasetId)
+			}
+
+			return err
+		}
+
+		return fmt.Errorf("%w: %s", ErrPermissionDenied, datasetId)
+	}
+
+	return nil
+}
+
+// Dataset returns a dataset with its samples, which share the dataset's
+// permissions
+func (sdb *SeqDB) Dataset(datasetId string, isAdmin bool, permissions []string) (*Dataset, error) {
+	err := sdb.CanViewDataset(datasetId, isAdmin, permissions)
+
+	if err != nil {
+		return nil, err
+	}
+
+	dataset, err := scanDataset(sdb.db.QueryRow(sdb.compatSql(DatasetSql), sql.Named("id", datasetId)).Scan)
+
+	if err != nil {
+		return nil, err
+	}
+
+	dataset.Samples, err = sdb.datasetSamples(datasetId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return dataset, nil
+}
+
+// scanDataset reads the datasetColumnsSql columns of a row
+func scanDataset(scan func(dest ...any) error) (*Dataset, error) {
+	var dataset Dataset
+
+	err := scan(&dataset.Id,
+		&dataset.Genome,
+		&dataset.Assembly,
+		&dataset.Institution,
+		&dataset.Name,
+		&dataset.Description,
+		&dataset.Accession,
+		&dataset.Doi,
+		&dataset.Contact,
+		&dataset.CreatedAt,
+		&dataset.SampleCount)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &dataset, nil
+}
+
+// Samples lists the samples of a dataset the user can view
+func (sdb *SeqDB) Samples(datasetId string, isAdmin bool, permissions []string) ([]*Sample, error) {
+	err := sdb.CanViewDataset(datasetId, isAdmin, permissions)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return sdb.datasetSamples(datasetId)
+}
+
+func (sdb *SeqDB) datasetSamples(datasetId string) ([]*Sample, error) {
 	rows, err := sdb.db.Query(DatasetSamplesSql, sql.Named("id", datasetId))
 
 	if err != nil {
@@ -418,40 +665,13 @@ func (sdb *SeqDB) Samples(datasetId string) ([]*Sample, error) {
 	return ret, nil
 }
 
-func (sdb *SeqDB) Search(query string, assembly string, isAdmin bool, permissions []string) ([]*Sample, error) {
-
-	var rows *sql.Rows
-	var err error
-
-	if query != "" {
-		query = strings.TrimSpace(query)
-
-		namedArgs := []any{sql.Named("assembly", web.FormatParam(assembly)),
-			sql.Named("id", query),
-			sql.Named("q", fmt.Sprintf("%%%s%%", query)),
-		}
-
-		query := sqlite.MakePermissionsSql(SearchSamplesSql, isAdmin, permissions, &namedArgs)
-
-		// if platform != "" {
-		// 	// platform specific search
-		// 	rows, err = sdb.db.Query(SearchPlatformSamplesSql,
-		// 		sql.Named("assembly", assembly),
-		// 		sql.Named("platform", platform),
-		// 		sql.Named("id", query),
-		// 		sql.Named("q", fmt.Sprintf("%%%s%%", query)))
-
-		// } else {
-		//search all platforms within assembly
-		rows, err = sdb.db.Query(query, namedArgs...)
-		//}
-	} else {
-		namedArgs := []any{sql.Named("assembly", web.FormatParam(assembly))}
+// Search finds the samples of an assembly matching a query, narrowed by
+// an optional filter (see ParseFilter)
+func (sdb *SeqDB) Search(query string, assembly string, filter *Filter, isAdmin bool, permissions []string) ([]*Sample, error) {
 
-		query := sqlite.MakePermissionsSql(AllSamplesSql, isAdmin, permissions, &namedArgs)
+	sqlQuery, namedArgs := sdb.searchSql(query, assembly, filter, isAdmin, permissions)
 
-		rows, err = sdb.db.Query(query, namedArgs...)
-	}
+	rows, err := sdb.db.Query(sqlQuery, namedArgs...)
 
 	if err != nil {
 		return nil, err //fmt.Errorf("there was an error with the database query")
@@ -490,18 +710,38 @@ func (sdb *SeqDB) Search(query string, assembly string, isAdmin bool, permission
 	return ret, nil
 }
 
+// SeqReader bins the reads of a sample. Readers should stop and return
+// the context error if ctx is cancelled, for example when a client
+// disconnects.
 type SeqReader interface {
-	BinCounts(location *dna.Location) (*SampleBinCounts, error)
+	BinCounts(ctx context.Context, location *dna.Location) (*SampleBinCounts, error)
 }
 
-func (sdb *SeqDB) ReaderFromId(sampleId string, binWidth int) (SeqReader, error) {
-
-	//const FIND_TRACK_SQL = `SELECT platform, genome, name, reads, stat_mode, url FROM tracks WHERE seq.publicId = ?1`
-
+// sample looks up a sample without checking permissions
+func (sdb *SeqDB) sample(sampleId string) (*Sample, error) {
 	row := sdb.db.QueryRow(SampleFromIdSql, sql.Named("id", sampleId))
 
 	sample, err := rowToSample(row)
 
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s", ErrSampleNotFound, sampleId)
+		}
+
+		return nil, err
+	}
+
+	sample.chromSizes = sdb.assemblyChromSizes(sample.Assembly)
+
+	return sample, nil
+}
+
+func (sdb *SeqDB) ReaderFromId(sampleId string, binWidth int, stat string) (SeqReader, error) {
+
+	//const FIND_TRACK_SQL = `SELECT platform, genome, name, reads, stat_mode, url FROM tracks WHERE seq.publicId = ?1`
+
+	sample, err := sdb.sample(sampleId)
+
 	if err != nil {
 		return nil, err
 	}
@@ -512,9 +752,13 @@ func (sdb *SeqDB) ReaderFromId(sampleId string, binWidth int) (SeqReader, error)
 
 	switch sample.Type {
 	case SampleTypeBigWig:
-		return NewBigWigReader(sample, binWidth)
+		return NewBigWigReader(sample, binWidth, stat)
+	case SampleTypeRemoteBigWig:
+		return NewRemoteBigWigReader(sample, binWidth, stat)
+	case SampleTypeBam:
+		return NewBamReader(sample, sdb.samplePath(sample.Url), binWidth, stat)
 	default:
-		return NewDBSeqReader(sample, filepath.Join(sdb.url, sample.Url), binWidth)
+		return NewDBSeqReader(sample, filepath.Join(sdb.url, sample.Url), binWidth, stat, sdb.pool)
 	}
 
 }
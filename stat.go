@@ -0,0 +1,115 @@
+package seqs
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+const (
+	// average value of each bin. For bigwigs this is over the bases
+	// with data, as bigWigSummary does; for read counts it is the reads
+	// in the bin, whether the bin size is stored or resampled.
+	StatMean = "mean"
+	// largest value in each bin, so narrow peaks are not averaged away
+	StatMax = "max"
+	// smallest value in each bin
+	StatMin = "min"
+	// total of the values in each bin
+	StatSum = "sum"
+	// fraction of each bin that has data
+	StatCoverage = "coverage"
+	// standard deviation of the values in each bin
+	StatStd = "std"
+)
+
+func ParseStat(stat string) (string, error) {
+	stat = strings.ToLower(strings.TrimSpace(stat))
+
+	switch stat {
+	case "":
+		return StatMean, nil
+	case StatMean, StatMax, StatMin, StatSum, StatCoverage, StatStd:
+		return stat, nil
+	default:
+		return "", fmt.Errorf("unknown stat %s", stat)
+	}
+}
+
+// binStat accumulates the non-empty sub-bins that make up a bin. Weights
+// are in sub-bins and can be fractional when sub-bins straddle a bin
+// edge.
+type binStat struct {
+	covered    float64
+	sum        float64
+	sumSquares float64
+	min        float64
+	max        float64
+}
+
+func (s *binStat) add(value float64, weight float64) {
+	if s.covered == 0 {
+		s.min = value
+		s.max = value
+	} else {
+		s.min = math.Min(s.min, value)
+		s.max = math.Max(s.max, value)
+	}
+
+	s.covered += weight
+	s.sum += value * weight
+	s.sumSquares += value * value * weight
+}
+
+// value calculates a stat for a bin made of size sub-bins, where the
+// sub-bins that were not added are zero
+func (s *binStat) value(stat string, size float64) float64 {
+	if s.covered == 0 || size <= 0 {
+		return 0
+	}
+
+	// some sub-bins are empty
+	gaps := s.covered < size
+
+	switch stat {
+	case StatSum:
+		return s.sum
+	case StatMax:
+		if gaps {
+			return math.Max(s.max, 0)
+		}
+
+		return s.max
+	case StatMin:
+		if gaps {
+			return math.Min(s.min, 0)
+		}
+
+		return s.min
+	case StatCoverage:
+		return math.Min(s.covered/size, 1)
+	case StatStd:
+		mean := s.sum / size
+		v := s.sumSquares/size - mean*mean
+
+		if v <= 0 {
+			return 0
+		}
+
+		return math.Sqrt(v)
+	default:
+		return s.sum / size
+	}
+}
+
+// statValue is a stat of a bin that holds a single count, as in the
+// sample dbs and bams at their native bin size
+func statValue(count float64, stat string) float64 {
+	var s binStat
+
+	if count != 0 {
+		s.add(count, 1)
+	}
+
+	return s.value(stat, 1)
+}
@@ -0,0 +1,114 @@
+package seqs
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseStat(t *testing.T) {
+	for input, want := range map[string]string{"": StatMean, " MAX ": StatMax, "std": StatStd, "coverage": StatCoverage} {
+		stat, err := ParseStat(input)
+
+		if err != nil || stat != want {
+			t.Errorf("%q is %q %v, want %q", input, stat, err, want)
+		}
+	}
+
+	_, err := ParseStat("median")
+
+	if err == nil {
+		t.Errorf("expected an error for an unknown stat")
+	}
+}
+
+func TestBinStat(t *testing.T) {
+	type value struct {
+		value  float64
+		weight float64
+	}
+
+	tests := []struct {
+		name   string
+		values []value
+		size   float64
+		want   map[string]float64
+	}{
+		{"no gaps", []value{{4, 1}, {2, 1}}, 2, map[string]float64{
+			StatMean: 3, StatSum: 6, StatMax: 4, StatMin: 2, StatCoverage: 1, StatStd: 1}},
+		// the 2 empty sub-bins are 0, which is the min
+		{"gaps", []value{{4, 1}, {2, 1}, {1, 1}}, 5, map[string]float64{
+			StatMean: 1.4, StatSum: 7, StatMax: 4, StatMin: 0, StatCoverage: 0.6, StatStd: math.Sqrt(21.0/5 - 1.4*1.4)}},
+		// and for negative values, the max
+		{"negative with gaps", []value{{-2, 1}, {-4, 1}}, 4, map[string]float64{
+			StatMean: -1.5, StatSum: -6, StatMax: 0, StatMin: -4, StatCoverage: 0.5, StatStd: math.Sqrt(5 - 1.5*1.5)}},
+		// sub-bins straddling the edges of the bin count in part
+		{"fractional", []value{{2, 0.5}, {6, 1}, {2, 0.5}}, 2, map[string]float64{
+			StatMean: 4, StatSum: 8, StatMax: 6, StatMin: 2, StatCoverage: 1, StatStd: 2}},
+		{"empty", nil, 4, map[string]float64{
+			StatMean: 0, StatSum: 0, StatMax: 0, StatMin: 0, StatCoverage: 0, StatStd: 0}},
+	}
+
+	for _, test := range tests {
+		var s binStat
+
+		for _, v := range test.values {
+			s.add(v.value, v.weight)
+		}
+
+		for stat, want := range test.want {
+			if got := s.value(stat, test.size); math.Abs(got-want) > 1e-12 {
+				t.Errorf("%s %s is %f, want %f", test.name, stat, got, want)
+			}
+		}
+	}
+}
+
+func TestStatValue(t *testing.T) {
+	tests := []struct {
+		count float64
+		want  map[string]float64
+	}{
+		{3, map[string]float64{StatMean: 3, StatSum: 3, StatMax: 3, StatMin: 3, StatCoverage: 1, StatStd: 0}},
+		{0, map[string]float64{StatMean: 0, StatSum: 0, StatMax: 0, StatMin: 0, StatCoverage: 0, StatStd: 0}},
+	}
+
+	for _, test := range tests {
+		for stat, want := range test.want {
+			if got := statValue(test.count, stat); got != want {
+				t.Errorf("%s of %f is %f, want %f", stat, test.count, got, want)
+			}
+		}
+	}
+}
+
+func TestResampleStatsWithGaps(t *testing.T) {
+	// 100 base bins with counts 4 and 2 and a gap of 3 bins in the
+	// first 500 bases, then a gap of a whole 500 base bin
+	runs := []*ReadBin{
+		{Start: 1, End: 100, Count: 4},
+		{Start: 201, End: 300, Count: 2},
+		{Start: 1001, End: 1500, Count: 1},
+	}
+
+	location := binsLocation(t, "chr1", 1, 1500)
+
+	tests := []struct {
+		stat string
+		want []*ReadBin
+	}{
+		{StatSum, []*ReadBin{{Start: 1, End: 500, Count: 6}, {Start: 1001, End: 1500, Count: 5}}},
+		// reads in the bin, as for stored bins
+		{StatMean, []*ReadBin{{Start: 1, End: 500, Count: 6}, {Start: 1001, End: 1500, Count: 5}}},
+		{StatMax, []*ReadBin{{Start: 1, End: 500, Count: 4}, {Start: 1001, End: 1500, Count: 1}}},
+		// the empty stored bins make the min 0
+		{StatMin, []*ReadBin{{Start: 1001, End: 1500, Count: 1}}},
+		{StatCoverage, []*ReadBin{{Start: 1, End: 500, Count: 0.4}, {Start: 1001, End: 1500, Count: 1}}},
+		{StatStd, []*ReadBin{{Start: 1, End: 500, Count: math.Sqrt(20.0/5 - 1.2*1.2)}}},
+	}
+
+	for _, test := range tests {
+		if got := resampleBins(runs, 100, location, 500, 0, test.stat); !sameBins(got, test.want) {
+			t.Errorf("%s bins %v, want %v", test.stat, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package seqs
+
+import (
+	"database/sql"
+	"errors"
+	"io/fs"
+
+	"github.com/antonybholmes/go-seqs/bam"
+	"github.com/antonybholmes/go-seqs/bigwig"
+)
+
+const (
+	SampleStatusOk                 = "ok"
+	SampleStatusForbidden          = "forbidden"
+	SampleStatusNotFound           = "not_found"
+	SampleStatusUnsupportedBinSize = "unsupported_bin_size"
+	SampleStatusReadError          = "read_error"
+	SampleStatusOutOfRange         = "out_of_range"
+)
+
+var (
+	ErrSampleNotFound     = errors.New("sample not found")
+	ErrDatasetNotFound    = errors.New("dataset not found")
+	ErrPermissionDenied   = errors.New("permission denied")
+	ErrUnsupportedBinSize = errors.New("bin size not available for sample")
+	ErrChrNotInSample     = errors.New("chromosome not in sample")
+)
+
+// SampleStatus maps an error from reading a sample to the status
+// reported to clients.
+func SampleStatus(err error) string {
+	switch {
+	case err == nil:
+		return SampleStatusOk
+	case errors.Is(err, ErrPermissionDenied):
+		return SampleStatusForbidden
+	case errors.Is(err, ErrUnsupportedBinSize):
+		return SampleStatusUnsupportedBinSize
+	case errors.Is(err, ErrLocationOutOfRange):
+		return SampleStatusOutOfRange
+	case errors.Is(err, ErrSampleNotFound),
+		errors.Is(err, sql.ErrNoRows),
+		errors.Is(err, fs.ErrNotExist),
+		errors.Is(err, ErrChrNotInSample),
+		errors.Is(err, bigwig.ErrChrNotFound),
+		errors.Is(err, bam.ErrChrNotFound):
+		return SampleStatusNotFound
+	default:
+		return SampleStatusReadError
+	}
+}
+
+// NewSampleBinCountsError is the placeholder returned for a sample that
+// could not be read at all, for example because the user cannot view it.
+func NewSampleBinCountsError(id string, binSize int, err error) *SampleBinCounts {
+	ret := SampleBinCounts{
+		Id:      id,
+		Bins:    []*ReadBin{},
+		BinSize: binSize,
+		Norm:    NormRaw,
+	}
+
+	ret.SetError(err)
+
+	return &ret
+}
+
+// SetError records why a sample has no, or only partial, data.
+func (counts *SampleBinCounts) SetError(err error) {
+	counts.Status = SampleStatus(err)
+
+	if err != nil {
+		counts.Message = err.Error()
+	} else {
+		counts.Message = ""
+	}
+}